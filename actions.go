@@ -0,0 +1,56 @@
+package onkyo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+)
+
+// RunCommand runs a command with the given variables added to its environment, returning its
+// output along with the error if it fails.
+func RunCommand(ctx context.Context, command []string, env ...string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("No command given")
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+
+	if output, err := cmd.CombinedOutput(); err == nil {
+		log.Debugf("%s: %s", command[0], output)
+		return nil
+	} else {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(output))
+	}
+}
+
+// PostJSON POSTs the given value to a URL as JSON, treating any non-2xx status as an error.
+func PostJSON(ctx context.Context, url string, value interface{}) error {
+	body, err := json.Marshal(value)
+
+	if err != nil {
+		return err
+	}
+
+	if request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body)); err == nil {
+		request.Header.Set(`Content-Type`, `application/json`)
+
+		if response, err := http.DefaultClient.Do(request); err == nil {
+			response.Body.Close()
+
+			if response.StatusCode < 200 || response.StatusCode >= 300 {
+				return fmt.Errorf("%s returned %s", url, response.Status)
+			}
+
+			return nil
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
@@ -0,0 +1,195 @@
+package onkyo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"sync"
+
+	_ "golang.org/x/image/bmp"
+)
+
+const (
+	albumArtBMP   = '0'
+	albumArtJPEG  = '1'
+	albumArtURL   = '2'
+	albumArtNone  = 'n'
+	albumArtStart = '0'
+	albumArtNext  = '1'
+	albumArtEnd   = '2'
+)
+
+// AlbumArt is a complete piece of jacket art received from the device, either as image data
+// or as a URL the image can be retrieved from.
+type AlbumArt struct {
+	ContentType string
+	Data        []byte
+	URL         string
+}
+
+// Image decodes the image data.
+func (self *AlbumArt) Image() (image.Image, error) {
+	if len(self.Data) == 0 {
+		return nil, fmt.Errorf("Album art has no image data")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(self.Data))
+	return img, err
+}
+
+// AlbumArtDecoder reassembles album art from the sequence of NJA messages it is sent in.
+type AlbumArtDecoder struct {
+	lock      sync.RWMutex
+	buffer    bytes.Buffer
+	imageType byte
+	receiving bool
+	current   *AlbumArt
+	updates   chan *AlbumArt
+}
+
+func NewAlbumArtDecoder() *AlbumArtDecoder {
+	return &AlbumArtDecoder{
+		updates: make(chan *AlbumArt, 1),
+	}
+}
+
+// Current returns the most recently completed album art, or nil if there is none.
+func (self *AlbumArtDecoder) Current() *AlbumArt {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.current
+}
+
+// Updates returns a channel that receives album art as each image is completed (or nil when
+// the device reports there is no image).
+func (self *AlbumArtDecoder) Updates() <-chan *AlbumArt {
+	return self.updates
+}
+
+// Watch decodes album art from every NJA message received from the device until the
+// connection is closed.
+func (self *AlbumArtDecoder) Watch(device *Device) {
+	sub := device.Subscribe()
+
+	go func() {
+		for message := range sub {
+			if _, err := self.Update(message); err != nil {
+				log.Warningf("Album art: %v", err)
+			}
+		}
+	}()
+}
+
+// Update applies an NJA message, returning whether it completed a new image.
+func (self *AlbumArtDecoder) Update(message Message) (bool, error) {
+	if message.Code() != `NJA` {
+		return false, nil
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	value := message.Value()
+
+	if len(value) < 2 {
+		if value == string(albumArtNone) {
+			self.complete(nil)
+			return true, nil
+		}
+
+		return false, fmt.Errorf("Malformed album art message %q", value)
+	}
+
+	imageType, flag, data := value[0], value[1], value[2:]
+
+	switch imageType {
+	case albumArtNone:
+		self.complete(nil)
+		return true, nil
+
+	case albumArtURL:
+		self.complete(&AlbumArt{
+			URL: data,
+		})
+		return true, nil
+
+	case albumArtBMP, albumArtJPEG:
+		chunk, err := hex.DecodeString(data)
+
+		if err != nil {
+			self.reset()
+			return false, fmt.Errorf("Invalid album art data: %v", err)
+		}
+
+		switch flag {
+		case albumArtStart:
+			if self.receiving {
+				log.Debugf("Album art restarted before the previous image was complete")
+			}
+
+			self.reset()
+			self.receiving = true
+			self.imageType = imageType
+
+		case albumArtNext, albumArtEnd:
+			if !self.receiving {
+				return false, fmt.Errorf("Received album art data without a start packet")
+			} else if imageType != self.imageType {
+				self.reset()
+				return false, fmt.Errorf("Album art image type changed mid-image")
+			}
+
+		default:
+			self.reset()
+			return false, fmt.Errorf("Unknown album art packet flag %q", flag)
+		}
+
+		self.buffer.Write(chunk)
+
+		if flag == albumArtEnd {
+			art := &AlbumArt{
+				ContentType: `image/jpeg`,
+				Data:        make([]byte, self.buffer.Len()),
+			}
+
+			if self.imageType == albumArtBMP {
+				art.ContentType = `image/bmp`
+			}
+
+			copy(art.Data, self.buffer.Bytes())
+
+			self.reset()
+			self.complete(art)
+			return true, nil
+		}
+
+		return false, nil
+
+	default:
+		return false, fmt.Errorf("Unknown album art image type %q", imageType)
+	}
+}
+
+func (self *AlbumArtDecoder) reset() {
+	self.buffer.Reset()
+	self.receiving = false
+}
+
+func (self *AlbumArtDecoder) complete(art *AlbumArt) {
+	self.current = art
+
+	for {
+		select {
+		case self.updates <- art:
+			return
+		default:
+			select {
+			case <-self.updates:
+			default:
+			}
+		}
+	}
+}
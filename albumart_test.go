@@ -0,0 +1,101 @@
+package onkyo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAlbumArtDecoder(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []Message
+		errors   int
+		art      *AlbumArt
+	}{
+		{
+			name:     `jpeg in three packets`,
+			messages: []Message{`!1NJA10FFD8`, `!1NJA1101020304`, `!1NJA12FFD9`},
+			art:      &AlbumArt{ContentType: `image/jpeg`, Data: []byte{0xff, 0xd8, 0x01, 0x02, 0x03, 0x04, 0xff, 0xd9}},
+		}, {
+			name:     `bmp in a start and end packet`,
+			messages: []Message{`!1NJA00424D`, `!1NJA020A0B`},
+			art:      &AlbumArt{ContentType: `image/bmp`, Data: []byte{0x42, 0x4d, 0x0a, 0x0b}},
+		}, {
+			name:     `url`,
+			messages: []Message{`!1NJA2-http://192.168.1.20/album_art.cgi`},
+			art:      &AlbumArt{URL: `http://192.168.1.20/album_art.cgi`},
+		}, {
+			name:     `restart discards the partial image`,
+			messages: []Message{`!1NJA10AAAA`, `!1NJA10FFD8`, `!1NJA12FFD9`},
+			art:      &AlbumArt{ContentType: `image/jpeg`, Data: []byte{0xff, 0xd8, 0xff, 0xd9}},
+		}, {
+			name:     `data without a start packet`,
+			messages: []Message{`!1NJA11AAAA`, `!1NJA12FFD9`},
+			errors:   2,
+		}, {
+			name:     `image type changes mid-image`,
+			messages: []Message{`!1NJA10FFD8`, `!1NJA02FFD9`},
+			errors:   1,
+		}, {
+			name:     `invalid hex abandons the image`,
+			messages: []Message{`!1NJA10FFD8`, `!1NJA11XYZ`, `!1NJA12FFD9`},
+			errors:   2,
+		}, {
+			name:     `unknown flag`,
+			messages: []Message{`!1NJA10FFD8`, `!1NJA13FFD9`},
+			errors:   1,
+		}, {
+			name:     `no image`,
+			messages: []Message{`!1NJA10FFD8`, `!1NJA12FFD9`, `!1NJAn-`},
+		}, {
+			name:     `no image (short form)`,
+			messages: []Message{`!1NJA2-http://192.168.1.20/album_art.cgi`, `!1NJAn`},
+		}, {
+			name:     `other messages are ignored`,
+			messages: []Message{`!1NJA10FFD8`, `!1NLTF300`, `!1NJA12FFD9`},
+			art:      &AlbumArt{ContentType: `image/jpeg`, Data: []byte{0xff, 0xd8, 0xff, 0xd9}},
+		},
+	}
+
+	for _, test := range tests {
+		decoder := NewAlbumArtDecoder()
+		errors := 0
+
+		for _, message := range test.messages {
+			if _, err := decoder.Update(message); err != nil {
+				errors += 1
+			}
+		}
+
+		if errors != test.errors {
+			t.Errorf("%s: expected %d errors, got %d", test.name, test.errors, errors)
+		}
+
+		if art := decoder.Current(); !reflect.DeepEqual(art, test.art) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.art, art)
+		}
+	}
+}
+
+func TestAlbumArtDecoderUpdates(t *testing.T) {
+	decoder := NewAlbumArtDecoder()
+
+	for _, message := range []Message{`!1NJA10FFD8`, `!1NJA12FFD9`, `!1NJA2-http://192.168.1.20/album_art.cgi`} {
+		if completed, err := decoder.Update(message); err != nil {
+			t.Fatal(err)
+		} else if completed != (message.Value()[1] != '0') {
+			t.Errorf("%s: unexpected completion state %v", message, completed)
+		}
+	}
+
+	// only the latest image is kept for a slow receiver
+	if art := <-decoder.Updates(); art.URL != `http://192.168.1.20/album_art.cgi` {
+		t.Errorf("expected the URL to be the latest update, got %+v", art)
+	}
+
+	select {
+	case art := <-decoder.Updates():
+		t.Errorf("expected a single update, also got %+v", art)
+	default:
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+// restoreOrder lists the settings that are restored from a backup, in the order they're
+// applied: outputs and speakers first (since they determine which of the others apply), then
+// the listening mode (since levels and tone are kept per mode), then everything else.  The
+// rest of a backup (power, volume, inputs, tuner and metadata) is state rather than setup.
+var restoreOrder = []string{
+	`SPL`, `HDO`, `HAO`, `RES`, `ISF`, `VWM`, `VPM`,
+	`LMD`,
+	`ADY`, `ADQ`, `ADV`, `DVL`, `MOT`, `LTN`, `RAS`,
+	`SWL`, `CTL`,
+	`TFR`, `TFW`, `TFH`, `TCT`, `TSR`, `TSB`, `TSW`,
+	`DIM`, `DIF`,
+	`ZTN`, `ZBL`, `LTZ`, `RAZ`,
+	`TN3`, `BL3`,
+}
+
+// toneValue matches the combined bass and treble values reported by the tone commands, which
+// have to be set separately.
+var toneValue = regexp.MustCompile(`^(B[-+0][0-9A-F])(T[-+0][0-9A-F])$`)
+
+// Backup is a snapshot of every value a device reported.
+type Backup struct {
+	Model      string            `json:"model"`
+	Identifier string            `json:"identifier,omitempty"`
+	Created    time.Time         `json:"created"`
+	Settings   []*DecodedMessage `json:"settings"`
+}
+
+// Change is a setting that differs between a backup and the device.
+type Change struct {
+	Code    string
+	Name    string
+	Current string
+	Backup  string
+}
+
+// backupDevice queries every queryable command in the catalog, recording those the device
+// supports.
+func backupDevice(target *Target) (*Backup, error) {
+	info := target.Device.Info()
+	backup := &Backup{
+		Model:      info.Model,
+		Identifier: info.Identifier,
+		Created:    time.Now(),
+		Settings:   make([]*DecodedMessage, 0),
+	}
+
+	seen := make(map[string]bool)
+
+	for _, zone := range Zones() {
+		for _, cmd := range zoneToCmds[zone] {
+			if !cmd.Queryable() || seen[cmd.Code] {
+				continue
+			}
+
+			seen[cmd.Code] = true
+
+			if message, err := target.Device.Query(target.Timeout, cmd.Code); err == nil {
+				if message.Value() == `` {
+					log.Debugf("%s: not available", cmd.Code)
+					continue
+				}
+
+				backup.Settings = append(backup.Settings, DecodeMessage(message))
+				log.Infof("%s (%s): %s", cmd.Code, cmd.Name, message.Value())
+			} else if err == onkyo.ErrResponseTimeout {
+				log.Debugf("%s: no reply", cmd.Code)
+			} else {
+				return nil, fmt.Errorf("Failed to query %s: %v", cmd.Code, err)
+			}
+		}
+	}
+
+	if len(backup.Settings) == 0 {
+		return nil, fmt.Errorf("The device did not report any settings")
+	}
+
+	return backup, nil
+}
+
+func loadBackup(filename string) (*Backup, error) {
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+
+		backup := &Backup{}
+
+		if err := json.NewDecoder(file).Decode(backup); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %v", filename, err)
+		}
+
+		return backup, nil
+	} else {
+		return nil, err
+	}
+}
+
+// restoreChanges compares the restorable settings in a backup with the device, returning
+// those that differ in the order they should be applied.
+func restoreChanges(target *Target, backup *Backup) ([]*Change, error) {
+	saved := make(map[string]*DecodedMessage)
+
+	for _, setting := range backup.Settings {
+		saved[setting.Code] = setting
+	}
+
+	changes := make([]*Change, 0)
+
+	for _, code := range restoreOrder {
+		setting, ok := saved[code]
+
+		if !ok {
+			continue
+		}
+
+		if message, err := target.Device.Query(target.Timeout, code); err == nil {
+			if current := message.Value(); current != setting.Value {
+				changes = append(changes, &Change{
+					Code:    code,
+					Name:    setting.Name,
+					Current: current,
+					Backup:  setting.Value,
+				})
+			}
+		} else if err == onkyo.ErrResponseTimeout {
+			log.Warningf("%s: no reply, not restoring it", code)
+		} else {
+			return nil, fmt.Errorf("Failed to query %s: %v", code, err)
+		}
+	}
+
+	return changes, nil
+}
+
+// applyChange sets a value from a backup and verifies that the device reports it afterwards.
+func applyChange(target *Target, change *Change) error {
+	params := []string{change.Backup}
+
+	if parts := toneValue.FindStringSubmatch(change.Backup); parts != nil {
+		params = parts[1:]
+	}
+
+	for _, param := range params {
+		if err := callCommand(target, change.Code, param, []string{param}); err != nil {
+			return fmt.Errorf("%s: %v", change.Code, err)
+		}
+	}
+
+	if message, err := target.Device.Query(target.Timeout, change.Code); err == nil {
+		if message.Value() != change.Backup {
+			return fmt.Errorf("%s: the device reports %q rather than %q", change.Code, message.Value(), change.Backup)
+		}
+
+		return nil
+	} else {
+		return fmt.Errorf("%s: failed to verify: %v", change.Code, err)
+	}
+}
+
+func printChanges(w io.Writer, changes []*Change) {
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s -> %s\n", change.Code, change.Name, change.Current, change.Backup)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+// settingsReceiver emulates a device that reports and stores settings.  Tone commands are set
+// one part at a time but reported together, and frozen settings ignore any attempt to change
+// them.  Settings it doesn't have aren't replied to.
+type settingsReceiver struct {
+	state  map[string]string
+	frozen map[string]bool
+	sets   []string
+	lock   sync.Mutex
+}
+
+func (self *settingsReceiver) serve(receiver onkyo.Transport) {
+	for {
+		message, err := receiver.ReadFrame()
+
+		if err != nil {
+			return
+		}
+
+		code, value := message.Code(), message.Value()
+
+		self.lock.Lock()
+		current, ok := self.state[code]
+
+		if ok && value != `QSTN` {
+			self.sets = append(self.sets, code+value)
+
+			if !self.frozen[code] {
+				if toneValue.MatchString(current) && strings.HasPrefix(value, `B`) {
+					current = value + current[3:]
+				} else if toneValue.MatchString(current) && strings.HasPrefix(value, `T`) {
+					current = current[:3] + value
+				} else {
+					current = value
+				}
+
+				self.state[code] = current
+			}
+		}
+
+		self.lock.Unlock()
+
+		if ok {
+			receiver.WriteFrame(onkyo.Message(`!1` + code + current))
+		}
+	}
+}
+
+func (self *settingsReceiver) get(code string) string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.state[code]
+}
+
+func (self *settingsReceiver) sent() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	sets := self.sets
+	self.sets = nil
+
+	return sets
+}
+
+func newSettingsTarget(t *testing.T, state map[string]string, frozen ...string) (*Target, *settingsReceiver) {
+	device, receiver := newTestDevice(t)
+	settings := &settingsReceiver{
+		state:  state,
+		frozen: make(map[string]bool),
+	}
+
+	for _, code := range frozen {
+		settings.frozen[code] = true
+	}
+
+	go settings.serve(receiver)
+
+	return newTarget(`test`, device, 100*time.Millisecond, nil), settings
+}
+
+func TestRestoreChanges(t *testing.T) {
+	target, _ := newSettingsTarget(t, map[string]string{
+		`PWR`: `01`,
+		`MVL`: `20`,
+		`SPL`: `SB`,
+		`LMD`: `00`,
+		`TFR`: `B+0T+0`,
+		`DIM`: `00`,
+	})
+
+	// in a different order to restoreOrder, with state that isn't restored and a setting the
+	// device doesn't reply to
+	backup := &Backup{
+		Model: `TX-NR626`,
+		Settings: []*DecodedMessage{
+			{Code: `DIM`, Value: `01`},
+			{Code: `PWR`, Value: `00`},
+			{Code: `TFR`, Value: `B+3T-2`},
+			{Code: `MVL`, Value: `40`},
+			{Code: `SWL`, Value: `+2`},
+			{Code: `SPL`, Value: `SB`},
+			{Code: `LMD`, Value: `0C`},
+		},
+	}
+
+	changes, err := restoreChanges(target, backup)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*Change{
+		{Code: `LMD`, Current: `00`, Backup: `0C`},
+		{Code: `TFR`, Current: `B+0T+0`, Backup: `B+3T-2`},
+		{Code: `DIM`, Current: `00`, Backup: `01`},
+	}
+
+	if !reflect.DeepEqual(changes, expected) {
+		for i := range changes {
+			t.Logf("%d: %+v", i, changes[i])
+		}
+
+		t.Errorf("expected %d changes in restore order", len(expected))
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+	tests := []struct {
+		change *Change
+		state  string
+		sets   []string
+		err    string
+	}{
+		{
+			change: &Change{Code: `DIM`, Current: `00`, Backup: `01`},
+			state:  `01`,
+			sets:   []string{`DIM01`},
+		}, {
+			// bass and treble are set separately, then verified together
+			change: &Change{Code: `TFR`, Current: `B+0T+0`, Backup: `B+3T-2`},
+			state:  `B+3T-2`,
+			sets:   []string{`TFRB+3`, `TFRT-2`},
+		}, {
+			change: &Change{Code: `TCT`, Current: `B-2T00`, Backup: `B00T+A`},
+			state:  `B00T+A`,
+			sets:   []string{`TCTB00`, `TCTT+A`},
+		}, {
+			change: &Change{Code: `LMD`, Current: `00`, Backup: `0C`},
+			state:  `00`,
+			sets:   []string{`LMD0C`},
+			err:    `LMD: the device reports "00" rather than "0C"`,
+		},
+	}
+
+	for _, test := range tests {
+		target, settings := newSettingsTarget(t, map[string]string{
+			test.change.Code: test.change.Current,
+		}, `LMD`)
+
+		err := applyChange(target, test.change)
+
+		if test.err != `` {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got %v", test.change.Code, test.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", test.change.Code, err)
+		}
+
+		if sets := settings.sent(); !reflect.DeepEqual(sets, test.sets) {
+			t.Errorf("%s: expected %v to be sent, got %v", test.change.Code, test.sets, sets)
+		}
+
+		if state := settings.get(test.change.Code); state != test.state {
+			t.Errorf("%s: expected the device to have %s, got %s", test.change.Code, test.state, state)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+const browseRedrawDelay = time.Duration(150) * time.Millisecond
+const browseHelp = `[0-9] select line, (b)ack, (t)op, (n)ext page, (p)revious page, (r)efresh, (q)uit`
+
+// browse interactively navigates the NET/USB list of the device, optionally switching to the
+// given input or network service first.
+func browse(device *onkyo.Device, source string) error {
+	browser := onkyo.NewNetBrowser(device)
+	defer browser.Close()
+
+	if source != `` {
+		if err := selectSource(device, browser, source); err != nil {
+			return err
+		}
+	}
+
+	if err := browser.Refresh(); err != nil {
+		return err
+	}
+
+	input := make(chan string)
+
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+
+		for scanner.Scan() {
+			input <- strings.TrimSpace(scanner.Text())
+		}
+
+		close(input)
+	}()
+
+	fmt.Println(browseHelp)
+
+	var pending onkyo.NetMenu
+	var redraw <-chan time.Time
+
+	for {
+		select {
+		case menu, ok := <-browser.Updates():
+			if !ok {
+				return fmt.Errorf("Lost connection to device")
+			}
+
+			// lines arrive one message at a time, so wait for the page to settle before drawing
+			pending = menu
+
+			if redraw == nil {
+				redraw = time.After(browseRedrawDelay)
+			}
+
+		case <-redraw:
+			printMenu(pending)
+			redraw = nil
+
+		case line, ok := <-input:
+			if !ok {
+				return nil
+			}
+
+			var err error
+
+			switch line {
+			case ``:
+				continue
+			case `q`, `quit`:
+				return nil
+			case `b`, `back`:
+				err = browser.Back()
+			case `t`, `top`:
+				err = browser.Top()
+			case `n`, `next`:
+				err = browser.PageDown()
+			case `p`, `prev`:
+				err = browser.PageUp()
+			case `r`, `refresh`:
+				printMenu(browser.Menu())
+				err = browser.Refresh()
+			case `?`, `h`, `help`:
+				fmt.Println(browseHelp)
+			default:
+				if n, convErr := strconv.Atoi(line); convErr == nil {
+					err = browser.Select(n)
+				} else {
+					err = fmt.Errorf("Unknown command %q", line)
+				}
+			}
+
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// selectSource switches to an input (e.g.: "dlna", "internet-radio") if the name is a known
+// input selector value, or to a network service (e.g.: "favorites", "tunein") otherwise.
+func selectSource(device *onkyo.Device, browser *onkyo.NetBrowser, source string) error {
+	if cmd, err := FindCommand(`main`, `SLI`); err == nil {
+		if param, err := primaryTarget().Aliases.Encode(cmd, source); err == nil {
+			return device.Send(cmd.Code, param)
+		}
+	}
+
+	return browser.SelectService(source)
+}
+
+func printMenu(menu onkyo.NetMenu) {
+	title := menu.Title
+
+	if menu.Service != `` {
+		title = fmt.Sprintf("%s [%s]", title, menu.Service)
+	}
+
+	fmt.Printf("\n== %s ==\n", title)
+
+	for i, line := range menu.Lines {
+		if line == `` {
+			continue
+		}
+
+		cursor := ` `
+
+		if i == menu.Cursor {
+			cursor = `>`
+		}
+
+		fmt.Printf("%s %d  %s\n", cursor, i, line)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+const DEFAULT_PROBE_CONCURRENCY = 4
+
+// Support describes how a device responded when asked for the value of a command.
+type Support string
+
+const (
+	Supported    Support = `supported`
+	NotAvailable Support = `n/a`
+	NoReply      Support = `timeout`
+)
+
+// ModelCapabilities records which commands a model supports, as determined by probing it.
+type ModelCapabilities struct {
+	Probed   time.Time          `json:"probed"`
+	Commands map[string]Support `json:"commands"`
+}
+
+// Supports returns whether the model supports a command.  Commands that can't be queried
+// (and so can't be probed) are assumed to be supported.
+func (self *ModelCapabilities) Supports(cmd *CommandInfo) bool {
+	if self == nil || !cmd.Queryable() {
+		return true
+	}
+
+	return self.Commands[cmd.Code] == Supported
+}
+
+// Capabilities holds the probed capabilities of each model, keyed by model name.
+type Capabilities map[string]*ModelCapabilities
+
+func defaultCapabilitiesFile() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, `onkyo-remote`, `capabilities.json`)
+	}
+
+	return `capabilities.json`
+}
+
+// LoadCapabilities reads a capabilities file.  A missing file is the same as an empty one.
+func LoadCapabilities(filename string) (Capabilities, error) {
+	caps := make(Capabilities)
+
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+
+		if err := json.NewDecoder(file).Decode(&caps); err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %v", filename, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return caps, nil
+}
+
+func (self Capabilities) Save(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	if data, err := json.MarshalIndent(self, ``, `  `); err == nil {
+		return os.WriteFile(filename, append(data, '\n'), 0644)
+	} else {
+		return err
+	}
+}
+
+// supported returns whether a command is offered for the target's device.
+func supported(target *Target, cmd *CommandInfo) bool {
+	return target.Capabilities.Supports(cmd)
+}
+
+// probeDevice queries every queryable command in the catalog, with at most concurrency
+// queries outstanding at once, and records how the device responded to each.
+func probeDevice(target *Target, concurrency int) (*ModelCapabilities, error) {
+	if concurrency <= 0 {
+		concurrency = DEFAULT_PROBE_CONCURRENCY
+	}
+
+	caps := &ModelCapabilities{
+		Probed:   time.Now(),
+		Commands: make(map[string]Support),
+	}
+
+	codes := make(chan string)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var failure error
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for code := range codes {
+				support := Supported
+
+				if message, err := target.Device.Query(target.Timeout, code); err == nil {
+					if message.Value() == `` {
+						support = NotAvailable
+					}
+				} else if err == onkyo.ErrResponseTimeout {
+					support = NoReply
+				} else {
+					lock.Lock()
+					failure = fmt.Errorf("Failed to query %s: %v", code, err)
+					lock.Unlock()
+					continue
+				}
+
+				log.Debugf("%s: %s", code, support)
+
+				lock.Lock()
+				caps.Commands[code] = support
+				lock.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool)
+
+	for _, zone := range Zones() {
+		for _, cmd := range zoneToCmds[zone] {
+			if cmd.Queryable() && !seen[cmd.Code] {
+				seen[cmd.Code] = true
+				codes <- cmd.Code
+			}
+		}
+	}
+
+	close(codes)
+	wg.Wait()
+
+	return caps, failure
+}
+
+// useCapabilities limits the commands offered for each target to those its model is known
+// to support, if it has been probed.
+func useCapabilities(filename string, targets []*Target) error {
+	if caps, err := LoadCapabilities(filename); err == nil {
+		for _, target := range targets {
+			if model := target.Device.Info().Model; model != `` {
+				if modelCaps, ok := caps[model]; ok {
+					target.Capabilities = modelCaps
+					log.Debugf("Using the capabilities of %s probed at %v for %s", model, modelCaps.Probed, target.Name)
+				}
+			}
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+func TestProbeDevice(t *testing.T) {
+	device, receiver := newTestDevice(t)
+
+	// everything else is reported as not available
+	replies := map[string]onkyo.Message{
+		`PWR`: `!1PWR01`,
+		`MVL`: `!1MVL2A`,
+		`AMT`: ``,
+	}
+
+	go func() {
+		for {
+			if message, err := receiver.ReadFrame(); err == nil {
+				reply, ok := replies[message.Code()]
+
+				if !ok {
+					reply = onkyo.Message(`!1` + message.Code() + `N/A`)
+				}
+
+				if reply != `` {
+					receiver.WriteFrame(reply)
+				}
+			} else {
+				return
+			}
+		}
+	}()
+
+	caps, err := probeDevice(&Target{Device: device, Timeout: 100 * time.Millisecond}, 8)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queryable := make(map[string]bool)
+
+	for i := range AllKnownCommands {
+		if AllKnownCommands[i].Queryable() {
+			queryable[AllKnownCommands[i].Code] = true
+		}
+	}
+
+	if len(caps.Commands) != len(queryable) {
+		t.Errorf("expected every queryable command (%d) to be probed, got %d", len(queryable), len(caps.Commands))
+	}
+
+	for code, expected := range map[string]Support{
+		`PWR`: Supported,
+		`MVL`: Supported,
+		`AMT`: NoReply,
+		`SLI`: NotAvailable,
+		`LMD`: NotAvailable,
+	} {
+		if support := caps.Commands[code]; support != expected {
+			t.Errorf("%s: expected %s, got %s", code, expected, support)
+		}
+	}
+
+	if caps.Probed.IsZero() {
+		t.Errorf("expected the probe time to be recorded")
+	}
+
+	// the target only offers what its model supports
+	target := &Target{Device: device, Capabilities: caps}
+	power, _ := FindCommand(`main`, `PWR`)
+	input, _ := FindCommand(`main`, `SLI`)
+
+	if !supported(target, power) || supported(target, input) {
+		t.Errorf("expected %s to be offered and %s not to be", power.Code, input.Code)
+	} else if !supported(&Target{Device: device}, input) {
+		t.Errorf("expected an unprobed target to offer every command")
+	}
+
+	for i := range AllKnownCommands {
+		if cmd := &AllKnownCommands[i]; !cmd.Queryable() {
+			if !supported(target, cmd) {
+				t.Errorf("expected %s, which can't be probed, to be offered", cmd.Code)
+			}
+
+			break
+		}
+	}
+}
+
+func TestCapabilitiesLoadSave(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), `onkyo-remote`, `capabilities.json`)
+
+	// a missing file is the same as an empty one
+	if caps, err := LoadCapabilities(filename); err != nil {
+		t.Fatal(err)
+	} else if len(caps) != 0 {
+		t.Errorf("expected no capabilities, got %v", caps)
+	}
+
+	saved := Capabilities{
+		`TX-NR626`: {
+			Probed:   time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+			Commands: map[string]Support{`PWR`: Supported, `SLI`: NotAvailable, `AMT`: NoReply},
+		},
+		`TX-8050`: {
+			Probed:   time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC),
+			Commands: map[string]Support{`PWR`: Supported},
+		},
+	}
+
+	if err := saved.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	if caps, err := LoadCapabilities(filename); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(caps, saved) {
+		t.Errorf("expected %+v, got %+v", saved, caps)
+	}
+
+	if err := os.WriteFile(filename, []byte(`{"TX-NR626":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadCapabilities(filename); err == nil || !strings.HasPrefix(err.Error(), `Failed to parse`) {
+		t.Errorf("expected a parse error, got %v", err)
+	}
+}
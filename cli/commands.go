@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/ghetzel/onkyo-remote"
 )
@@ -17,11 +19,11 @@ const (
 )
 
 type Value struct {
-	Data        string
-	Code        string
-	Name        string
-	Description string
-	Type        ValueType
+	Data        string    `json:"-"`
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Type        ValueType `json:"type,omitempty"`
 }
 
 func (self *Value) String() string {
@@ -38,20 +40,338 @@ func (self *Value) String() string {
 }
 
 type CommandInfo struct {
-	Zone        string
-	Code        string
-	Name        string
-	Description string
-	Values      []Value
+	Zone        string  `json:"zone"`
+	Code        string  `json:"code"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Values      []Value `json:"values"`
+}
+
+// ZoneControls identifies the commands used for the common controls in a zone.
+type ZoneControls struct {
+	Power         string
+	Volume        string
+	Mute          string
+	Input         string
+	ListeningMode string
+}
+
+var zoneControls = map[string]ZoneControls{
+	`main`:  {Power: `PWR`, Volume: `MVL`, Mute: `AMT`, Input: `SLI`, ListeningMode: `LMD`},
+	`zone2`: {Power: `ZPW`, Volume: `ZVL`, Mute: `ZMT`, Input: `SLZ`, ListeningMode: `LMZ`},
+	`zone3`: {Power: `PW3`, Volume: `VL3`, Mute: `MT3`, Input: `SL3`},
+	`zone4`: {Power: `PW4`, Volume: `VL4`, Mute: `MT4`, Input: `SL4`},
+}
+
+func (self ZoneControls) Codes() []string {
+	codes := make([]string, 0)
+
+	for _, code := range []string{self.Power, self.Volume, self.Mute, self.Input, self.ListeningMode} {
+		if code != `` {
+			codes = append(codes, code)
+		}
+	}
+
+	return codes
+}
+
+const DEFAULT_ZONE = `main`
+
+// ValueAliases are additional names for values, keyed by command code and then by name.
+type ValueAliases map[string]map[string]string
+
+// Encode converts a value name into the parameter string that should be sent to the device,
+// checking the aliases before the catalog.
+func (self ValueAliases) Encode(cmd *CommandInfo, arg string) (string, error) {
+	if code, ok := self[cmd.Code][strings.ToLower(arg)]; ok {
+		return code, nil
+	}
+
+	return cmd.EncodeValue(arg)
+}
+
+// Name returns the alias for a value of the given command code, if there is one.
+func (self ValueAliases) Name(code string, value string) (string, bool) {
+	for alias, aliased := range self[code] {
+		if aliased == value {
+			return alias, true
+		}
+	}
+
+	return ``, false
 }
 
 var codeToCmd = map[string]*CommandInfo{}
+var zoneToCmds = map[string][]*CommandInfo{}
+var rangeValuePattern = regexp.MustCompile(`^\((\d+), (\d+)\)$`)
+var valuePatterns = map[string]*regexp.Regexp{}
+var valuePatternsLock sync.Mutex
 
 func initCommands() {
 	for i := range AllKnownCommands {
 		cmd := &AllKnownCommands[i]
-		codeToCmd[cmd.Code] = cmd
+
+		// some codes appear in several zones; prefer the first (main zone) entry
+		if _, ok := codeToCmd[cmd.Code]; !ok {
+			codeToCmd[cmd.Code] = cmd
+		}
+
+		zoneToCmds[cmd.Zone] = append(zoneToCmds[cmd.Zone], cmd)
+	}
+}
+
+func Zones() []string {
+	zones := make([]string, 0)
+
+	for zone, _ := range zoneToCmds {
+		zones = append(zones, zone)
+	}
+
+	sort.Strings(zones)
+	return zones
+}
+
+// FindCommand locates a command in the given zone by its name or code.
+func FindCommand(zone string, name string) (*CommandInfo, error) {
+	if cmds, ok := zoneToCmds[zone]; ok {
+		for _, cmd := range cmds {
+			if strings.EqualFold(cmd.Name, name) || strings.EqualFold(cmd.Code, name) {
+				return cmd, nil
+			}
+		}
+
+		return nil, fmt.Errorf("Command %q not found in zone %q", name, zone)
+	} else {
+		return nil, fmt.Errorf("Unknown zone %q", zone)
+	}
+}
+
+// Names returns all of the aliases a value is known by.  Several values in the catalog are
+// listed under more than one name (e.g.: "('video2', 'cbl', 'sat')").
+func (self *Value) Names() []string {
+	names := make([]string, 0)
+
+	for _, name := range strings.Split(strings.Trim(self.Name, `()`), `,`) {
+		name = strings.Trim(strings.TrimSpace(name), `'`)
+
+		switch name {
+		case ``, `None`:
+			continue
+		default:
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Literal returns whether the value's code is a fixed string (as opposed to a pattern).
+func (self *Value) Literal() bool {
+	return regexp.QuoteMeta(self.Code) == self.Code && !strings.Contains(self.Code, `{`)
+}
+
+func (self *Value) numericRange() (int64, int64, bool) {
+	if match := rangeValuePattern.FindStringSubmatch(self.Code); match != nil {
+		min, _ := strconv.ParseInt(match[1], 10, 32)
+		max, _ := strconv.ParseInt(match[2], 10, 32)
+		return min, max, true
+	}
+
+	return 0, 0, false
+}
+
+func (self *Value) pattern() *regexp.Regexp {
+	valuePatternsLock.Lock()
+	defer valuePatternsLock.Unlock()
+
+	if rx, ok := valuePatterns[self.Code]; ok {
+		return rx
+	}
+
+	rx, err := regexp.Compile(`^` + self.Code + `$`)
+
+	if err != nil {
+		log.Debugf("Value pattern %q is not a valid expression: %v", self.Code, err)
+		rx = nil
+	}
+
+	valuePatterns[self.Code] = rx
+	return rx
+}
+
+// Match returns whether the given raw value is described by this catalog value.  If it is,
+// a human-readable interpretation of the value is also returned (which may be empty).
+func (self *Value) Match(raw string) (string, bool) {
+	if min, max, ok := self.numericRange(); ok {
+		if v, err := strconv.ParseInt(raw, 16, 32); err == nil && v >= min && v <= max {
+			return fmt.Sprintf("%d", v), true
+		}
+
+		return ``, false
+	}
+
+	if self.Literal() {
+		if raw != self.Code {
+			return ``, false
+		}
+	} else if rx := self.pattern(); rx == nil || !rx.MatchString(raw) {
+		return ``, false
+	}
+
+	switch self.Type {
+	case Hexadecimal:
+		if v, err := strconv.ParseInt(raw, 16, 32); err == nil {
+			return fmt.Sprintf("%d", v), true
+		}
+	}
+
+	if names := self.Names(); len(names) > 0 {
+		return names[0], true
+	}
+
+	return ``, true
+}
+
+// Choices returns the names of every fixed value that can be set for this command, omitting
+// queries and relative adjustments.
+func (self *CommandInfo) Choices() []string {
+	choices := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, value := range self.Values {
+		if !value.Literal() {
+			continue
+		}
+
+		switch value.Code {
+		case `QSTN`, `UP`, `DOWN`, `TG`:
+			continue
+		}
+
+		if names := value.Names(); len(names) > 0 && !seen[names[0]] {
+			choices = append(choices, names[0])
+			seen[names[0]] = true
+		}
+	}
+
+	return choices
+}
+
+// Queryable returns whether the command supports retrieving its current value via "QSTN".
+func (self *CommandInfo) Queryable() bool {
+	for _, value := range self.Values {
+		if value.Code == `QSTN` {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EncodeValue converts a value name, decimal number or raw value into the parameter string
+// that should be sent to the device.
+func (self *CommandInfo) EncodeValue(arg string) (string, error) {
+	for _, value := range self.Values {
+		if value.Literal() {
+			if strings.EqualFold(arg, value.Code) {
+				return value.Code, nil
+			}
+
+			for _, name := range value.Names() {
+				if strings.EqualFold(arg, name) {
+					return value.Code, nil
+				}
+			}
+		}
+	}
+
+	if v, err := strconv.ParseInt(arg, 10, 32); err == nil {
+		for _, value := range self.Values {
+			if min, max, ok := value.numericRange(); ok {
+				if v >= min && v <= max {
+					return fmt.Sprintf("%02X", v), nil
+				}
+			} else if value.Type == Hexadecimal && value.Code != `QSTN` {
+				return fmt.Sprintf("%02X", v), nil
+			}
+		}
 	}
+
+	for _, value := range self.Values {
+		if !value.Literal() {
+			if _, ok := value.Match(arg); ok {
+				return arg, nil
+			}
+		}
+	}
+
+	return ``, fmt.Errorf("Invalid value %q for command %s (%s)", arg, self.Name, self.Code)
+}
+
+// valueDecoders summarize values that are too structured to be described by the catalog.
+var valueDecoders = map[string]func(value string) (string, error){
+	`IFA`: func(value string) (string, error) {
+		if info, err := onkyo.ParseAudioInfo(value); err == nil {
+			return info.String(), nil
+		} else {
+			return ``, err
+		}
+	},
+	`IFV`: func(value string) (string, error) {
+		if info, err := onkyo.ParseVideoInfo(value); err == nil {
+			return info.String(), nil
+		} else {
+			return ``, err
+		}
+	},
+}
+
+// DecodedMessage is a message received from the device, annotated with catalog information.
+type DecodedMessage struct {
+	Message     onkyo.Message `json:"-"`
+	Zone        string        `json:"zone,omitempty"`
+	Code        string        `json:"code"`
+	Name        string        `json:"name,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Value       string        `json:"value"`
+	Decoded     string        `json:"decoded,omitempty"`
+	Known       bool          `json:"known"`
+}
+
+func DecodeMessage(m onkyo.Message) *DecodedMessage {
+	decoded := &DecodedMessage{
+		Message: m,
+		Code:    m.Code(),
+		Value:   m.Value(),
+	}
+
+	if cmd, ok := codeToCmd[decoded.Code]; ok && cmd != nil {
+		decoded.Known = true
+		decoded.Zone = cmd.Zone
+		decoded.Name = cmd.Name
+		decoded.Description = cmd.Description
+
+		for i := range cmd.Values {
+			if v, ok := cmd.Values[i].Match(decoded.Value); ok {
+				decoded.Decoded = v
+				break
+			}
+		}
+
+		if decode, ok := valueDecoders[decoded.Code]; ok {
+			if summary, err := decode(decoded.Value); err == nil {
+				decoded.Decoded = summary
+			} else {
+				log.Debugf("Failed to decode %s: %v", m, err)
+			}
+		}
+
+		if alias, ok := primaryTarget().Aliases.Name(decoded.Code, decoded.Value); ok {
+			decoded.Decoded = alias
+		}
+	}
+
+	return decoded
 }
 
 func (self *CommandInfo) String() string {
@@ -81,7 +401,9 @@ func MessageToCommand(subcommand string, m onkyo.Message) (*CommandInfo, *Value,
 			if rx, err := regexp.Compile(`^` + cmd.Values[i].Code + `$`); err == nil {
 				if rx.MatchString(m.Value()) {
 					log.Debugf("%q: Value %+v matched", m.Value(), cmd.Values[i])
-					value := &cmd.Values[i]
+
+					// copied so that messages from several devices can be handled at once
+					value := cmd.Values[i]
 					value.Data = m.Value()
 
 					// if matches := rx.FindStringSubmatch(m.Value()); len(matches) > 0 {
@@ -101,7 +423,7 @@ func MessageToCommand(subcommand string, m onkyo.Message) (*CommandInfo, *Value,
 
 					log.Debugf("CALL: %s (%s): %s (%s) %q", cmd.Name, cmd.Code, value.Name, value.Code, value.Data)
 
-					return cmd, value, nil
+					return cmd, &value, nil
 				}
 			} else {
 				return nil, nil, err
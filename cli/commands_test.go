@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+func TestSignalInfoCommands(t *testing.T) {
+	for _, code := range []string{`IFA`, `IFV`} {
+		cmd := codeToCmd[code]
+
+		if value, err := cmd.EncodeValue(`query`); err != nil || value != `QSTN` {
+			t.Errorf("%s: expected query to encode as QSTN, got %q (%v)", code, value, err)
+		}
+
+		for _, arg := range []string{`garbage`, `nnnnn:nnnnn`, `HDMI 1,PCM,48 kHz,2.0 ch,Stereo,2.1 ch,`, `01`} {
+			if value, err := cmd.EncodeValue(arg); err == nil {
+				t.Errorf("%s: expected %q to be rejected, got %q", code, arg, value)
+			}
+		}
+	}
+
+	tests := []struct {
+		message string
+		decoded string
+	}{
+		{`!1IFAHDMI 5,PCM,48 kHz,2.0 ch,All Ch Stereo,5.1 ch,`, `HDMI 5 PCM 48 kHz 2.0 ch -> All Ch Stereo 5.1 ch`},
+		{`!1IFVHDMI 2,1920 x 1080p  60 Hz,RGB,24bit,HDMI,1920 x 1080p  60 Hz,RGB,24bit,`, `HDMI 2 1920x1080p60 RGB 24bit -> HDMI 1920x1080p60 RGB 24bit`},
+		{`!1IFAgarbage`, ``},
+	}
+
+	for _, test := range tests {
+		if decoded := DecodeMessage(onkyo.Message(test.message)); decoded.Decoded != test.decoded {
+			t.Errorf("%s: expected %q, got %q", test.message, test.decoded, decoded.Decoded)
+		}
+	}
+}
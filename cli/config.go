@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is read from the configuration file, and describes the devices that can be selected
+// by name (with --device):
+//
+//	default: living-room
+//	devices:
+//	  living-room:
+//	    host: 192.168.1.20
+//	    identifier: 0009B0123456   # found via discovery if the device is no longer at "host"
+//	    zone: main
+//	    response-timeout: 2s
+//	    volume:
+//	      max: 60
+//	    inputs:
+//	      tv: 12                   # "input-selector tv" sends SLI12
+//	  den:
+//	    serial: /dev/ttyUSB0
+//	groups:
+//	  house: [living-room, den]
+//
+// Several devices can be selected at once by giving a comma-separated list of names, the name
+// of a group, or "all".
+type Config struct {
+	Default string                   `yaml:"default,omitempty"`
+	Devices map[string]*DeviceConfig `yaml:"devices,omitempty"`
+	Groups  map[string][]string      `yaml:"groups,omitempty"`
+	Rules   onkyo.Rules              `yaml:"rules,omitempty"`
+}
+
+// DeviceConfig holds the connection details and defaults for a named device.
+type DeviceConfig struct {
+	Name             string            `yaml:"-"`
+	Host             string            `yaml:"host,omitempty"`
+	Port             int               `yaml:"port,omitempty"`
+	Identifier       string            `yaml:"identifier,omitempty"`
+	Serial           string            `yaml:"serial,omitempty"`
+	Baud             int               `yaml:"baud,omitempty"`
+	Zone             string            `yaml:"zone,omitempty"`
+	ResponseTimeout  time.Duration     `yaml:"response-timeout,omitempty"`
+	DiscoveryTimeout time.Duration     `yaml:"discovery-timeout,omitempty"`
+	Volume           VolumeLimits      `yaml:"volume,omitempty"`
+	Inputs           map[string]string `yaml:"inputs,omitempty"`
+}
+
+// VolumeLimits restricts the volume that can be set in every zone.  A maximum of zero means
+// there is no limit.
+type VolumeLimits struct {
+	Min int64 `yaml:"min,omitempty"`
+	Max int64 `yaml:"max,omitempty"`
+}
+
+func defaultConfigFile() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, `onkyo-remote`, `config.yaml`)
+	}
+
+	return `config.yaml`
+}
+
+// LoadConfig reads the configuration file.  A missing file is the same as an empty one.
+func LoadConfig(filename string) (*Config, error) {
+	config := &Config{}
+
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+
+		if err := yaml.NewDecoder(file).Decode(config); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("Failed to parse %s: %v", filename, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if config.Devices == nil {
+		config.Devices = make(map[string]*DeviceConfig)
+	}
+
+	for name, settings := range config.Devices {
+		if settings == nil {
+			settings = &DeviceConfig{}
+			config.Devices[name] = settings
+		}
+
+		settings.Name = name
+
+		if settings.Zone != `` {
+			if _, ok := zoneControls[settings.Zone]; !ok {
+				return nil, fmt.Errorf("Device %q: unknown zone %q", name, settings.Zone)
+			}
+		}
+
+		if settings.Volume.Max > 0 && settings.Volume.Min > settings.Volume.Max {
+			return nil, fmt.Errorf("Device %q: the minimum volume is greater than the maximum", name)
+		}
+	}
+
+	for group, members := range config.Groups {
+		if _, ok := config.Devices[group]; ok || group == `all` {
+			return nil, fmt.Errorf("Group %q has the same name as a device", group)
+		}
+
+		for _, name := range members {
+			if _, ok := config.Devices[name]; !ok {
+				return nil, fmt.Errorf("Group %q: device %q is not defined", group, name)
+			}
+		}
+	}
+
+	if config.Default != `` {
+		if _, ok := config.Devices[config.Default]; !ok {
+			return nil, fmt.Errorf("The default device %q is not defined", config.Default)
+		}
+	}
+
+	if err := config.Rules.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Names returns the names of every configured device, sorted.
+func (self *Config) Names() []string {
+	names := make([]string, 0)
+
+	for name := range self.Devices {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Device returns the named device, or the default device if no name is given.  If neither
+// is set, nil is returned.
+func (self *Config) Device(name string) (*DeviceConfig, error) {
+	if name == `` {
+		name = self.Default
+	}
+
+	if name == `` {
+		return nil, nil
+	}
+
+	if settings, ok := self.Devices[name]; ok {
+		return settings, nil
+	} else if len(self.Devices) > 0 {
+		return nil, fmt.Errorf("Unknown device %q (configured devices: %s)", name, strings.Join(self.Names(), `, `))
+	} else {
+		return nil, fmt.Errorf("Unknown device %q (no devices are configured)", name)
+	}
+}
+
+// Select returns the devices named in a comma-separated list of device names, group names or
+// "all" (every configured device).  Each device is only returned once.
+func (self *Config) Select(names string) ([]*DeviceConfig, error) {
+	selected := make([]*DeviceConfig, 0)
+	seen := make(map[string]bool)
+
+	for _, name := range strings.Split(names, `,`) {
+		var members []string
+
+		if name = strings.TrimSpace(name); name == `` {
+			continue
+		} else if name == `all` {
+			members = self.Names()
+		} else if group, ok := self.Groups[name]; ok {
+			members = group
+		} else {
+			members = []string{name}
+		}
+
+		for _, member := range members {
+			if settings, err := self.Device(member); err == nil {
+				if !seen[settings.Name] {
+					selected = append(selected, settings)
+					seen[settings.Name] = true
+				}
+			} else {
+				return nil, err
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+// Aliases returns the input aliases of the device for the input selector of every zone.
+func (self *DeviceConfig) Aliases() ValueAliases {
+	aliases := make(ValueAliases)
+
+	for alias, value := range self.Inputs {
+		for _, controls := range zoneControls {
+			cmd, ok := codeToCmd[controls.Input]
+
+			if !ok || cmd == nil {
+				continue
+			}
+
+			// aliases may be given as a value name or as the raw code sent to the device
+			code, err := cmd.EncodeValue(value)
+
+			if err != nil {
+				code = strings.ToUpper(value)
+			}
+
+			if aliases[cmd.Code] == nil {
+				aliases[cmd.Code] = make(map[string]string)
+			}
+
+			aliases[cmd.Code][strings.ToLower(alias)] = code
+		}
+	}
+
+	return aliases
+}
+
+// FilterSend implements onkyo.DeviceOptions.SendFilter, keeping the volume of every zone within
+// the configured limits.
+func (self *DeviceConfig) FilterSend(device *onkyo.Device, cmd string, param string) (string, error) {
+	if !isVolumeCode(cmd) || (self.Volume.Min == 0 && self.Volume.Max == 0) {
+		return param, nil
+	}
+
+	limits := self.Volume
+
+	switch param {
+	case `QSTN`:
+		return param, nil
+
+	case `UP`, `UP1`:
+		if current, ok := currentVolume(device, cmd); ok && limits.Max > 0 && current >= limits.Max {
+			return ``, fmt.Errorf("Volume is limited to %d on %s", limits.Max, self.Name)
+		}
+
+	case `DOWN`, `DOWN1`:
+		if current, ok := currentVolume(device, cmd); ok && current <= limits.Min {
+			return ``, fmt.Errorf("Volume is limited to at least %d on %s", limits.Min, self.Name)
+		}
+
+	default:
+		if volume, err := strconv.ParseInt(param, 16, 32); err == nil {
+			if limits.Max > 0 && volume > limits.Max {
+				log.Warningf("Volume %d is above the limit of %d, using %d", volume, limits.Max, limits.Max)
+				return fmt.Sprintf("%02X", limits.Max), nil
+			} else if volume < limits.Min {
+				log.Warningf("Volume %d is below the limit of %d, using %d", volume, limits.Min, limits.Min)
+				return fmt.Sprintf("%02X", limits.Min), nil
+			}
+		}
+	}
+
+	return param, nil
+}
+
+func isVolumeCode(code string) bool {
+	for _, controls := range zoneControls {
+		if controls.Volume == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func currentVolume(device *onkyo.Device, code string) (int64, bool) {
+	if message, ok := device.State().Get(code); ok {
+		if volume, err := strconv.ParseInt(message.Value(), 16, 32); err == nil {
+			return volume, true
+		}
+	}
+
+	return 0, false
+}
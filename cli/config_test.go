@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+func TestConfigSelect(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), `config.yaml`)
+
+	if err := os.WriteFile(filename, []byte(`
+default: living-room
+devices:
+  living-room:
+    host: 192.168.1.20
+  den:
+    serial: /dev/ttyUSB0
+  kitchen:
+    host: 192.168.1.21
+groups:
+  house: [living-room, den]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		names    string
+		selected []string
+		err      bool
+	}{
+		{`den`, []string{`den`}, false},
+		{`house`, []string{`living-room`, `den`}, false},
+		{`all`, []string{`den`, `kitchen`, `living-room`}, false},
+		{`kitchen,house`, []string{`kitchen`, `living-room`, `den`}, false},
+		{` den , house `, []string{`den`, `living-room`}, false},
+		{`house,all`, []string{`living-room`, `den`, `kitchen`}, false},
+		{`den,,`, []string{`den`}, false},
+		{``, []string{}, false},
+		{`den,garage`, nil, true},
+	}
+
+	for _, test := range tests {
+		selected, err := config.Select(test.names)
+
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error state: %v", test.names, err)
+			continue
+		} else if test.err {
+			continue
+		}
+
+		names := make([]string, 0)
+
+		for _, settings := range selected {
+			names = append(names, settings.Name)
+		}
+
+		if !reflect.DeepEqual(names, test.selected) {
+			t.Errorf("%q: expected %v, got %v", test.names, test.selected, names)
+		}
+	}
+}
+
+func TestFilterSend(t *testing.T) {
+	device, receiver := newTestDevice(t)
+
+	settings := &DeviceConfig{
+		Name:   `living-room`,
+		Volume: VolumeLimits{Min: 10, Max: 60},
+	}
+
+	// the device reports the current volume before each test, which limits stepping it
+	setVolume := func(code string, value string) {
+		receiver.WriteFrame(onkyo.Message(`!1` + code + value))
+
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+			if message, ok := device.State().Get(code); ok && message.Value() == value {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s%s", code, value)
+			}
+		}
+	}
+
+	tests := []struct {
+		settings *DeviceConfig
+		current  string
+		cmd      string
+		param    string
+		filtered string
+		err      bool
+	}{
+		{settings, `20`, `MVL`, `50`, `3C`, false},
+		{settings, `20`, `MVL`, `3C`, `3C`, false},
+		{settings, `20`, `MVL`, `28`, `28`, false},
+		{settings, `20`, `MVL`, `05`, `0A`, false},
+		{settings, `20`, `MVL`, `QSTN`, `QSTN`, false},
+		{settings, `20`, `MVL`, `UP`, `UP`, false},
+		{settings, `20`, `ZVL`, `64`, `3C`, false},
+		{settings, `20`, `PWR`, `01`, `01`, false},
+		{settings, `3C`, `MVL`, `UP`, ``, true},
+		{settings, `3C`, `MVL`, `UP1`, ``, true},
+		{settings, `3C`, `MVL`, `DOWN`, `DOWN`, false},
+		{settings, `0A`, `MVL`, `DOWN1`, ``, true},
+		{settings, `0A`, `MVL`, `UP`, `UP`, false},
+		{&DeviceConfig{Name: `den`}, `20`, `MVL`, `50`, `50`, false},
+		{&DeviceConfig{Name: `den`, Volume: VolumeLimits{Min: 5}}, `20`, `MVL`, `50`, `50`, false},
+	}
+
+	for _, test := range tests {
+		setVolume(`MVL`, test.current)
+
+		filtered, err := test.settings.FilterSend(device, test.cmd, test.param)
+
+		if (err != nil) != test.err {
+			t.Errorf("%s%s at %s: unexpected error state: %v", test.cmd, test.param, test.current, err)
+		} else if filtered != test.filtered {
+			t.Errorf("%s%s at %s: expected %q, got %q", test.cmd, test.param, test.current, test.filtered, filtered)
+		}
+	}
+}
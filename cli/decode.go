@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var pcapMagic = []uint32{0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1}
+
+const pcapngMagic = 0x0a0d0d0a
+
+var hexdumpLinePattern = regexp.MustCompile(`^\s*[0-9a-fA-F]{4,8}(?::\s*|\s{2,})(.*)$`)
+
+// CapturedPacket is an eISCP packet along with where it was captured from, if known.
+type CapturedPacket struct {
+	*onkyo.PacketInfo
+	Source      string
+	Destination string
+	Time        time.Time
+}
+
+var iscpMagic = []byte(`ISCP`)
+
+// captureStream accumulates the payloads of a TCP connection, since eISCP packets may be
+// split across (or share) segments.
+type captureStream struct {
+	data []byte
+}
+
+func (self *captureStream) feed(payload []byte) ([]*onkyo.PacketInfo, error) {
+	self.data = append(self.data, payload...)
+
+	var result []*onkyo.PacketInfo
+	var firstErr error
+
+	for {
+		infos, err := onkyo.InspectPackets(self.data)
+
+		for _, info := range infos {
+			self.data = self.data[len(info.Raw):]
+		}
+
+		result = append(result, infos...)
+
+		if err == nil || self.incomplete() {
+			// the rest of the packet (if any) is still to come
+			return result, firstErr
+		} else if firstErr == nil {
+			firstErr = err
+		}
+
+		// skip to the next packet in the stream, keeping enough to recognize its header if
+		// it has only partly arrived
+		if i := bytes.Index(self.data[1:], iscpMagic); i >= 0 {
+			self.data = self.data[i+1:]
+		} else {
+			if keep := len(iscpMagic) - 1; len(self.data) > keep {
+				self.data = self.data[len(self.data)-keep:]
+			}
+
+			return result, firstErr
+		}
+	}
+}
+
+// incomplete returns whether the stream starts with a valid packet header (or part of one)
+// for a packet that hasn't been entirely received.
+func (self *captureStream) incomplete() bool {
+	if len(self.data) < len(iscpMagic) {
+		return len(self.data) > 0 && bytes.HasPrefix(iscpMagic, self.data)
+	} else if !bytes.HasPrefix(self.data, iscpMagic) {
+		return false
+	} else if len(self.data) < 16 {
+		return true
+	}
+
+	headerSize := binary.BigEndian.Uint32(self.data[4:8])
+	messageLen := binary.BigEndian.Uint32(self.data[8:12])
+
+	return headerSize == 16 && self.data[12] == 1 && len(self.data) < int(headerSize+messageLen)
+}
+
+// decodeInput reads eISCP packets from raw bytes, a hex dump or a packet capture.
+func decodeInput(r io.Reader, format string, port int) ([]*CapturedPacket, []error) {
+	data, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	if format == `auto` {
+		format = detectFormat(data)
+	}
+
+	switch format {
+	case `pcap`:
+		return decodeCapture(data, port)
+	case `hex`:
+		if raw, err := parseHex(string(data)); err == nil {
+			data = raw
+		} else {
+			return nil, []error{err}
+		}
+	case `raw`:
+	default:
+		return nil, []error{fmt.Errorf("Unknown input format %q", format)}
+	}
+
+	infos, err := onkyo.InspectPackets(data)
+	captured := make([]*CapturedPacket, 0)
+
+	for _, info := range infos {
+		captured = append(captured, &CapturedPacket{
+			PacketInfo: info,
+		})
+	}
+
+	if err != nil {
+		return captured, []error{err}
+	}
+
+	return captured, nil
+}
+
+func detectFormat(data []byte) string {
+	if len(data) >= 4 {
+		magic := binary.BigEndian.Uint32(data[0:4])
+
+		if magic == pcapngMagic {
+			return `pcap`
+		}
+
+		for _, m := range pcapMagic {
+			if magic == m {
+				return `pcap`
+			}
+		}
+	}
+
+	if bytes.HasPrefix(data, iscpMagic) {
+		return `raw`
+	}
+
+	if _, err := parseHex(string(data)); err == nil {
+		return `hex`
+	}
+
+	return `raw`
+}
+
+// parseHex decodes hex strings, either as a continuous string of digits (optionally
+// separated by spaces, colons or "0x" prefixes) or as a hex dump with leading offsets and
+// trailing ASCII columns (as copied from Wireshark or produced by xxd or hexdump -C).
+func parseHex(text string) ([]byte, error) {
+	var digits strings.Builder
+
+	for _, line := range strings.Split(text, "\n") {
+		limit := -1
+
+		// hex dumps have an offset column and (at most) 16 bytes per line
+		if match := hexdumpLinePattern.FindStringSubmatch(line); match != nil {
+			line = match[1]
+			limit = 32
+		}
+
+		fields := strings.Fields(strings.NewReplacer(`0x`, ` `, `:`, ` `, `|`, ` | `).Replace(line))
+		lineDigits := 0
+
+		for _, field := range fields {
+			if len(field)%2 != 0 || !isHex(field) || (limit > 0 && lineDigits+len(field) > limit) {
+				break
+			}
+
+			digits.WriteString(field)
+			lineDigits += len(field)
+		}
+	}
+
+	if digits.Len() == 0 {
+		return nil, fmt.Errorf("No hex data found")
+	}
+
+	return hex.DecodeString(digits.String())
+}
+
+func isHex(value string) bool {
+	_, err := hex.DecodeString(strings.Repeat(`0`, len(value)%2) + value)
+	return err == nil
+}
+
+// decodeCapture extracts eISCP packets from TCP and UDP traffic to or from the given port in
+// a pcap or pcapng file.  TCP payloads are reassembled in capture order.
+func decodeCapture(data []byte, port int) ([]*CapturedPacket, []error) {
+	var source gopacket.PacketDataSource
+	var linkType layers.LinkType
+
+	if len(data) < 4 {
+		return nil, []error{fmt.Errorf("Capture is too short")}
+	}
+
+	if binary.BigEndian.Uint32(data[0:4]) == pcapngMagic {
+		if r, err := pcapgo.NewNgReader(bytes.NewReader(data), pcapgo.DefaultNgReaderOptions); err == nil {
+			source = r
+			linkType = r.LinkType()
+		} else {
+			return nil, []error{err}
+		}
+	} else {
+		if r, err := pcapgo.NewReader(bytes.NewReader(data)); err == nil {
+			source = r
+			linkType = r.LinkType()
+		} else {
+			return nil, []error{err}
+		}
+	}
+
+	captured := make([]*CapturedPacket, 0)
+	streams := make(map[string]*captureStream)
+	errs := make([]error, 0)
+
+	packets := gopacket.NewPacketSource(source, linkType)
+	packets.DecodeOptions = gopacket.NoCopy
+
+	for p := range packets.Packets() {
+		var src, dst string
+		var srcPort, dstPort int
+		var payload []byte
+
+		if network := p.NetworkLayer(); network != nil {
+			src = network.NetworkFlow().Src().String()
+			dst = network.NetworkFlow().Dst().String()
+		} else {
+			continue
+		}
+
+		switch transport := p.TransportLayer().(type) {
+		case *layers.TCP:
+			srcPort, dstPort = int(transport.SrcPort), int(transport.DstPort)
+			payload = transport.Payload
+		case *layers.UDP:
+			srcPort, dstPort = int(transport.SrcPort), int(transport.DstPort)
+			payload = transport.Payload
+		default:
+			continue
+		}
+
+		if (srcPort != port && dstPort != port) || len(payload) == 0 {
+			continue
+		}
+
+		from := fmt.Sprintf("%s:%d", src, srcPort)
+		to := fmt.Sprintf("%s:%d", dst, dstPort)
+
+		var infos []*onkyo.PacketInfo
+		var err error
+
+		if _, ok := p.TransportLayer().(*layers.TCP); ok {
+			key := from + `>` + to
+
+			stream := streams[key]
+
+			if stream == nil {
+				stream = &captureStream{}
+				streams[key] = stream
+			}
+
+			infos, err = stream.feed(payload)
+		} else {
+			infos, err = onkyo.InspectPackets(payload)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s -> %s: %v", from, to, err))
+		}
+
+		for _, info := range infos {
+			captured = append(captured, &CapturedPacket{
+				PacketInfo:  info,
+				Source:      from,
+				Destination: to,
+				Time:        p.Metadata().Timestamp,
+			})
+		}
+	}
+
+	for key, stream := range streams {
+		if len(stream.data) > 0 {
+			errs = append(errs, fmt.Errorf("%s: %d bytes of incomplete packet at end of capture", strings.Replace(key, `>`, ` -> `, 1), len(stream.data)))
+		}
+	}
+
+	return captured, errs
+}
+
+func printCapturedPacket(n int, captured *CapturedPacket, dump bool) {
+	fmt.Printf("Packet %d (%d bytes)", n, len(captured.Raw))
+
+	if captured.Source != `` {
+		fmt.Printf(" %s -> %s at %s", captured.Source, captured.Destination, captured.Time.Format(`2006-01-02 15:04:05.000000`))
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("  Header size: %d\n", captured.HeaderSize)
+	fmt.Printf("  Length:      %d\n", captured.Length)
+	fmt.Printf("  Version:     %d\n", captured.Version)
+	fmt.Printf("  Reserved:    % x\n", captured.Reserved)
+	fmt.Printf("  Message:     %q\n", string(captured.Message))
+
+	decoded := DecodeMessage(captured.Message)
+
+	if decoded.Known {
+		fmt.Printf("  Command:     %s (%s, zone: %s)\n", decoded.Code, decoded.Name, decoded.Zone)
+	} else {
+		fmt.Printf("  Command:     %s (unknown)\n", decoded.Code)
+	}
+
+	if decoded.Decoded != `` && decoded.Decoded != decoded.Value {
+		fmt.Printf("  Value:       %s (%s)\n", decoded.Value, decoded.Decoded)
+	} else {
+		fmt.Printf("  Value:       %s\n", decoded.Value)
+	}
+
+	for _, err := range captured.Errors {
+		fmt.Printf("  Error:       %s\n", err)
+	}
+
+	if dump {
+		fmt.Printf("\n%s\n", strings.TrimRight(captured.Hexdump(), "\n"))
+	}
+
+	fmt.Printf("\n")
+}
+
+func decode(filename string, format string, port int, dump bool) error {
+	var input io.Reader = os.Stdin
+
+	if filename != `` && filename != `-` {
+		if file, err := os.Open(filename); err == nil {
+			defer file.Close()
+			input = file
+		} else {
+			return err
+		}
+	}
+
+	captured, errs := decodeInput(input, format, port)
+	failed := len(errs) > 0
+
+	for i, p := range captured {
+		printCapturedPacket(i+1, p, dump)
+
+		if len(p.Errors) > 0 {
+			failed = true
+		}
+	}
+
+	for _, err := range errs {
+		fmt.Printf("Error: %v\n", err)
+	}
+
+	if failed {
+		return fmt.Errorf("Some data could not be decoded")
+	}
+
+	return nil
+}
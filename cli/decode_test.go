@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func eiscpPacket(message string) []byte {
+	payload := []byte(`!1` + message + "\x00\r\n")
+	header := make([]byte, 16)
+
+	copy(header, iscpMagic)
+	binary.BigEndian.PutUint32(header[4:8], 16)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(payload)))
+	header[12] = 1
+
+	return append(header, payload...)
+}
+
+func feedSegments(stream *captureStream, segments ...[]byte) []string {
+	messages := make([]string, 0)
+
+	for _, segment := range segments {
+		infos, _ := stream.feed(segment)
+
+		for _, info := range infos {
+			messages = append(messages, string(info.Message))
+		}
+	}
+
+	return messages
+}
+
+func TestCaptureStreamSplitPackets(t *testing.T) {
+	data := append(eiscpPacket(`PWR01`), eiscpPacket(`MVL2A`)...)
+
+	// split at every offset, including within the ISCP magic
+	for i := 1; i < len(data); i++ {
+		messages := feedSegments(&captureStream{}, data[:i], data[i:])
+
+		if len(messages) != 2 || messages[0] != `!1PWR01` || messages[1] != `!1MVL2A` {
+			t.Errorf("split at %d: expected both messages, got %q", i, messages)
+		}
+	}
+}
+
+func TestCaptureStreamResync(t *testing.T) {
+	packet := eiscpPacket(`SLI10`)
+
+	tests := []struct {
+		name     string
+		segments [][]byte
+	}{
+		{`garbage before a packet`, [][]byte{append([]byte(`garbage`), packet...)}},
+		{`magic split after garbage`, [][]byte{append([]byte(`garbage`), packet[:2]...), packet[2:]}},
+		{`magic split one byte in`, [][]byte{append([]byte(`garbage`), packet[:1]...), packet[1:]}},
+		{`magic split three bytes in`, [][]byte{append([]byte(`garbage`), packet[:3]...), packet[3:]}},
+		{`garbage segment`, [][]byte{[]byte(`garbage`), packet}},
+	}
+
+	for _, test := range tests {
+		if messages := feedSegments(&captureStream{}, test.segments...); len(messages) != 1 || messages[0] != `!1SLI10` {
+			t.Errorf("%s: expected !1SLI10, got %q", test.name, messages)
+		}
+	}
+}
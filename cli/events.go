@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+	"github.com/gorilla/websocket"
+)
+
+const DEFAULT_EVENT_KEEPALIVE = time.Duration(30) * time.Second
+
+type Event struct {
+	*DecodedMessage
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// EventCommand is a command received from a WebSocket client, specified either by catalog
+// name or as a raw eISCP message.
+type EventCommand struct {
+	Zone  string      `json:"zone"`
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+	Raw   string      `json:"raw"`
+}
+
+type EventFilter struct {
+	Zones []string
+	Codes []string
+}
+
+func NewEventFilter(req *http.Request) *EventFilter {
+	filter := &EventFilter{}
+
+	for _, zone := range req.URL.Query()[`zone`] {
+		filter.Zones = append(filter.Zones, strings.Split(zone, `,`)...)
+	}
+
+	for _, code := range req.URL.Query()[`code`] {
+		filter.Codes = append(filter.Codes, strings.Split(code, `,`)...)
+	}
+
+	return filter
+}
+
+func (self *EventFilter) Match(event *Event) bool {
+	if len(self.Zones) > 0 && !containsFold(self.Zones, event.Zone) {
+		return false
+	}
+
+	if len(self.Codes) > 0 && !containsFold(self.Codes, event.Code) && !containsFold(self.Codes, event.Name) {
+		return false
+	}
+
+	return true
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(req *http.Request) bool {
+		return true
+	},
+}
+
+// streamEvents sends every message received from the device to the client, either over a
+// WebSocket (if the client requested an upgrade) or as Server-Sent Events.
+func (self *HttpServer) streamEvents(w http.ResponseWriter, req *http.Request) {
+	filter := NewEventFilter(req)
+
+	if websocket.IsWebSocketUpgrade(req) {
+		if conn, err := upgrader.Upgrade(w, req, nil); err == nil {
+			self.streamWebsocket(conn, filter)
+		} else {
+			log.Errorf("Failed to upgrade connection: %v", err)
+		}
+	} else {
+		self.streamServerSentEvents(w, req, filter)
+	}
+}
+
+func (self *HttpServer) streamServerSentEvents(w http.ResponseWriter, req *http.Request, filter *EventFilter) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, `Streaming is not supported`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(`Content-Type`, `text/event-stream`)
+	w.Header().Set(`Cache-Control`, `no-cache`)
+	w.Header().Set(`Connection`, `keep-alive`)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	sub := self.device.Subscribe()
+	defer self.device.Unsubscribe(sub)
+
+	keepalive := time.NewTicker(DEFAULT_EVENT_KEEPALIVE)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case message, ok := <-sub:
+			if !ok {
+				return
+			}
+
+			if event := NewEvent(message); filter.Match(event) {
+				if data, err := json.Marshal(event); err == nil {
+					fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+					flusher.Flush()
+				} else {
+					log.Errorf("Failed to encode event: %v", err)
+				}
+			}
+
+		case <-keepalive.C:
+			fmt.Fprintf(w, ": keepalive\n\n")
+			flusher.Flush()
+
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (self *HttpServer) streamWebsocket(conn *websocket.Conn, filter *EventFilter) {
+	defer conn.Close()
+
+	sub := self.device.Subscribe()
+	defer self.device.Unsubscribe(sub)
+
+	replies := make(chan interface{})
+	done := make(chan bool)
+	quit := make(chan bool)
+
+	// lets the reader give up on a reply if the writer has already gone
+	defer close(quit)
+
+	go func() {
+		defer close(done)
+
+		for {
+			var command EventCommand
+
+			if err := conn.ReadJSON(&command); err == nil {
+				if err := self.runEventCommand(&command); err != nil {
+					select {
+					case replies <- map[string]interface{}{
+						`error`: err.Error(),
+					}:
+					case <-quit:
+						return
+					}
+				}
+			} else {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					log.Debugf("WebSocket client disconnected: %v", err)
+				}
+
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case message, ok := <-sub:
+			if !ok {
+				return
+			}
+
+			if event := NewEvent(message); filter.Match(event) {
+				if err := conn.WriteJSON(event); err != nil {
+					log.Debugf("Failed to write event: %v", err)
+					return
+				}
+			}
+
+		case reply := <-replies:
+			if err := conn.WriteJSON(reply); err != nil {
+				log.Debugf("Failed to write reply: %v", err)
+				return
+			}
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// runEventCommand sends a command received from a WebSocket client to the device.  Replies
+// from the device are delivered back to the client as regular events.
+func (self *HttpServer) runEventCommand(command *EventCommand) error {
+	if command.Raw != `` {
+		if code, value, err := onkyo.ParseRawMessage(command.Raw); err == nil {
+			return self.device.Send(code, value)
+		} else {
+			return err
+		}
+	}
+
+	zone := command.Zone
+
+	if zone == `` {
+		zone = primaryTarget().Zone
+	}
+
+	if cmd, err := FindCommand(zone, command.Name); err == nil {
+		value := `query`
+
+		if command.Value != nil {
+			value = fmt.Sprintf("%v", command.Value)
+		}
+
+		if param, err := primaryTarget().Aliases.Encode(cmd, value); err == nil {
+			return self.device.Send(cmd.Code, param)
+		} else {
+			return err
+		}
+	} else {
+		return err
+	}
+}
+
+func NewEvent(message onkyo.Message) *Event {
+	return &Event{
+		DecodedMessage: DecodeMessage(message),
+		Timestamp:      time.Now(),
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+const DEFAULT_HOOK_TIMEOUT = time.Duration(10) * time.Second
+const DEFAULT_HOOK_CONCURRENCY = 4
+const DEFAULT_HOOK_QUEUE_SIZE = 64
+
+// Hook is a shell command run when the device reports a message with the given code (or any
+// message, if the code is "*").
+type Hook struct {
+	Code    string
+	Command string
+}
+
+// ParseHook parses a hook given as CODE=COMMAND, where the code may also be a command name.
+func ParseHook(spec string) (*Hook, error) {
+	parts := strings.SplitN(spec, `=`, 2)
+
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == `` || strings.TrimSpace(parts[1]) == `` {
+		return nil, fmt.Errorf("Invalid hook %q (expected CODE=COMMAND)", spec)
+	}
+
+	hook := &Hook{
+		Code:    strings.TrimSpace(parts[0]),
+		Command: parts[1],
+	}
+
+	if hook.Code != `*` {
+		if cmd, err := FindCommand(primaryTarget().Zone, hook.Code); err == nil {
+			hook.Code = cmd.Code
+		} else if _, ok := codeToCmd[strings.ToUpper(hook.Code)]; ok {
+			hook.Code = strings.ToUpper(hook.Code)
+		} else {
+			return nil, err
+		}
+	}
+
+	return hook, nil
+}
+
+// these are reported continuously while a network source is playing or browsing, so they are
+// only passed to hooks that ask for them by code
+var chattyHookCodes = map[string]bool{
+	`NJA`: true,
+	`NLT`: true,
+	`NLS`: true,
+}
+
+type hookJob struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Hooks runs commands and calls webhooks for messages received from the device.  A fixed number
+// of workers run them in the order they were queued; if the queue fills up (because hooks are
+// slower than the device is chatty), further ones are dropped.
+type Hooks struct {
+	OnChange []*Hook
+	Webhooks []string
+	Timeout  time.Duration
+	queue    chan hookJob
+}
+
+func NewHooks(onChange []*Hook, webhooks []string, timeout time.Duration, concurrency int) *Hooks {
+	if timeout <= 0 {
+		timeout = DEFAULT_HOOK_TIMEOUT
+	}
+
+	if concurrency <= 0 {
+		concurrency = DEFAULT_HOOK_CONCURRENCY
+	}
+
+	hooks := &Hooks{
+		OnChange: onChange,
+		Webhooks: webhooks,
+		Timeout:  timeout,
+		queue:    make(chan hookJob, DEFAULT_HOOK_QUEUE_SIZE),
+	}
+
+	for i := 0; i < concurrency; i++ {
+		go hooks.work()
+	}
+
+	return hooks
+}
+
+// Run dispatches every message the device reports until it is closed.
+func (self *Hooks) Run(device *onkyo.Device) {
+	sub := device.Subscribe()
+	defer device.Unsubscribe(sub)
+
+	for message := range sub {
+		self.Dispatch(DecodeMessage(message))
+	}
+}
+
+// Dispatch queues every hook matching the message to be run in the background.
+func (self *Hooks) Dispatch(decoded *DecodedMessage) {
+	chatty := chattyHookCodes[decoded.Code]
+
+	for _, hook := range self.OnChange {
+		hook := hook
+
+		if hook.Code == decoded.Code || (hook.Code == `*` && !chatty) {
+			self.enqueue(hook.Command, func(ctx context.Context) error {
+				return self.exec(ctx, hook.Command, decoded)
+			})
+		}
+	}
+
+	if !chatty {
+		for _, url := range self.Webhooks {
+			url := url
+
+			self.enqueue(url, func(ctx context.Context) error {
+				return self.post(ctx, url, decoded)
+			})
+		}
+	}
+}
+
+func (self *Hooks) enqueue(name string, fn func(ctx context.Context) error) {
+	select {
+	case self.queue <- hookJob{name, fn}:
+	default:
+		log.Warningf("Too many hooks waiting to run, dropping %q", name)
+	}
+}
+
+func (self *Hooks) work() {
+	for job := range self.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), self.Timeout)
+
+		if err := job.fn(ctx); err != nil {
+			log.Errorf("Hook %q failed: %v", job.name, err)
+		}
+
+		cancel()
+	}
+}
+
+func (self *Hooks) exec(ctx context.Context, command string, decoded *DecodedMessage) error {
+	return onkyo.RunCommand(ctx, []string{`/bin/sh`, `-c`, command},
+		`ZONE=`+decoded.Zone,
+		`CODE=`+decoded.Code,
+		`NAME=`+decoded.Name,
+		`VALUE=`+decoded.Value,
+		`DECODED=`+decoded.Decoded)
+}
+
+func (self *Hooks) post(ctx context.Context, url string, decoded *DecodedMessage) error {
+	return onkyo.PostJSON(ctx, url, decoded)
+}
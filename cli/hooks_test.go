@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+func TestHooksSkipChattyCodes(t *testing.T) {
+	posted := make(chan string, 4)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var decoded DecodedMessage
+
+		if err := json.NewDecoder(req.Body).Decode(&decoded); err != nil {
+			t.Errorf("Failed to decode webhook body: %v", err)
+		}
+
+		posted <- decoded.Code
+	}))
+
+	defer server.Close()
+
+	hooks := NewHooks(nil, []string{server.URL}, time.Second, 1)
+
+	for _, message := range []onkyo.Message{`!1NLTF300000000000001000FF00`, `!1NJA2-http://192.168.1.2/album_art.cgi`, `!1PWR01`} {
+		hooks.Dispatch(DecodeMessage(message))
+	}
+
+	select {
+	case code := <-posted:
+		if code != `PWR` {
+			t.Errorf("expected only PWR to be posted, got %s", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the webhook")
+	}
+
+	select {
+	case code := <-posted:
+		t.Errorf("expected nothing else to be posted, got %s", code)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestHooksMatchCodes(t *testing.T) {
+	hooks := &Hooks{
+		OnChange: []*Hook{
+			{Code: `*`, Command: `all`},
+			{Code: `NLT`, Command: `list`},
+			{Code: `MVL`, Command: `volume`},
+		},
+		queue: make(chan hookJob, 8),
+	}
+
+	tests := []struct {
+		message onkyo.Message
+		run     []string
+	}{
+		{`!1MVL2A`, []string{`all`, `volume`}},
+		{`!1PWR01`, []string{`all`}},
+		{`!1NLTF300000000000001000FF00`, []string{`list`}},
+		{`!1NLSC0P`, nil},
+	}
+
+	for _, test := range tests {
+		hooks.Dispatch(DecodeMessage(test.message))
+
+		if len(hooks.queue) != len(test.run) {
+			t.Errorf("%s: expected %d hooks queued, got %d", test.message, len(test.run), len(hooks.queue))
+		}
+
+		for _, expected := range test.run {
+			if len(hooks.queue) == 0 {
+				break
+			} else if job := <-hooks.queue; job.name != expected {
+				t.Errorf("%s: expected %q to be queued, got %q", test.message, expected, job.name)
+			}
+		}
+	}
+}
+
+func TestHooksDropWhenQueueIsFull(t *testing.T) {
+	// no workers, so nothing is taken off the queue
+	hooks := &Hooks{
+		OnChange: []*Hook{{Code: `*`, Command: `true`}},
+		queue:    make(chan hookJob, 2),
+	}
+
+	done := make(chan bool)
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			hooks.Dispatch(DecodeMessage(`!1PWR01`))
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch blocked on a full queue")
+	}
+
+	if len(hooks.queue) != 2 {
+		t.Errorf("expected the queue to hold 2 hooks, got %d", len(hooks.queue))
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+const DEFAULT_HTTP_LISTEN = `:8080`
+
+type HttpError struct {
+	Status int
+	Err    error
+}
+
+func (self *HttpError) Error() string {
+	return self.Err.Error()
+}
+
+func httpErrorf(status int, format string, args ...interface{}) *HttpError {
+	return &HttpError{
+		Status: status,
+		Err:    fmt.Errorf(format, args...),
+	}
+}
+
+type StateValue struct {
+	*DecodedMessage
+	Updated time.Time `json:"updated"`
+}
+
+type HttpServer struct {
+	Address string
+	Timeout time.Duration
+	Metrics *MetricsCollector
+	Art     *onkyo.AlbumArtDecoder
+	Scenes  onkyo.Scenes
+
+	// Scheduler, if set, is described by the /schedule endpoint.
+	Scheduler *onkyo.Scheduler
+	device    *onkyo.Device
+	mux       *http.ServeMux
+}
+
+func NewHttpServer(device *onkyo.Device, address string, timeout time.Duration) *HttpServer {
+	server := &HttpServer{
+		Address: address,
+		Timeout: timeout,
+		Metrics: NewMetricsCollector(device),
+		Art:     onkyo.NewAlbumArtDecoder(),
+		Scenes:  make(onkyo.Scenes),
+		device:  device,
+		mux:     http.NewServeMux(),
+	}
+
+	server.mux.HandleFunc(`/state`, server.handle(server.getState))
+	server.mux.HandleFunc(`/zones/`, server.handle(server.zoneCommand))
+	server.mux.HandleFunc(`/raw`, server.handle(server.sendRaw))
+	server.mux.HandleFunc(`/catalog`, server.handle(server.getCatalog))
+	server.mux.HandleFunc(`/events`, server.streamEvents)
+	server.mux.Handle(`/metrics`, server.Metrics.Handler())
+	server.mux.HandleFunc(`/art/`, server.getAlbumArt)
+	server.mux.HandleFunc(`/scenes`, server.handle(server.getScenes))
+	server.mux.HandleFunc(`/scenes/`, server.handle(server.runScene))
+	server.mux.HandleFunc(`/schedule`, server.handle(server.getSchedule))
+
+	return server
+}
+
+func (self *HttpServer) Handle(pattern string, handler http.Handler) {
+	self.mux.Handle(pattern, handler)
+}
+
+func (self *HttpServer) ListenAndServe() error {
+	self.Art.Watch(self.device)
+
+	// ask for the art of whatever is currently playing
+	if err := self.device.Send(`NJA`, `REQ`); err != nil {
+		log.Warningf("Failed to request album art: %v", err)
+	}
+
+	log.Noticef("Listening for HTTP requests on %s", self.Address)
+	return http.ListenAndServe(self.Address, self.mux)
+}
+
+// handle wraps a handler that returns data to be encoded as JSON, translating any errors
+// into the appropriate HTTP status code.
+func (self *HttpServer) handle(handler func(*http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(`Content-Type`, `application/json`)
+
+		if data, err := handler(req); err == nil {
+			json.NewEncoder(w).Encode(data)
+		} else {
+			status := http.StatusInternalServerError
+
+			switch err.(type) {
+			case *HttpError:
+				status = err.(*HttpError).Status
+			default:
+				if err == onkyo.ErrResponseTimeout {
+					status = http.StatusGatewayTimeout
+				}
+			}
+
+			log.Debugf("%s %s: %d %v", req.Method, req.URL.Path, status, err)
+
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				`error`: err.Error(),
+			})
+		}
+	}
+}
+
+func (self *HttpServer) getState(req *http.Request) (interface{}, error) {
+	if req.Method != `GET` {
+		return nil, httpErrorf(http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+
+	zones := make(map[string]map[string]*StateValue)
+	state := self.device.State()
+
+	for code, message := range state.Messages() {
+		decoded := DecodeMessage(message)
+		zone := decoded.Zone
+		name := decoded.Name
+
+		if !decoded.Known {
+			zone = `unknown`
+			name = code
+		}
+
+		if _, ok := zones[zone]; !ok {
+			zones[zone] = make(map[string]*StateValue)
+		}
+
+		zones[zone][name] = &StateValue{
+			DecodedMessage: decoded,
+			Updated:        state.Updated(code),
+		}
+	}
+
+	return zones, nil
+}
+
+func (self *HttpServer) zoneCommand(req *http.Request) (interface{}, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, `/zones/`), `/`), `/`)
+
+	if len(parts) != 2 {
+		return nil, httpErrorf(http.StatusNotFound, "Path must be in the format /zones/ZONE/NAME")
+	}
+
+	cmd, err := FindCommand(parts[0], parts[1])
+
+	if err != nil {
+		return nil, &HttpError{http.StatusNotFound, err}
+	}
+
+	if !supported(primaryTarget(), cmd) {
+		return nil, httpErrorf(http.StatusNotFound, "Command %s (%s) is not supported by this model", cmd.Name, cmd.Code)
+	}
+
+	switch req.Method {
+	case `GET`:
+		if !cmd.Queryable() {
+			return nil, httpErrorf(http.StatusBadRequest, "Command %s (%s) cannot be queried", cmd.Name, cmd.Code)
+		}
+
+		if message, err := self.Metrics.Query(self.Timeout, cmd.Code); err == nil {
+			return DecodeMessage(message), nil
+		} else {
+			return nil, err
+		}
+
+	case `PUT`:
+		if value, err := readValue(req); err == nil {
+			if param, err := primaryTarget().Aliases.Encode(cmd, value); err == nil {
+				if message, err := self.device.Request(self.Timeout, cmd.Code, param); err == nil {
+					return DecodeMessage(message), nil
+				} else {
+					return nil, err
+				}
+			} else {
+				return nil, &HttpError{http.StatusBadRequest, err}
+			}
+		} else {
+			return nil, err
+		}
+
+	default:
+		return nil, httpErrorf(http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+}
+
+func (self *HttpServer) sendRaw(req *http.Request) (interface{}, error) {
+	if req.Method != `POST` {
+		return nil, httpErrorf(http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+
+	if raw, err := readValue(req); err == nil {
+		if code, value, err := onkyo.ParseRawMessage(raw); err == nil {
+			if message, err := self.device.Request(self.Timeout, code, value); err == nil {
+				return DecodeMessage(message), nil
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, httpErrorf(http.StatusBadRequest, "%v", err)
+		}
+	} else {
+		return nil, err
+	}
+}
+
+func (self *HttpServer) getCatalog(req *http.Request) (interface{}, error) {
+	if req.Method != `GET` {
+		return nil, httpErrorf(http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+
+	target := primaryTarget()
+	commands := make([]CommandInfo, 0)
+
+	for i := range AllKnownCommands {
+		if supported(target, &AllKnownCommands[i]) {
+			commands = append(commands, AllKnownCommands[i])
+		}
+	}
+
+	return commands, nil
+}
+
+func (self *HttpServer) getScenes(req *http.Request) (interface{}, error) {
+	if req.Method != `GET` {
+		return nil, httpErrorf(http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+
+	scenes := make([]*onkyo.Scene, 0)
+
+	for _, name := range self.Scenes.Names() {
+		scenes = append(scenes, self.Scenes[name])
+	}
+
+	return scenes, nil
+}
+
+// runScene runs the named scene, returning the messages that were sent.  If the "dry_run"
+// query parameter is true, the messages are returned without being sent.
+func (self *HttpServer) runScene(req *http.Request) (interface{}, error) {
+	if req.Method != `POST` {
+		return nil, httpErrorf(http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	}
+
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, `/scenes/`), `/`)
+
+	if _, ok := self.Scenes[name]; !ok {
+		return nil, httpErrorf(http.StatusNotFound, "Scene %q not found", name)
+	}
+
+	dryRun := (req.URL.Query().Get(`dry_run`) == `true`)
+
+	if sent, err := runScene(self.Scenes, name, primaryTarget().With(self.device, self.Timeout), dryRun); err == nil {
+		return map[string]interface{}{
+			`scene`:   name,
+			`dry_run`: dryRun,
+			`sent`:    sent,
+		}, nil
+	} else {
+		return nil, err
+	}
+}
+
+// getSchedule lists the upcoming runs of scheduled jobs (the number of which can be given with
+// the "count" query parameter), along with the results of their last runs.
+func (self *HttpServer) getSchedule(req *http.Request) (interface{}, error) {
+	if req.Method != `GET` {
+		return nil, httpErrorf(http.StatusMethodNotAllowed, "Method %s not allowed", req.Method)
+	} else if self.Scheduler == nil {
+		return nil, httpErrorf(http.StatusNotFound, "No schedule is being run")
+	}
+
+	count := DEFAULT_SCHEDULE_LIST_COUNT
+
+	if v := req.URL.Query().Get(`count`); v != `` {
+		if c, err := strconv.Atoi(v); err == nil && c > 0 {
+			count = c
+		} else {
+			return nil, httpErrorf(http.StatusBadRequest, "Invalid count %q", v)
+		}
+	}
+
+	return map[string]interface{}{
+		`upcoming`:  self.Scheduler.Schedule.Upcoming(time.Now(), count),
+		`last_runs`: self.Scheduler.Results(),
+	}, nil
+}
+
+// getAlbumArt serves the art for the currently-playing track.  Requesting "current.jpg"
+// always returns a JPEG (converting if necessary), while "current" returns the image as-is.
+func (self *HttpServer) getAlbumArt(w http.ResponseWriter, req *http.Request) {
+	art := self.Art.Current()
+
+	if art == nil {
+		http.Error(w, `No album art available`, http.StatusNotFound)
+		return
+	}
+
+	if art.URL != `` {
+		http.Redirect(w, req, art.URL, http.StatusFound)
+		return
+	}
+
+	switch path.Base(req.URL.Path) {
+	case `current.jpg`:
+		if art.ContentType != `image/jpeg` {
+			if img, err := art.Image(); err == nil {
+				w.Header().Set(`Content-Type`, `image/jpeg`)
+				jpeg.Encode(w, img, nil)
+			} else {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+
+			return
+		}
+	case `current`:
+		break
+	default:
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set(`Content-Type`, art.ContentType)
+	w.Write(art.Data)
+}
+
+// readValue reads a request body that is either a JSON object with a "value" key, or the
+// value as plain text.
+func readValue(req *http.Request) (string, error) {
+	if body, err := ioutil.ReadAll(req.Body); err == nil {
+		body = []byte(strings.TrimSpace(string(body)))
+
+		if strings.HasPrefix(string(body), `{`) {
+			var input struct {
+				Value interface{} `json:"value"`
+			}
+
+			if err := json.Unmarshal(body, &input); err != nil {
+				return ``, &HttpError{http.StatusBadRequest, err}
+			}
+
+			if input.Value == nil {
+				body = nil
+			} else {
+				body = []byte(fmt.Sprintf("%v", input.Value))
+			}
+		}
+
+		if len(body) == 0 {
+			return ``, httpErrorf(http.StatusBadRequest, "A value must be provided")
+		}
+
+		return string(body), nil
+	} else {
+		return ``, &HttpError{http.StatusBadRequest, err}
+	}
+}
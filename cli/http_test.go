@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *settingsReceiver) {
+	device, receiver := newTestDevice(t)
+
+	// AMT isn't reported, so commands for it time out
+	settings := &settingsReceiver{
+		state: map[string]string{
+			`PWR`: `00`,
+			`MVL`: `20`,
+			`DIM`: `00`,
+		},
+	}
+
+	go settings.serve(receiver)
+
+	server := httptest.NewServer(NewHttpServer(device, ``, 100*time.Millisecond).mux)
+	t.Cleanup(server.Close)
+
+	return server, settings
+}
+
+func TestHttpServer(t *testing.T) {
+	server, settings := newTestServer(t)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		value  string
+	}{
+		{`GET`, `/zones/main/system-power`, ``, http.StatusOK, `00`},
+		{`PUT`, `/zones/main/system-power`, `on`, http.StatusOK, `01`},
+		{`GET`, `/zones/main/PWR`, ``, http.StatusOK, `01`},
+		{`PUT`, `/zones/main/master-volume`, `{"value": "2A"}`, http.StatusOK, `2A`},
+		{`PUT`, `/zones/main/master-volume`, `{}`, http.StatusBadRequest, ``},
+		{`PUT`, `/zones/main/system-power`, `sideways`, http.StatusBadRequest, ``},
+		{`GET`, `/zones/main/audio-muting`, ``, http.StatusGatewayTimeout, ``},
+		{`DELETE`, `/zones/main/system-power`, ``, http.StatusMethodNotAllowed, ``},
+		{`GET`, `/zones/main/garbage`, ``, http.StatusNotFound, ``},
+		{`GET`, `/zones/main`, ``, http.StatusNotFound, ``},
+		{`GET`, `/zones/attic/system-power`, ``, http.StatusNotFound, ``},
+		{`POST`, `/raw`, `!1DIM01`, http.StatusOK, `01`},
+		{`POST`, `/raw`, `DIM02`, http.StatusOK, `02`},
+		{`POST`, `/raw`, `{"value": "!xDIMQSTN"}`, http.StatusOK, `02`},
+		{`POST`, `/raw`, `!1AMT01`, http.StatusGatewayTimeout, ``},
+		{`POST`, `/raw`, `!1P`, http.StatusBadRequest, ``},
+		{`POST`, `/raw`, ``, http.StatusBadRequest, ``},
+		{`GET`, `/raw`, ``, http.StatusMethodNotAllowed, ``},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(test.method, server.URL+test.path, strings.NewReader(test.body))
+		response, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded map[string]interface{}
+		json.NewDecoder(response.Body).Decode(&decoded)
+		response.Body.Close()
+
+		if response.StatusCode != test.status {
+			t.Errorf("%s %s %q: expected status %d, got %d (%v)", test.method, test.path, test.body, test.status, response.StatusCode, decoded)
+		} else if test.status != http.StatusOK {
+			if _, ok := decoded[`error`]; !ok {
+				t.Errorf("%s %s %q: expected an error, got %v", test.method, test.path, test.body, decoded)
+			}
+		} else if decoded[`value`] != test.value {
+			t.Errorf("%s %s %q: expected %s, got %v", test.method, test.path, test.body, test.value, decoded[`value`])
+		}
+	}
+
+	if sets := settings.sent(); strings.Join(sets, ` `) != `PWR01 MVL2A DIM01 DIM02` {
+		t.Errorf("unexpected commands sent: %v", sets)
+	}
+
+	// the state holds the last value of everything the device reported
+	response, err := http.Get(server.URL + `/state`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer response.Body.Close()
+
+	var state map[string]map[string]*StateValue
+
+	if err := json.NewDecoder(response.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]string{
+		`system-power`:  `01`,
+		`master-volume`: `2A`,
+		`dimmer-level`:  `02`,
+	} {
+		if current, ok := state[`main`][name]; !ok || current.Value != value {
+			t.Errorf("expected main %s to be %s, got %+v", name, value, current)
+		} else if current.Updated.IsZero() {
+			t.Errorf("expected main %s to have been updated", name)
+		}
+	}
+}
+
+func TestHttpServerWebsocket(t *testing.T) {
+	server, _ := newTestServer(t)
+
+	conn, _, err := websocket.DefaultDialer.Dial(`ws`+strings.TrimPrefix(server.URL, `http`)+`/events?code=MVL`, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	// a command by name, then a raw one; the device's replies come back as events
+	tests := []struct {
+		command  EventCommand
+		expected string
+	}{
+		{EventCommand{Name: `master-volume`, Value: `2A`}, `2A`},
+		{EventCommand{Raw: `!1MVL2B`}, `2B`},
+		{EventCommand{Raw: `!1P`}, `error`},
+	}
+
+	for _, test := range tests {
+		if err := conn.WriteJSON(test.command); err != nil {
+			t.Fatal(err)
+		}
+
+		var event map[string]interface{}
+
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatal(err)
+		}
+
+		if test.expected == `error` {
+			if _, ok := event[`error`]; !ok {
+				t.Errorf("%+v: expected an error, got %v", test.command, event)
+			}
+		} else if event[`code`] != `MVL` || event[`value`] != test.expected {
+			t.Errorf("%+v: expected MVL%s, got %v", test.command, test.expected, event)
+		}
+	}
+}
@@ -279,7 +279,6 @@ var AllKnownCommands = []CommandInfo{
 		Name:        `audio-infomation`,
 		Description: `Audio Infomation Command`,
 		Values: []Value{
-			{Code: `nnnnn:nnnnn`, Name: `None`, Description: `Infomation of Audio(Same Immediate Display ',' is separator of infomations)`},
 			{Code: `QSTN`, Name: `query`, Description: `gets Infomation of Audio`},
 		},
 	},
@@ -289,7 +288,6 @@ var AllKnownCommands = []CommandInfo{
 		Name:        `video-infomation`,
 		Description: `Video Infomation Command`,
 		Values: []Value{
-			{Code: `nnnnn:nnnnn`, Name: `None`, Description: `infomation of Video(Same Immediate Display ',' is separator of infomations)`},
 			{Code: `QSTN`, Name: `query`, Description: `gets Infomation of Video`},
 		},
 	},
@@ -1066,7 +1064,7 @@ oo -> receivable Program (8 bits are represented in hexadecimal notation. Each b
 		Description: `NET/USB Play Status`,
 		Values: []Value{
 			{Code: `prs`, Name: `prs`, Description: `NET/USB Play Status (3 letters)
-p -> Play Status: "S": STOP, "P": Play, "p": Pause, "F": FF, "R": FR
+p -> Play Status: "S": STOP, "P": Play, "p": Pause, "F": FF, "R": FR, "E": EOF
 r -> Repeat Status: "-": Off, "R": All, "F": Folder, "1": Repeat 1,
 s -> Shuffle Status: "-": Off, "S": All , "A": Album, "F": Folder`},
 			{Code: `QSTN`, Name: `query`, Description: `gets the Net/USB Status`},
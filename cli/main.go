@@ -2,10 +2,16 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghetzel/cli"
@@ -28,22 +34,229 @@ var log = logging.MustGetLogger(`main`)
 // }
 
 var device *onkyo.Device
+var scenes onkyo.Scenes
+
+// deviceConfigs are the devices selected from the configuration file, if any.
+var deviceConfigs []*DeviceConfig
+
+// discoverAll is set when "all" devices are selected, but none are configured.
+var discoverAll bool
+
+// rules are the automation rules from the configuration file.
+var rules onkyo.Rules
+
+// rootContext is the context of the application (rather than a subcommand), used by
+// commands that only connect to the device when needed.
+var rootContext *cli.Context
 
 func configureDevices(c *cli.Context) error {
-	if devices, err := onkyo.Discover(c.Duration(`discovery-timeout`), c.String(`host`)); err == nil {
-		for _, d := range devices {
+	opts := &onkyo.DeviceOptions{
+		AutoReconnect: c.Bool(`reconnect`),
+	}
+
+	if filename := c.String(`record`); filename != `` {
+		if file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err == nil {
+			opts.Recorder = onkyo.NewRecorder(file)
+		} else {
+			return fmt.Errorf("Failed to open recording: %v", err)
+		}
+	}
+
+	targets = make([]*Target, 0)
+	timeout := c.Duration(`response-timeout`)
+
+	switch {
+	case discoverAll:
+		if devices, err := onkyo.DiscoverWithOptions(c.Duration(`discovery-timeout`), c.String(`host`), opts); err == nil {
+			for _, d := range devices {
+				info := d.Info()
+				log.Noticef("Found device: [%s] %s at %s", info.Identifier, info.Model, d.Address().String())
+
+				targets = append(targets, newTarget(d.Address().String(), d, timeout, nil))
+			}
+		} else {
+			return fmt.Errorf("Failed to auto-discover devices: %v", err)
+		}
+
+	case len(deviceConfigs) == 0:
+		if d, err := connectDevice(c, nil, opts); err == nil {
+			targets = append(targets, newTarget(d.Address().String(), d, timeout, nil))
+		} else {
+			return err
+		}
+
+	case len(deviceConfigs) == 1:
+		if d, err := connectDevice(c, deviceConfigs[0], opts); err == nil {
+			targets = append(targets, newTarget(deviceConfigs[0].Name, d, timeout, deviceConfigs[0]))
+		} else {
+			return err
+		}
+
+	default:
+		connected := make([]*Target, len(deviceConfigs))
+		var wg sync.WaitGroup
+
+		for i, settings := range deviceConfigs {
+			wg.Add(1)
+
+			go func(i int, settings *DeviceConfig) {
+				defer wg.Done()
+
+				if d, err := connectDevice(c, settings, opts); err == nil {
+					connected[i] = newTarget(settings.Name, d, timeout, settings)
+
+					if settings.ResponseTimeout > 0 && !c.IsSet(`response-timeout`) {
+						connected[i].Timeout = settings.ResponseTimeout
+					}
+				} else {
+					log.Errorf("%s: %v", settings.Name, err)
+				}
+			}(i, settings)
+		}
+
+		wg.Wait()
+
+		for _, target := range connected {
+			if target != nil {
+				targets = append(targets, target)
+			} else {
+				connectFailures += 1
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		return fmt.Errorf("No devices found.")
+	}
+
+	device = targets[0].Device
+	return nil
+}
+
+// connectDevice connects to a single device, as described by its settings (if any) and the
+// command line.
+func connectDevice(c *cli.Context, settings *DeviceConfig, options *onkyo.DeviceOptions) (*onkyo.Device, error) {
+	opts := *options
+	port := c.String(`serial`)
+	baud := c.Int(`baud`)
+	host := c.String(`host`)
+	tcpPort := c.Int(`port`)
+	identifier := ``
+	discoveryTimeout := c.Duration(`discovery-timeout`)
+
+	if settings != nil {
+		opts.SendFilter = settings.FilterSend
+		identifier = settings.Identifier
+
+		if settings.DiscoveryTimeout > 0 && !c.IsSet(`discovery-timeout`) {
+			discoveryTimeout = settings.DiscoveryTimeout
+		}
+
+		if settings.Serial != `` {
+			port = settings.Serial
+
+			if settings.Baud > 0 {
+				baud = settings.Baud
+			}
+		} else if settings.Host != `` || settings.Identifier != `` {
+			port = ``
+
+			if settings.Host != `` {
+				host = settings.Host
+			}
+
+			if settings.Port > 0 {
+				tcpPort = settings.Port
+			}
+		}
+	}
+
+	if port != `` {
+		if d, err := onkyo.NewSerialDevice(port, baud, onkyo.DeviceInfo{}, &opts); err == nil {
+			log.Noticef("Connected to device on %s", port)
+			return d, nil
+		} else {
+			return nil, fmt.Errorf("Failed to open serial port %s: %v", port, err)
+		}
+	}
+
+	if host != `` && host != `auto` && !strings.Contains(host, `/`) {
+		address := host
+
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			address = net.JoinHostPort(host, strconv.Itoa(tcpPort))
+		}
+
+		if d, err := onkyo.Connect(address, c.Duration(`response-timeout`), &opts); err == nil {
 			info := d.Info()
-			log.Noticef("Found device: [%x] %s at %s", info.Identifier, info.Model, d.Address().String())
+			log.Noticef("Connected to device: [%s] %s at %s", info.Identifier, info.Model, d.Address().String())
+
+			// devices that don't describe themselves are assumed to be the right one
+			if identifier == `` || info.Identifier == `` || strings.EqualFold(info.Identifier, identifier) {
+				return d, nil
+			}
+
+			d.Close()
+			log.Noticef("Device at %s is %s rather than %s, searching the network", address, info.Identifier, identifier)
+		} else if identifier == `` {
+			return nil, fmt.Errorf("Failed to connect to %s: %v", address, err)
+		} else {
+			log.Noticef("Failed to connect to %s (%v), searching the network for %s", address, err, identifier)
 		}
 
-		if len(devices) > 0 {
-			device = devices[0]
-			return nil
+		host = `auto`
+	}
+
+	return discoverDevice(discoveryTimeout, host, identifier, &opts)
+}
+
+// discoverDevice finds the device at the given host (or anywhere on the network, if "auto").
+// If an identifier is given, only that device is accepted; when it is no longer at the given
+// host (e.g.: because its address has changed), the whole network is searched for it.
+func discoverDevice(timeout time.Duration, host string, identifier string, opts *onkyo.DeviceOptions) (*onkyo.Device, error) {
+	retry := (identifier != `` && host != `` && host != `auto`)
+	devices, err := onkyo.DiscoverWithOptions(timeout, host, opts)
+
+	if err != nil && !retry {
+		return nil, fmt.Errorf("Failed to auto-discover devices: %v", err)
+	}
+
+	var found *onkyo.Device
+
+	for _, d := range devices {
+		info := d.Info()
+		log.Noticef("Found device: [%s] %s at %s", info.Identifier, info.Model, d.Address().String())
+
+		if found == nil && (identifier == `` || strings.EqualFold(info.Identifier, identifier)) {
+			found = d
 		} else {
-			return fmt.Errorf("No devices found.")
+			d.Close()
 		}
+	}
+
+	if found != nil {
+		return found, nil
+	} else if retry {
+		log.Noticef("Device %s was not found at %s, searching the network", identifier, host)
+		return discoverDevice(timeout, `auto`, identifier, opts)
+	} else if identifier != `` {
+		return nil, fmt.Errorf("No device with identifier %s found.", identifier)
 	} else {
-		return fmt.Errorf("Failed to auto-discover devices: %v", err)
+		return nil, fmt.Errorf("No devices found.")
+	}
+}
+
+func printCommandHelp(w io.Writer, cmd *CommandInfo) {
+	fmt.Fprintf(w, "%s - %s (zone: %s)\n", cmd.Code, cmd.Description, cmd.Zone)
+
+	if len(cmd.Values) > 0 {
+		fmt.Fprintf(w, "\nSubcommands:\n")
+
+		for _, subcommand := range cmd.Values {
+			fmt.Fprintf(w, "  %-10s %s\n", subcommand.Code, strings.Replace(subcommand.Description, "\n", "\n    ", -1))
+		}
+
+		fmt.Fprintf(w, "\n")
 	}
 }
 
@@ -59,12 +272,40 @@ func main() {
 			Usage: `The level of logging verbosity to output.`,
 			Value: `error`,
 		},
+		cli.StringFlag{
+			Name:   `config, c`,
+			Usage:  `The configuration file named devices are defined in`,
+			EnvVar: `ONKYO_CONFIG`,
+			Value:  defaultConfigFile(),
+		},
+		cli.StringFlag{
+			Name:   `device, D`,
+			Usage:  `The devices in the configuration file to control: a comma-separated list of device or group names, or "all" (if not given, the configured default device is used unless --host or --serial are)`,
+			EnvVar: `ONKYO_DEVICE`,
+		},
 		cli.StringFlag{
 			Name:   `host, H`,
-			Usage:  `The IP address of the Onkyo ISCP device to control (use "auto" to auto-discover)`,
+			Usage:  `The address ("host" or "host:port") of the Onkyo ISCP device to connect to directly (use "auto" to auto-discover, or a CIDR range to discover within)`,
 			EnvVar: `ONKYO_ISCP_HOST`,
 			Value:  `auto`,
 		},
+		// receivers listen for eISCP on the discovery port (60128), not 60530 as was once assumed
+		cli.IntFlag{
+			Name:   `port, P`,
+			Usage:  `The TCP port to connect to when --host doesn't include one`,
+			EnvVar: `ONKYO_ISCP_PORT`,
+			Value:  onkyo.DEFAULT_DISCOVERY_PORT,
+		},
+		cli.StringFlag{
+			Name:   `serial, S`,
+			Usage:  `Control a device connected to this serial port (e.g.: /dev/ttyUSB0) instead of over the network`,
+			EnvVar: `ONKYO_ISCP_SERIAL`,
+		},
+		cli.IntFlag{
+			Name:  `baud`,
+			Usage: `The speed of the serial port`,
+			Value: onkyo.DEFAULT_SERIAL_BAUD,
+		},
 		cli.DurationFlag{
 			Name:  `discovery-timeout, T`,
 			Usage: `How long to perform auto-discovery for`,
@@ -75,6 +316,32 @@ func main() {
 			Usage: `How long to wait for command responses`,
 			Value: onkyo.DEFAULT_RESPONSE_TIMEOUT,
 		},
+		cli.BoolFlag{
+			Name:  `reconnect`,
+			Usage: `Automatically reconnect to the device if the connection is lost`,
+		},
+		cli.StringFlag{
+			Name:  `record`,
+			Usage: `Append every message sent to and received from the device to the given file (as JSON lines)`,
+		},
+		cli.StringFlag{
+			Name:   `capabilities`,
+			Usage:  `The file that the capabilities of each model are recorded in by the "probe" command`,
+			EnvVar: `ONKYO_CAPABILITIES`,
+			Value:  defaultCapabilitiesFile(),
+		},
+		cli.StringFlag{
+			Name:   `scenes`,
+			Usage:  `The file scenes are defined in`,
+			EnvVar: `ONKYO_SCENES`,
+			Value:  defaultSceneFile(),
+		},
+		cli.StringFlag{
+			Name:   `schedule`,
+			Usage:  `The file scheduled jobs are defined in`,
+			EnvVar: `ONKYO_SCHEDULE`,
+			Value:  defaultScheduleFile(),
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
@@ -86,14 +353,65 @@ func main() {
 		}
 
 		initCommands()
+		rootContext = c
+
+		if config, err := LoadConfig(c.String(`config`)); err == nil {
+			names := c.String(`device`)
+			rules = config.Rules
+
+			if names == `all` && len(config.Devices) == 0 {
+				discoverAll = true
+			} else if names != `` {
+				if selected, err := config.Select(names); err == nil {
+					deviceConfigs = selected
+				} else {
+					log.Fatal(err)
+				}
+			} else if !(c.IsSet(`host`) || c.IsSet(`serial`)) {
+				// an explicit host or serial port takes precedence over the default device
+				if settings, err := config.Device(``); err != nil {
+					log.Fatal(err)
+				} else if settings != nil {
+					deviceConfigs = []*DeviceConfig{settings}
+				}
+			}
+		} else {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+
+		if s, err := onkyo.LoadSceneFile(c.String(`scenes`)); err == nil {
+			scenes = s
+		} else {
+			log.Fatalf("Failed to load scenes: %v", err)
+		}
+
+		if len(deviceConfigs) == 1 {
+			if settings := deviceConfigs[0]; settings.ResponseTimeout > 0 && !c.IsSet(`response-timeout`) {
+				c.Set(`response-timeout`, settings.ResponseTimeout.String())
+			}
+		}
 
 		switch c.Args().First() {
-		case `help`: // don't go through discovery for informational subcommands
+		case `help`, `replay`, `decode`: // don't go through discovery for informational subcommands
+			return nil
+		case `scene`, `schedule`: // connect to the device only when running a scene or job
 			return nil
 		default:
 			if err := configureDevices(c); err != nil {
 				log.Fatal(err)
 			}
+
+			switch c.Args().First() {
+			case `get`, `call`, `automate`:
+			default:
+				if len(targets) > 1 {
+					log.Fatalf("The %q command can only control one device at a time", c.Args().First())
+				}
+			}
+
+			if err := useCapabilities(c.String(`capabilities`), targets); err != nil {
+				log.Fatalf("Failed to load capabilities: %v", err)
+			}
 		}
 
 		return nil
@@ -112,35 +430,9 @@ func main() {
 			},
 			Action: func(c *cli.Context) {
 				if code := c.Args().First(); code != `` {
-					if err := device.Send(code, `QSTN`); err == nil {
-						select {
-						case message := <-device.Messages():
-							if ci, value, err := MessageToCommand(`QSTN`, message); err == nil {
-								v := ``
-
-								if value != nil {
-									v = value.String()
-								}
-
-								if c.Bool(`only-value`) {
-									if v != `` {
-										fmt.Println(v)
-									}
-								} else {
-									fmt.Printf("%s\t%s\t%s\t%s\n", ci.Code, v, ci.Name, ci.Description)
-								}
-
-								if v == `` {
-									os.Exit(1)
-								}
-							} else {
-								log.Fatal(err)
-							}
-						case <-time.After(c.GlobalDuration(`response-timeout`)):
-						}
-					} else {
-						log.Fatalf("Failed to send command: %v", err)
-					}
+					exitWithFailures(forEachTarget(func(target *Target, w io.Writer) error {
+						return getValue(target, code, c.Bool(`only-value`), w)
+					}))
 				} else {
 					log.Fatalf("Must specify a command area to query.")
 				}
@@ -151,19 +443,9 @@ func main() {
 			ArgsUsage: `COMMAND SUBCOMMAND [ARGS]`,
 			Action: func(c *cli.Context) {
 				if code := c.Args().First(); code != `` {
-					subcommand := c.Args().Get(2)
-
-					if err := device.Send(code, c.Args().Tail()...); err == nil {
-						select {
-						case message := <-device.Messages():
-							if _, _, err := MessageToCommand(subcommand, message); err != nil {
-								log.Fatal(err)
-							}
-						case <-time.After(c.GlobalDuration(`response-timeout`)):
-						}
-					} else {
-						log.Fatalf("Failed to send command: %v", err)
-					}
+					exitWithFailures(forEachTarget(func(target *Target, w io.Writer) error {
+						return callCommand(target, code, c.Args().Get(2), c.Args().Tail())
+					}))
 				} else {
 					log.Fatalf("Must specify a command area to query.")
 				}
@@ -171,8 +453,69 @@ func main() {
 		}, {
 			Name:  `serve`,
 			Usage: `Connect to a device and continuously monitor events.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `listen, l`,
+					Usage: `If specified, also serve the HTTP API and event stream on this address`,
+				},
+				cli.StringSliceFlag{
+					Name:  `on-change, c`,
+					Usage: `Run a shell command (given as CODE=COMMAND, or *=COMMAND for every message) when a message is received, with ZONE, CODE, NAME, VALUE and DECODED set in its environment`,
+					Value: &cli.StringSlice{},
+				},
+				cli.StringSliceFlag{
+					Name:  `webhook, w`,
+					Usage: `POST every message received to this URL as JSON`,
+					Value: &cli.StringSlice{},
+				},
+				cli.DurationFlag{
+					Name:  `hook-timeout`,
+					Usage: `How long each command or webhook may take before it is cancelled`,
+					Value: DEFAULT_HOOK_TIMEOUT,
+				},
+				cli.IntFlag{
+					Name:  `hook-concurrency`,
+					Usage: `The maximum number of commands and webhooks to run at once`,
+					Value: DEFAULT_HOOK_CONCURRENCY,
+				},
+			},
 			Action: func(c *cli.Context) {
 				queries := make(chan []string)
+				onChange := make([]*Hook, 0)
+
+				for _, spec := range c.StringSlice(`on-change`) {
+					if hook, err := ParseHook(spec); err == nil {
+						onChange = append(onChange, hook)
+					} else {
+						log.Fatal(err)
+					}
+				}
+
+				hooks := NewHooks(onChange, c.StringSlice(`webhook`), c.Duration(`hook-timeout`), c.Int(`hook-concurrency`))
+
+				if address := c.String(`listen`); address != `` {
+					server := NewHttpServer(device, address, c.GlobalDuration(`response-timeout`))
+					server.Scenes = scenes
+
+					go func() {
+						if err := server.ListenAndServe(); err != nil {
+							log.Fatal(err)
+						}
+					}()
+				}
+
+				// replies to queries are consumed below, so the hooks watch the device on their own
+				if len(onChange) > 0 || len(hooks.Webhooks) > 0 {
+					go hooks.Run(device)
+				}
+
+				if len(rules) > 0 {
+					go func() {
+						if err := runRules(targets[0]); err != nil {
+							log.Fatal(err)
+						}
+					}()
+				}
 
 				go func() {
 					for {
@@ -212,6 +555,518 @@ func main() {
 					queries <- strings.Split(line, ` `)
 				}
 			},
+		}, {
+			Name:  `automate`,
+			Usage: `Perform the actions of the rules in the configuration file as devices report changes (as a daemon).`,
+			Action: func(c *cli.Context) {
+				if len(rules) == 0 {
+					log.Fatalf("No rules are defined in %s", rootContext.String(`config`))
+				}
+
+				for _, target := range targets {
+					target.Device.SetAutoReconnect(true)
+				}
+
+				exitWithFailures(forEachTarget(func(target *Target, w io.Writer) error {
+					return runRules(target)
+				}))
+			},
+		}, {
+			Name:  `backup`,
+			Usage: `Save every setting the device reports (as JSON) so that it can be restored later.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `output, o`,
+					Usage: `Write the backup to this file rather than standard output`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				if backup, err := backupDevice(targets[0]); err == nil {
+					var w io.Writer = os.Stdout
+
+					if filename := c.String(`output`); filename != `` {
+						if file, err := os.Create(filename); err == nil {
+							defer file.Close()
+							w = file
+						} else {
+							log.Fatal(err)
+						}
+					}
+
+					encoder := json.NewEncoder(w)
+					encoder.SetIndent(``, `  `)
+
+					if err := encoder.Encode(backup); err != nil {
+						log.Fatal(err)
+					}
+
+					log.Noticef("Saved %d settings", len(backup.Settings))
+				} else {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:      `restore`,
+			Usage:     `Reapply the setup (speakers, outputs, listening mode, Audyssey, levels, tone and display) from a backup.`,
+			ArgsUsage: `FILE`,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  `dry-run, n`,
+					Usage: `Only print the settings that would be changed`,
+				},
+				cli.BoolFlag{
+					Name:  `force, f`,
+					Usage: `Restore a backup taken from a different model`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				if c.Args().First() == `` {
+					log.Fatalf("Must specify a backup file to restore.")
+				}
+
+				backup, err := loadBackup(c.Args().First())
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				target := targets[0]
+
+				if model := target.Device.Info().Model; backup.Model != model && !c.Bool(`force`) {
+					log.Fatalf("The backup was taken from a %s, not a %s (use --force to restore it anyway)", backup.Model, model)
+				}
+
+				if message, err := target.Device.Query(target.Timeout, `PWR`); err != nil {
+					log.Fatalf("Failed to query power: %v", err)
+				} else if message.Value() != `01` {
+					log.Fatalf("The device must be on to restore settings")
+				}
+
+				changes, err := restoreChanges(target, backup)
+
+				if err != nil {
+					log.Fatal(err)
+				} else if len(changes) == 0 {
+					log.Noticef("The device already matches the backup")
+					return
+				}
+
+				printChanges(os.Stdout, changes)
+
+				if c.Bool(`dry-run`) {
+					return
+				}
+
+				failures := 0
+
+				for _, change := range changes {
+					if err := applyChange(target, change); err == nil {
+						log.Infof("Restored %s", change.Code)
+					} else {
+						log.Error(err)
+						failures += 1
+					}
+				}
+
+				if failures > 0 {
+					log.Fatalf("Failed to restore %d of %d settings", failures, len(changes))
+				}
+
+				log.Noticef("Restored %d settings", len(changes))
+			},
+		}, {
+			Name:  `probe`,
+			Usage: `Determine which commands the device supports, recording them in the capabilities file for its model.`,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  `concurrency, c`,
+					Usage: `The maximum number of queries to have outstanding at once`,
+					Value: DEFAULT_PROBE_CONCURRENCY,
+				},
+			},
+			Action: func(c *cli.Context) {
+				model := device.Info().Model
+
+				if model == `` {
+					log.Fatalf("The model of the device is not known")
+				}
+
+				filename := rootContext.String(`capabilities`)
+				caps, err := LoadCapabilities(filename)
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				modelCaps, err := probeDevice(targets[0], c.Int(`concurrency`))
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				counts := make(map[Support]int)
+
+				for i := range AllKnownCommands {
+					cmd := &AllKnownCommands[i]
+
+					// list codes that appear in several zones once
+					if support, ok := modelCaps.Commands[cmd.Code]; ok && codeToCmd[cmd.Code] == cmd {
+						fmt.Printf("%s\t%s\t%s\n", cmd.Code, support, cmd.Name)
+						counts[support] += 1
+					}
+				}
+
+				caps[model] = modelCaps
+
+				if err := caps.Save(filename); err != nil {
+					log.Fatal(err)
+				}
+
+				log.Noticef("%s: %d supported, %d not available, %d did not reply (saved to %s)", model, counts[Supported], counts[NotAvailable], counts[NoReply], filename)
+			},
+		}, {
+			Name:  `shell`,
+			Usage: `Control the device from an interactive prompt.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `history`,
+					Usage: `The file command history is saved to`,
+					Value: defaultHistoryFile(),
+				},
+			},
+			Action: func(c *cli.Context) {
+				if err := NewShell(device, c.GlobalDuration(`response-timeout`)).Run(c.String(`history`)); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:  `tui`,
+			Usage: `Show a full-screen dashboard of every zone, with keyboard controls.`,
+			Action: func(c *cli.Context) {
+				if err := NewDashboard(device, c.GlobalDuration(`response-timeout`)).Run(); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:      `browse`,
+			Usage:     `Interactively browse NET/USB lists (DLNA, favorites, internet radio, etc.)`,
+			ArgsUsage: `[SOURCE]`,
+			Action: func(c *cli.Context) {
+				if err := browse(device, c.Args().First()); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:  `now-playing`,
+			Usage: `Show the track currently playing from a NET/USB source.`,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  `follow, f`,
+					Usage: `Continue printing the track information as it changes`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				if err := nowPlaying(device, c.GlobalDuration(`response-timeout`), c.Bool(`follow`)); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:  `signal`,
+			Usage: `Show decoded audio and video information for the current input (IFA/IFV).`,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  `json, j`,
+					Usage: `Output the information as JSON`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				if info, err := querySignalInfo(device, c.GlobalDuration(`response-timeout`)); err == nil {
+					if err := printSignalInfo(info, c.Bool(`json`)); err != nil {
+						log.Fatal(err)
+					}
+				} else {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:        `tuner`,
+			Usage:       `Control the AM/FM tuner and manage presets.`,
+			Subcommands: tunerCommands(),
+		}, {
+			Name:  `proxy`,
+			Usage: `Share the connection to the device between many eISCP clients.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `listen, l`,
+					Usage: `The address to accept clients (and answer discovery requests) on`,
+					Value: fmt.Sprintf(":%d", onkyo.DEFAULT_DISCOVERY_PORT),
+				},
+			},
+			Action: func(c *cli.Context) {
+				proxy := onkyo.NewProxy(device)
+
+				if err := proxy.ListenAndServe(c.String(`listen`)); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:  `http`,
+			Usage: `Expose the device via an HTTP REST API.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `listen, l`,
+					Usage: `The address the HTTP server should listen on`,
+					Value: DEFAULT_HTTP_LISTEN,
+				},
+				cli.DurationFlag{
+					Name:  `poll-interval`,
+					Usage: `If non-zero, how often to query zone state for the /metrics endpoint`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				server := NewHttpServer(device, c.String(`listen`), c.GlobalDuration(`response-timeout`))
+				server.Scenes = scenes
+
+				if interval := c.Duration(`poll-interval`); interval > 0 {
+					go server.Metrics.Poll(interval, c.GlobalDuration(`response-timeout`))
+				}
+
+				if err := server.ListenAndServe(); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:  `metrics`,
+			Usage: `Export device state and connection health as Prometheus metrics.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `listen, l`,
+					Usage: `The address the metrics endpoint should listen on`,
+					Value: DEFAULT_METRICS_LISTEN,
+				},
+				cli.DurationFlag{
+					Name:  `poll-interval`,
+					Usage: `How often to query the state of each zone`,
+					Value: DEFAULT_METRICS_POLL_INTERVAL,
+				},
+			},
+			Action: func(c *cli.Context) {
+				collector := NewMetricsCollector(device)
+				mux := http.NewServeMux()
+				mux.Handle(`/metrics`, collector.Handler())
+
+				go collector.Poll(c.Duration(`poll-interval`), c.GlobalDuration(`response-timeout`))
+
+				log.Noticef("Serving metrics on %s/metrics", c.String(`listen`))
+
+				if err := http.ListenAndServe(c.String(`listen`), mux); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:  `mqtt`,
+			Usage: `Bridge the device to an MQTT broker (with Home Assistant discovery).`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   `broker, b`,
+					Usage:  `The URL of the MQTT broker to connect to`,
+					EnvVar: `ONKYO_MQTT_BROKER`,
+					Value:  `tcp://localhost:1883`,
+				},
+				cli.StringFlag{
+					Name:  `username, u`,
+					Usage: `The username to authenticate to the broker with`,
+				},
+				cli.StringFlag{
+					Name:   `password, p`,
+					Usage:  `The password to authenticate to the broker with`,
+					EnvVar: `ONKYO_MQTT_PASSWORD`,
+				},
+				cli.StringFlag{
+					Name:  `prefix`,
+					Usage: `The topic prefix that device state is published under`,
+					Value: DEFAULT_MQTT_PREFIX,
+				},
+				cli.StringFlag{
+					Name:  `discovery-prefix`,
+					Usage: `The Home Assistant discovery topic prefix (set to "" to disable discovery)`,
+					Value: DEFAULT_MQTT_DISCOVERY_PREFIX,
+				},
+				cli.StringFlag{
+					Name:  `embedded-broker`,
+					Usage: `Run an MQTT broker in-process on the given address and connect to it`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				broker := c.String(`broker`)
+
+				if address := c.String(`embedded-broker`); address != `` {
+					if server, err := StartEmbeddedBroker(address); err == nil {
+						defer server.Close()
+						broker = `tcp://` + address
+					} else {
+						log.Fatalf("Failed to start embedded broker: %v", err)
+					}
+				}
+
+				bridge := NewMqttBridge(device, broker)
+				bridge.Username = c.String(`username`)
+				bridge.Password = c.String(`password`)
+				bridge.Prefix = c.String(`prefix`)
+				bridge.DiscoveryPrefix = c.String(`discovery-prefix`)
+				bridge.Scenes = scenes
+				bridge.Timeout = c.GlobalDuration(`response-timeout`)
+
+				if err := bridge.Run(); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:  `scene`,
+			Usage: `List and run the scenes defined in the scenes file.`,
+			Subcommands: []cli.Command{
+				{
+					Name:  `list`,
+					Usage: `List the available scenes`,
+					Action: func(c *cli.Context) {
+						printScenes(scenes)
+					},
+				}, {
+					Name:      `run`,
+					Usage:     `Run a scene`,
+					ArgsUsage: `NAME`,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  `dry-run, n`,
+							Usage: `Print the messages the scene would send without connecting to the device`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						if c.Bool(`dry-run`) {
+							if sent, err := runScene(scenes, c.Args().First(), primaryTarget().With(nil, rootContext.Duration(`response-timeout`)), true); err == nil {
+								for _, message := range sent {
+									fmt.Println(message)
+								}
+							} else {
+								log.Fatal(err)
+							}
+
+							return
+						}
+
+						if err := configureDevices(rootContext); err != nil {
+							log.Fatal(err)
+						}
+
+						exitWithFailures(forEachTarget(func(target *Target, w io.Writer) error {
+							_, err := runScene(scenes, c.Args().First(), target, false)
+							return err
+						}))
+					},
+				},
+			},
+		}, {
+			Name:  `schedule`,
+			Usage: `Run the jobs in the schedule file at their scheduled times (as a daemon).`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `listen, l`,
+					Usage: `If specified, also serve the HTTP API (including upcoming runs at /schedule) on this address`,
+				},
+				cli.StringFlag{
+					Name:  `state`,
+					Usage: `The file used to keep track of missed runs between restarts (defaults to the schedule file with ".state" appended)`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				filename := rootContext.String(`schedule`)
+
+				if schedule, err := onkyo.LoadScheduleFile(filename); err == nil {
+					if err := configureDevices(rootContext); err != nil {
+						log.Fatal(err)
+					}
+
+					stateFile := c.String(`state`)
+
+					if stateFile == `` {
+						stateFile = scheduleStateFile(filename)
+					}
+
+					if err := runSchedule(schedule, stateFile, c.String(`listen`)); err != nil {
+						log.Fatal(err)
+					}
+				} else {
+					log.Fatalf("Failed to load schedule: %v", err)
+				}
+			},
+			Subcommands: []cli.Command{
+				{
+					Name:  `list`,
+					Usage: `List the upcoming runs of scheduled jobs`,
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  `count, n`,
+							Usage: `The number of runs to list`,
+							Value: DEFAULT_SCHEDULE_LIST_COUNT,
+						},
+					},
+					Action: func(c *cli.Context) {
+						if schedule, err := onkyo.LoadScheduleFile(rootContext.String(`schedule`)); err == nil {
+							printUpcoming(schedule, c.Int(`count`))
+						} else {
+							log.Fatalf("Failed to load schedule: %v", err)
+						}
+					},
+				},
+			},
+		}, {
+			Name:      `decode`,
+			Usage:     `Decode eISCP packets from raw bytes, a hex dump or a pcap/pcapng capture.`,
+			ArgsUsage: `[FILE]`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `format, f`,
+					Usage: `The input format (auto, raw, hex or pcap)`,
+					Value: `auto`,
+				},
+				cli.IntFlag{
+					Name:  `port, p`,
+					Usage: `Only decode captured TCP/UDP traffic to or from this port`,
+					Value: onkyo.DEFAULT_DISCOVERY_PORT,
+				},
+				cli.BoolFlag{
+					Name:  `hexdump, x`,
+					Usage: `Show a hex dump of each packet`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				if err := decode(c.Args().First(), c.String(`format`), c.Int(`port`), c.Bool(`hexdump`)); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:      `replay`,
+			Usage:     `Decode a session recording, or emulate the recorded device.`,
+			ArgsUsage: `FILE`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `listen, l`,
+					Usage: `Instead of decoding, serve the recording to eISCP clients on the given address`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				if frames, err := readRecording(c.Args().First()); err == nil {
+					if address := c.String(`listen`); address != `` {
+						if err := onkyo.NewReplayer(frames).ListenAndServe(address); err != nil {
+							log.Fatal(err)
+						}
+					} else {
+						printRecording(frames)
+					}
+				} else {
+					log.Fatal(err)
+				}
+			},
 		}, {
 			Name:      `help`,
 			Usage:     `Show the documentation for a given command`,
@@ -243,17 +1098,7 @@ func main() {
 				}
 
 				for _, cmd := range commands {
-					fmt.Printf("%s - %s (zone: %s)\n", cmd.Code, cmd.Description, cmd.Zone)
-
-					if len(cmd.Values) > 0 {
-						fmt.Printf("\nSubcommands:\n")
-
-						for _, subcommand := range cmd.Values {
-							fmt.Printf("  %-10s %s\n", subcommand.Code, strings.Replace(subcommand.Description, "\n", "\n    ", -1))
-						}
-
-						fmt.Printf("\n")
-					}
+					printCommandHelp(os.Stdout, cmd)
 				}
 			},
 		},
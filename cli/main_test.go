@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/ghetzel/onkyo-remote"
+	"github.com/op/go-logging"
+)
+
+func TestMain(m *testing.M) {
+	logging.SetLevel(logging.CRITICAL, `main`)
+	logging.SetLevel(logging.CRITICAL, `onkyo`)
+
+	initCommands()
+	os.Exit(m.Run())
+}
+
+// newTestDevice returns a device connected over a net.Pipe, along with the receiver's end.
+func newTestDevice(t *testing.T) (*onkyo.Device, onkyo.Transport) {
+	client, server := net.Pipe()
+	receiver := onkyo.NewConnTransport(server, onkyo.FramingEISCP)
+	receiver.Dial()
+
+	device, err := onkyo.NewDeviceFromConn(client, onkyo.DeviceInfo{
+		Model:      `TX-NR626`,
+		Identifier: `0009B0D4A6F1`,
+	}, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		device.Close()
+		server.Close()
+	})
+
+	return device, receiver
+}
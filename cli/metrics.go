@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const DEFAULT_METRICS_LISTEN = `:9090`
+const DEFAULT_METRICS_POLL_INTERVAL = time.Duration(30) * time.Second
+
+// MetricsCollector exports the current state of the device and the health of the connection
+// to it as Prometheus metrics.
+type MetricsCollector struct {
+	device         *onkyo.Device
+	identifier     string
+	power          *prometheus.Desc
+	volume         *prometheus.Desc
+	mute           *prometheus.Desc
+	input          *prometheus.Desc
+	listeningMode  *prometheus.Desc
+	received       *prometheus.Desc
+	sendErrors     *prometheus.Desc
+	decodeFailures *prometheus.Desc
+	reconnects     *prometheus.Desc
+	responders     *prometheus.Desc
+	latency        *prometheus.HistogramVec
+}
+
+func NewMetricsCollector(device *onkyo.Device) *MetricsCollector {
+	zoneLabels := []string{`device`, `zone`}
+
+	return &MetricsCollector{
+		device:         device,
+		identifier:     deviceIdentifier(device),
+		power:          prometheus.NewDesc(`onkyo_power`, `Whether the zone is powered on.`, zoneLabels, nil),
+		volume:         prometheus.NewDesc(`onkyo_volume`, `The volume level of the zone.`, zoneLabels, nil),
+		mute:           prometheus.NewDesc(`onkyo_mute`, `Whether the zone is muted.`, zoneLabels, nil),
+		input:          prometheus.NewDesc(`onkyo_input`, `The selected input of the zone (always 1).`, append(zoneLabels, `input`), nil),
+		listeningMode:  prometheus.NewDesc(`onkyo_listening_mode`, `The listening mode of the zone (always 1).`, append(zoneLabels, `mode`), nil),
+		received:       prometheus.NewDesc(`onkyo_messages_received_total`, `The number of messages received from the device.`, []string{`device`, `code`}, nil),
+		sendErrors:     prometheus.NewDesc(`onkyo_send_errors_total`, `The number of commands that failed to send.`, []string{`device`}, nil),
+		decodeFailures: prometheus.NewDesc(`onkyo_decode_failures_total`, `The number of packets that could not be decoded.`, []string{`device`}, nil),
+		reconnects:     prometheus.NewDesc(`onkyo_reconnects_total`, `The number of times the connection to the device was re-established.`, []string{`device`}, nil),
+		responders:     prometheus.NewDesc(`onkyo_discovery_responders_total`, `The number of devices that have responded to discovery.`, nil, nil),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    `onkyo_query_duration_seconds`,
+			Help:    `The round-trip time of QSTN queries.`,
+			Buckets: []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{`device`, `code`}),
+	}
+}
+
+func (self *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- self.power
+	ch <- self.volume
+	ch <- self.mute
+	ch <- self.input
+	ch <- self.listeningMode
+	ch <- self.received
+	ch <- self.sendErrors
+	ch <- self.decodeFailures
+	ch <- self.reconnects
+	ch <- self.responders
+	self.latency.Describe(ch)
+}
+
+func (self *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := self.device.Stats()
+
+	for zone, controls := range zoneControls {
+		if message, ok := self.current(controls.Power); ok {
+			ch <- prometheus.MustNewConstMetric(self.power, prometheus.GaugeValue, boolMetric(message.Value() == `01`), self.identifier, zone)
+		}
+
+		if message, ok := self.current(controls.Mute); ok {
+			ch <- prometheus.MustNewConstMetric(self.mute, prometheus.GaugeValue, boolMetric(message.Value() == `01`), self.identifier, zone)
+		}
+
+		if message, ok := self.current(controls.Volume); ok {
+			if v, err := strconv.ParseInt(message.Value(), 16, 32); err == nil {
+				ch <- prometheus.MustNewConstMetric(self.volume, prometheus.GaugeValue, float64(v), self.identifier, zone)
+			}
+		}
+
+		if message, ok := self.current(controls.Input); ok {
+			ch <- prometheus.MustNewConstMetric(self.input, prometheus.GaugeValue, 1, self.identifier, zone, metricLabel(message))
+		}
+
+		if message, ok := self.current(controls.ListeningMode); ok {
+			ch <- prometheus.MustNewConstMetric(self.listeningMode, prometheus.GaugeValue, 1, self.identifier, zone, metricLabel(message))
+		}
+	}
+
+	for code, count := range stats.Received() {
+		ch <- prometheus.MustNewConstMetric(self.received, prometheus.CounterValue, float64(count), self.identifier, code)
+	}
+
+	ch <- prometheus.MustNewConstMetric(self.sendErrors, prometheus.CounterValue, float64(stats.SendErrors()), self.identifier)
+	ch <- prometheus.MustNewConstMetric(self.decodeFailures, prometheus.CounterValue, float64(stats.DecodeFailures()), self.identifier)
+	ch <- prometheus.MustNewConstMetric(self.reconnects, prometheus.CounterValue, float64(stats.Reconnects()), self.identifier)
+	ch <- prometheus.MustNewConstMetric(self.responders, prometheus.CounterValue, float64(onkyo.DiscoveryResponders()))
+
+	self.latency.Collect(ch)
+}
+
+// current returns the last known value of the given code, provided the device reported one.
+func (self *MetricsCollector) current(code string) (onkyo.Message, bool) {
+	if message, ok := self.device.State().Get(code); ok && message.Value() != `` {
+		return message, true
+	}
+
+	return ``, false
+}
+
+// Query performs a QSTN query against the device, recording how long it took to respond.
+func (self *MetricsCollector) Query(timeout time.Duration, code string) (onkyo.Message, error) {
+	started := time.Now()
+	message, err := self.device.Query(timeout, code)
+
+	if err == nil {
+		self.latency.WithLabelValues(self.identifier, code).Observe(time.Since(started).Seconds())
+	}
+
+	return message, err
+}
+
+// Poll periodically queries the controls of every zone so that the exported state stays
+// current even if the device doesn't report changes on its own.
+func (self *MetricsCollector) Poll(interval time.Duration, timeout time.Duration) {
+	for {
+		for _, controls := range zoneControls {
+			for _, code := range controls.Codes() {
+				if _, err := self.Query(timeout, code); err != nil {
+					log.Debugf("Failed to poll %s: %v", code, err)
+				}
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func (self *MetricsCollector) Handler() http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(self)
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+func boolMetric(value bool) float64 {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+func metricLabel(message onkyo.Message) string {
+	if decoded := DecodeMessage(message); decoded.Decoded != `` {
+		return decoded.Decoded
+	}
+
+	return message.Value()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	device, receiver := newTestDevice(t)
+	frames := readFrames(receiver)
+	collector := NewMetricsCollector(device)
+
+	for _, message := range []onkyo.Message{`!1PWR01`, `!1MVL2A`, `!1AMT00`, `!1SLI10`, `!1LMD0C`, `!1ZPW00`, `!1ZVLN/A`, `!1MVL2B`} {
+		receiver.WriteFrame(message)
+	}
+
+	// the last message is received once its value is in the state
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if message, ok := device.State().Get(`MVL`); ok && message.Value() == `2B` {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the device state")
+		}
+	}
+
+	// queries are answered, recording their latency
+	go func() {
+		if message := <-frames; message != `!1PWRQSTN` {
+			t.Errorf("expected !1PWRQSTN, got %q", message)
+		}
+
+		receiver.WriteFrame(`!1PWR01`)
+	}()
+
+	if _, err := collector.Query(time.Second, `PWR`); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	collector.Handler().ServeHTTP(recorder, httptest.NewRequest(`GET`, `/metrics`, nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+
+	tests := []struct {
+		line    string
+		present bool
+	}{
+		{`onkyo_power{device="0009b0d4a6f1",zone="main"} 1`, true},
+		{`onkyo_power{device="0009b0d4a6f1",zone="zone2"} 0`, true},
+		{`onkyo_volume{device="0009b0d4a6f1",zone="main"} 43`, true},
+		{`onkyo_mute{device="0009b0d4a6f1",zone="main"} 0`, true},
+		{`onkyo_input{device="0009b0d4a6f1",input="dvd",zone="main"} 1`, true},
+		{`onkyo_listening_mode{device="0009b0d4a6f1",mode="all-ch-stereo",zone="main"} 1`, true},
+		{`onkyo_messages_received_total{code="MVL",device="0009b0d4a6f1"} 2`, true},
+		{`onkyo_messages_received_total{code="PWR",device="0009b0d4a6f1"} 2`, true},
+		{`onkyo_query_duration_seconds_count{code="PWR",device="0009b0d4a6f1"} 1`, true},
+		{`onkyo_send_errors_total{device="0009b0d4a6f1"} 0`, true},
+		{`onkyo_decode_failures_total{device="0009b0d4a6f1"} 0`, true},
+
+		// values the device reported as unavailable, and zones it said nothing about
+		{`onkyo_volume{device="0009b0d4a6f1",zone="zone2"}`, false},
+		{`zone="zone3"`, false},
+	}
+
+	for _, test := range tests {
+		if strings.Contains(string(body), test.line) != test.present {
+			t.Errorf("expected %q to be present=%v in:\n%s", test.line, test.present, body)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ghetzel/onkyo-remote"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+const DEFAULT_MQTT_PREFIX = `onkyo`
+const DEFAULT_MQTT_DISCOVERY_PREFIX = `homeassistant`
+const DEFAULT_MQTT_CLIENT_ID = `onkyo-remote`
+
+type MqttBridge struct {
+	Broker          string
+	ClientID        string
+	Prefix          string
+	DiscoveryPrefix string
+	Username        string
+	Password        string
+	Scenes          onkyo.Scenes
+	Timeout         time.Duration
+	device          *onkyo.Device
+	client          mqtt.Client
+	identifier      string
+}
+
+func NewMqttBridge(device *onkyo.Device, broker string) *MqttBridge {
+	return &MqttBridge{
+		Broker:          broker,
+		ClientID:        DEFAULT_MQTT_CLIENT_ID,
+		Prefix:          DEFAULT_MQTT_PREFIX,
+		DiscoveryPrefix: DEFAULT_MQTT_DISCOVERY_PREFIX,
+		Scenes:          make(onkyo.Scenes),
+		Timeout:         onkyo.DEFAULT_RESPONSE_TIMEOUT,
+		device:          device,
+		identifier:      deviceIdentifier(device),
+	}
+}
+
+// Run connects to the broker and publishes device messages until the connection to the
+// device is lost.
+func (self *MqttBridge) Run() error {
+	options := mqtt.NewClientOptions()
+	options.AddBroker(self.Broker)
+	options.SetClientID(self.ClientID + `-` + self.identifier)
+	options.SetUsername(self.Username)
+	options.SetPassword(self.Password)
+	options.SetAutoReconnect(true)
+	options.SetWill(self.availabilityTopic(), `offline`, 1, true)
+	options.SetOnConnectHandler(self.onConnect)
+
+	self.client = mqtt.NewClient(options)
+
+	if token := self.client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("Failed to connect to MQTT broker %s: %v", self.Broker, token.Error())
+	}
+
+	defer self.client.Disconnect(250)
+
+	sub := self.device.Subscribe()
+	defer self.device.Unsubscribe(sub)
+
+	// publish availability as the device connection drops and recovers (without waiting, since
+	// this is called as messages are being received)
+	self.device.OnConnectionChange(func(connected bool) {
+		self.client.Publish(self.availabilityTopic(), 1, true, self.availability())
+	})
+
+	self.queryZones()
+
+	for message := range sub {
+		decoded := DecodeMessage(message)
+
+		if !decoded.Known {
+			continue
+		}
+
+		self.publish(self.stateTopic(decoded.Zone, decoded.Name), mqttPayload(decoded), true)
+	}
+
+	return fmt.Errorf("Lost connection to device")
+}
+
+func (self *MqttBridge) onConnect(client mqtt.Client) {
+	log.Noticef("Connected to MQTT broker %s", self.Broker)
+
+	setTopic := fmt.Sprintf("%s/%s/+/+/set", self.Prefix, self.identifier)
+
+	if token := client.Subscribe(setTopic, 1, self.onSet); token.Wait() && token.Error() != nil {
+		log.Errorf("Failed to subscribe to %s: %v", setTopic, token.Error())
+	}
+
+	self.publish(self.availabilityTopic(), self.availability(), true)
+
+	if self.DiscoveryPrefix != `` {
+		self.publishDiscovery()
+	}
+}
+
+func (self *MqttBridge) onSet(client mqtt.Client, msg mqtt.Message) {
+	// topic is PREFIX/IDENTIFIER/ZONE/NAME/set
+	parts := strings.Split(strings.TrimPrefix(msg.Topic(), self.Prefix+`/`), `/`)
+
+	if len(parts) != 4 {
+		log.Warningf("Ignoring message on unexpected topic %q", msg.Topic())
+		return
+	}
+
+	// scenes are triggered via PREFIX/IDENTIFIER/scene/NAME/set
+	if parts[1] == `scene` {
+		go func() {
+			if _, err := runScene(self.Scenes, parts[2], primaryTarget().With(self.device, self.Timeout), false); err != nil {
+				log.Errorf("Failed to run scene %q: %v", parts[2], err)
+			}
+		}()
+
+		return
+	}
+
+	if cmd, err := FindCommand(parts[1], parts[2]); err == nil {
+		if param, err := primaryTarget().Aliases.Encode(cmd, strings.TrimSpace(string(msg.Payload()))); err == nil {
+			if err := self.device.Send(cmd.Code, param); err != nil {
+				log.Errorf("Failed to send command: %v", err)
+			}
+		} else {
+			log.Warningf("%s: %v", msg.Topic(), err)
+		}
+	} else {
+		log.Warningf("%s: %v", msg.Topic(), err)
+	}
+}
+
+// queryZones requests the current value of every zone control so that retained topics are
+// populated as soon as the bridge starts.
+func (self *MqttBridge) queryZones() {
+	target := primaryTarget()
+
+	for _, controls := range zoneControls {
+		for _, code := range controls.Codes() {
+			if cmd, ok := codeToCmd[code]; ok && !supported(target, cmd) {
+				continue
+			}
+
+			if err := self.device.Send(code, `QSTN`); err != nil {
+				log.Warningf("Failed to query %s: %v", code, err)
+			}
+		}
+	}
+}
+
+func (self *MqttBridge) publish(topic string, payload interface{}, retained bool) {
+	token := self.client.Publish(topic, 1, retained, payload)
+
+	if token.Wait() && token.Error() != nil {
+		log.Errorf("Failed to publish to %s: %v", topic, token.Error())
+	} else {
+		log.Debugf("Published %s: %v", topic, payload)
+	}
+}
+
+func (self *MqttBridge) stateTopic(zone string, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", self.Prefix, self.identifier, zone, name)
+}
+
+// availability returns the payload of the availability topic for the device's connection.
+func (self *MqttBridge) availability() string {
+	if self.device.Connected() {
+		return `online`
+	}
+
+	return `offline`
+}
+
+func (self *MqttBridge) availabilityTopic() string {
+	return fmt.Sprintf("%s/%s/availability", self.Prefix, self.identifier)
+}
+
+// publishDiscovery emits Home Assistant MQTT discovery configurations for the controls of
+// every zone.
+func (self *MqttBridge) publishDiscovery() {
+	info := self.device.Info()
+	target := primaryTarget()
+
+	haDevice := map[string]interface{}{
+		`identifiers`:  []string{self.identifier},
+		`name`:         fmt.Sprintf("Onkyo %s", info.Model),
+		`model`:        info.Model,
+		`manufacturer`: `Onkyo`,
+	}
+
+	for zone, controls := range zoneControls {
+		power, err := FindCommand(zone, controls.Power)
+
+		if err != nil {
+			log.Warningf("Skipping discovery for zone %s: %v", zone, err)
+			continue
+		}
+
+		if !supported(target, power) {
+			log.Debugf("Skipping discovery for zone %s: not supported by %s", zone, info.Model)
+			continue
+		}
+
+		objectId := fmt.Sprintf("%s_%s", self.identifier, zone)
+
+		player := map[string]interface{}{
+			`name`:               fmt.Sprintf("%s %s", info.Model, zone),
+			`unique_id`:          objectId,
+			`device`:             haDevice,
+			`availability_topic`: self.availabilityTopic(),
+			`state_topic`:        self.stateTopic(zone, power.Name),
+			`command_topic`:      self.stateTopic(zone, power.Name) + `/set`,
+			`payload_on`:         `on`,
+			`payload_off`:        `standby`,
+		}
+
+		if volume, err := FindCommand(zone, controls.Volume); err == nil && supported(target, volume) {
+			player[`volume_state_topic`] = self.stateTopic(zone, volume.Name)
+			player[`volume_command_topic`] = self.stateTopic(zone, volume.Name) + `/set`
+
+			self.publishConfig(`number`, objectId+`_volume`, map[string]interface{}{
+				`name`:               fmt.Sprintf("%s %s volume", info.Model, zone),
+				`unique_id`:          objectId + `_volume`,
+				`device`:             haDevice,
+				`availability_topic`: self.availabilityTopic(),
+				`state_topic`:        self.stateTopic(zone, volume.Name),
+				`command_topic`:      self.stateTopic(zone, volume.Name) + `/set`,
+				`min`:                0,
+				`max`:                80,
+				`step`:               1,
+			})
+		}
+
+		if mute, err := FindCommand(zone, controls.Mute); err == nil && supported(target, mute) {
+			player[`mute_state_topic`] = self.stateTopic(zone, mute.Name)
+			player[`mute_command_topic`] = self.stateTopic(zone, mute.Name) + `/set`
+		}
+
+		for suffix, code := range map[string]string{
+			`input`:          controls.Input,
+			`listening_mode`: controls.ListeningMode,
+		} {
+			if code == `` {
+				continue
+			}
+
+			if cmd, err := FindCommand(zone, code); err == nil && supported(target, cmd) {
+				if suffix == `input` {
+					player[`source_state_topic`] = self.stateTopic(zone, cmd.Name)
+					player[`source_command_topic`] = self.stateTopic(zone, cmd.Name) + `/set`
+					player[`source_list`] = cmd.Choices()
+				}
+
+				self.publishConfig(`select`, objectId+`_`+suffix, map[string]interface{}{
+					`name`:               fmt.Sprintf("%s %s %s", info.Model, zone, strings.Replace(suffix, `_`, ` `, -1)),
+					`unique_id`:          objectId + `_` + suffix,
+					`device`:             haDevice,
+					`availability_topic`: self.availabilityTopic(),
+					`state_topic`:        self.stateTopic(zone, cmd.Name),
+					`command_topic`:      self.stateTopic(zone, cmd.Name) + `/set`,
+					`options`:            cmd.Choices(),
+				})
+			}
+		}
+
+		self.publishConfig(`media_player`, objectId, player)
+	}
+
+	for _, name := range self.Scenes.Names() {
+		objectId := fmt.Sprintf("%s_scene_%s", self.identifier, name)
+
+		self.publishConfig(`scene`, objectId, map[string]interface{}{
+			`name`:               fmt.Sprintf("%s %s", info.Model, name),
+			`unique_id`:          objectId,
+			`device`:             haDevice,
+			`availability_topic`: self.availabilityTopic(),
+			`command_topic`:      self.stateTopic(`scene`, name) + `/set`,
+		})
+	}
+}
+
+func (self *MqttBridge) publishConfig(component string, objectId string, config map[string]interface{}) {
+	if data, err := json.Marshal(config); err == nil {
+		self.publish(fmt.Sprintf("%s/%s/%s/config", self.DiscoveryPrefix, component, objectId), data, true)
+	} else {
+		log.Errorf("Failed to encode discovery config for %s: %v", objectId, err)
+	}
+}
+
+// mqttPayload returns the human-readable interpretation of a message if there is one, or
+// the raw value otherwise.
+func mqttPayload(decoded *DecodedMessage) string {
+	if decoded.Decoded != `` {
+		return decoded.Decoded
+	}
+
+	return decoded.Value
+}
+
+// StartEmbeddedBroker runs an MQTT broker in-process, which is useful for testing the bridge
+// without any external infrastructure.
+func StartEmbeddedBroker(address string) (*mochi.Server, error) {
+	server := mochi.New(nil)
+
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		return nil, err
+	}
+
+	if err := server.AddListener(listeners.NewTCP(listeners.Config{
+		ID:      `embedded`,
+		Address: address,
+	})); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Errorf("Embedded MQTT broker failed: %v", err)
+		}
+	}()
+
+	// give the listener a moment to start accepting connections
+	time.Sleep(100 * time.Millisecond)
+
+	log.Noticef("Embedded MQTT broker listening on %s", address)
+	return server, nil
+}
+
+// deviceIdentifier returns a string that uniquely identifies the device, suitable for use in
+// topic names.
+func deviceIdentifier(device *onkyo.Device) string {
+	info := device.Info()
+
+	if info.Identifier != `` {
+		return strings.ToLower(info.Identifier)
+	}
+
+	return strings.NewReplacer(`.`, `_`, `:`, `_`).Replace(device.Address().String())
+}
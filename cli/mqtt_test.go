@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ghetzel/onkyo-remote"
+)
+
+// mqttTestBridge runs a bridge between a device on one end of a net.Pipe and an embedded
+// broker, returning the other end of the pipe and every message published to the broker.
+func mqttTestBridge(t *testing.T) (onkyo.Transport, chan mqtt.Message, mqtt.Client) {
+	listener, err := net.Listen(`tcp`, `127.0.0.1:0`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := listener.Addr().String()
+	listener.Close()
+
+	broker, err := StartEmbeddedBroker(address)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { broker.Close() })
+
+	device, receiver := newTestDevice(t)
+
+	published := make(chan mqtt.Message, 256)
+	options := mqtt.NewClientOptions()
+	options.AddBroker(`tcp://` + address)
+	options.SetClientID(`test`)
+
+	observer := mqtt.NewClient(options)
+
+	if token := observer.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	t.Cleanup(func() { observer.Disconnect(0) })
+
+	if token := observer.Subscribe(`#`, 1, func(client mqtt.Client, msg mqtt.Message) {
+		published <- msg
+	}); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	bridge := NewMqttBridge(device, `tcp://`+address)
+	go bridge.Run()
+
+	return receiver, published, observer
+}
+
+// waitForTopic returns the payload of the next message published to a topic.
+func waitForTopic(t *testing.T, published chan mqtt.Message, topic string, payload string) []byte {
+	timeout := time.After(2 * time.Second)
+
+	for {
+		select {
+		case msg := <-published:
+			if msg.Topic() == topic && (payload == `` || string(msg.Payload()) == payload) {
+				return msg.Payload()
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s %s", topic, payload)
+			return nil
+		}
+	}
+}
+
+// readFrames delivers everything the device sends to the receiver end of the pipe.
+func readFrames(receiver onkyo.Transport) chan onkyo.Message {
+	frames := make(chan onkyo.Message, 64)
+
+	go func() {
+		for {
+			if message, err := receiver.ReadFrame(); err == nil {
+				frames <- message
+			} else {
+				close(frames)
+				return
+			}
+		}
+	}()
+
+	return frames
+}
+
+func TestMqttBridge(t *testing.T) {
+	receiver, published, observer := mqttTestBridge(t)
+	frames := readFrames(receiver)
+
+	// availability and Home Assistant discovery are published on connecting
+	waitForTopic(t, published, `onkyo/0009b0d4a6f1/availability`, `online`)
+
+	config := make(map[string]interface{})
+	payload := waitForTopic(t, published, `homeassistant/media_player/0009b0d4a6f1_main/config`, ``)
+
+	if err := json.Unmarshal(payload, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]interface{}{
+		`unique_id`:            `0009b0d4a6f1_main`,
+		`state_topic`:          `onkyo/0009b0d4a6f1/main/system-power`,
+		`command_topic`:        `onkyo/0009b0d4a6f1/main/system-power/set`,
+		`volume_command_topic`: `onkyo/0009b0d4a6f1/main/master-volume/set`,
+		`source_state_topic`:   `onkyo/0009b0d4a6f1/main/input-selector`,
+		`availability_topic`:   `onkyo/0009b0d4a6f1/availability`,
+		`payload_on`:           `on`,
+		`payload_off`:          `standby`,
+	} {
+		if config[key] != expected {
+			t.Errorf("discovery %s: expected %v, got %v", key, expected, config[key])
+		}
+	}
+
+	if sources, ok := config[`source_list`].([]interface{}); !ok || len(sources) == 0 {
+		t.Errorf("expected a source list, got %v", config[`source_list`])
+	}
+
+	// messages from the device are published as state
+	receiver.WriteFrame(`!1PWR01`)
+
+	if payload := waitForTopic(t, published, `onkyo/0009b0d4a6f1/main/system-power`, ``); string(payload) != `on` {
+		t.Errorf("expected the power state to be on, got %q", payload)
+	}
+
+	// messages on /set topics are sent to the device
+	observer.Publish(`onkyo/0009b0d4a6f1/main/system-power/set`, 1, false, `standby`).Wait()
+
+	timeout := time.After(2 * time.Second)
+
+	for sent := false; !sent; {
+		select {
+		case message := <-frames:
+			sent = (message == `!1PWR00`)
+		case <-timeout:
+			t.Fatalf("timed out waiting for !1PWR00")
+		}
+	}
+
+	// the device going away is reflected in its availability
+	receiver.Close()
+	waitForTopic(t, published, `onkyo/0009b0d4a6f1/availability`, `offline`)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+// Target is a device selected on the command line.
+type Target struct {
+	Name    string
+	Device  *onkyo.Device
+	Timeout time.Duration
+
+	// Zone is the zone commands are sent to when no zone is given.
+	Zone    string
+	Aliases ValueAliases
+
+	// Capabilities are those probed for the device's model, or nil if it hasn't been probed
+	// (in which case every command is offered).
+	Capabilities *ModelCapabilities
+}
+
+func newTarget(name string, device *onkyo.Device, timeout time.Duration, settings *DeviceConfig) *Target {
+	target := &Target{
+		Name:    name,
+		Device:  device,
+		Timeout: timeout,
+		Zone:    DEFAULT_ZONE,
+	}
+
+	if settings != nil {
+		if settings.Zone != `` {
+			target.Zone = settings.Zone
+		}
+
+		target.Aliases = settings.Aliases()
+	}
+
+	return target
+}
+
+// With returns a copy of the target for the given device, keeping its zone and aliases.
+func (self Target) With(device *onkyo.Device, timeout time.Duration) *Target {
+	self.Device = device
+	self.Timeout = timeout
+	return &self
+}
+
+// resolve returns the code of a command given by name or code in the target's zone.  Codes
+// that aren't in the catalog are returned as given.
+func (self *Target) resolve(command string) string {
+	if cmd, err := FindCommand(self.Zone, command); err == nil {
+		return cmd.Code
+	}
+
+	return command
+}
+
+// targets are all of the devices selected on the command line (device is the first of them).
+var targets []*Target
+
+// primaryTarget returns the first selected device, which is the one used by commands that
+// only control a single device.  Before connecting, it describes the first configured device.
+func primaryTarget() *Target {
+	if len(targets) > 0 {
+		return targets[0]
+	} else if len(deviceConfigs) > 0 {
+		return newTarget(deviceConfigs[0].Name, nil, 0, deviceConfigs[0])
+	}
+
+	return newTarget(``, nil, 0, nil)
+}
+
+// connectFailures is the number of selected devices that could not be connected to.
+var connectFailures int
+
+// forEachTarget calls fn for every selected device in parallel.  Anything fn writes is printed
+// once it returns, with each line prefixed by the name of the device if there are several.
+// The number of devices that fn failed for is returned.
+func forEachTarget(fn func(target *Target, w io.Writer) error) int {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	failures := 0
+
+	for _, target := range targets {
+		wg.Add(1)
+
+		go func(target *Target) {
+			defer wg.Done()
+
+			var output bytes.Buffer
+			err := fn(target, &output)
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			printPrefixed(os.Stdout, targetPrefix(target), output.String())
+
+			if err != nil {
+				log.Errorf("%s%v", targetPrefix(target), err)
+				failures += 1
+			}
+		}(target)
+	}
+
+	wg.Wait()
+	return failures
+}
+
+func targetPrefix(target *Target) string {
+	if len(targets) > 1 {
+		return target.Name + `: `
+	}
+
+	return ``
+}
+
+func printPrefixed(w io.Writer, prefix string, output string) {
+	if output == `` {
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+	}
+}
+
+// exitWithFailures exits non-zero if the command failed for (or could not connect to) any of
+// the selected devices.
+func exitWithFailures(failures int) {
+	if failures+connectFailures > 0 {
+		os.Exit(1)
+	}
+}
+
+func getValue(target *Target, code string, onlyValue bool, w io.Writer) error {
+	code = target.resolve(code)
+
+	if message, err := target.Device.Query(target.Timeout, code); err == nil {
+		if ci, value, err := MessageToCommand(`QSTN`, message); err == nil {
+			v := ``
+
+			if value != nil {
+				v = value.String()
+			} else {
+				v = DecodeMessage(message).Decoded
+			}
+
+			if onlyValue {
+				if v != `` {
+					fmt.Fprintln(w, v)
+				}
+			} else {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", ci.Code, v, ci.Name, ci.Description)
+			}
+
+			if v == `` {
+				return fmt.Errorf("No value returned for %s", code)
+			}
+
+			return nil
+		} else {
+			return err
+		}
+	} else {
+		return fmt.Errorf("Failed to query %s: %v", code, err)
+	}
+}
+
+func callCommand(target *Target, code string, subcommand string, params []string) error {
+	code = target.resolve(code)
+
+	if alias, ok := target.Aliases[code][strings.ToLower(strings.Join(params, ``))]; ok {
+		params = []string{alias}
+	}
+
+	if message, err := target.Device.Request(target.Timeout, code, params...); err == nil {
+		_, _, err := MessageToCommand(subcommand, message)
+		return err
+	} else if err == onkyo.ErrResponseTimeout {
+		// not every command is acknowledged by the device
+		log.Debugf("%sNo reply to %s", targetPrefix(target), code)
+		return nil
+	} else {
+		return fmt.Errorf("Failed to send command: %v", err)
+	}
+}
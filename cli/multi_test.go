@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTargetsKeepTheirOwnZoneAndAliases(t *testing.T) {
+	livingRoom := newTarget(`living-room`, nil, 0, &DeviceConfig{
+		Inputs: map[string]string{`tv`: `12`},
+	})
+
+	den := newTarget(`den`, nil, 0, &DeviceConfig{
+		Zone:   `zone2`,
+		Inputs: map[string]string{`tv`: `game`},
+	})
+
+	tests := []struct {
+		target  *Target
+		command string
+		code    string
+		param   string
+	}{
+		{livingRoom, `input-selector`, `SLI`, `12`},
+		{livingRoom, `SLI`, `SLI`, `12`},
+		{den, `selector`, `SLZ`, `02`},
+		{den, `SLZ`, `SLZ`, `02`},
+	}
+
+	for _, test := range tests {
+		code := test.target.resolve(test.command)
+
+		if code != test.code {
+			t.Errorf("%s: expected %s to resolve to %s, got %s", test.target.Name, test.command, test.code, code)
+			continue
+		}
+
+		if param, err := test.target.Aliases.Encode(codeToCmd[code], `TV`); err != nil {
+			t.Errorf("%s: %v", test.target.Name, err)
+		} else if param != test.param {
+			t.Errorf("%s: expected tv to be %s%s, got %s%s", test.target.Name, code, test.param, code, param)
+		}
+	}
+
+	if livingRoom.Zone != DEFAULT_ZONE {
+		t.Errorf("expected the default zone to be %s, got %s", DEFAULT_ZONE, livingRoom.Zone)
+	}
+
+	// commands that aren't in the target's zone are sent as given
+	if code := livingRoom.resolve(`selector`); code != `selector` {
+		t.Errorf("expected selector to be passed through, got %s", code)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+// nowPlaying prints the track currently playing from a NET/USB source, and optionally keeps
+// printing it as it changes.
+func nowPlaying(device *onkyo.Device, timeout time.Duration, follow bool) error {
+	np := onkyo.NewNowPlaying()
+
+	if follow {
+		np.Watch(device)
+
+		if err := np.Refresh(device); err != nil {
+			return err
+		}
+
+		for info := range np.Updates() {
+			fmt.Println(formatNowPlaying(info))
+		}
+
+		return fmt.Errorf("Lost connection to device")
+	}
+
+	for _, code := range onkyo.NowPlayingCodes {
+		if message, err := device.Query(timeout, code); err == nil {
+			if _, err := np.Update(message); err != nil {
+				log.Warning(err)
+			}
+		} else {
+			log.Debugf("Failed to query %s: %v", code, err)
+		}
+	}
+
+	fmt.Println(formatNowPlaying(np.Current()))
+	return nil
+}
+
+func formatNowPlaying(info onkyo.NowPlayingInfo) string {
+	return fmt.Sprintf("[%s]\t%s\t%s\t%s\t%s/%s\t%d/%d\trepeat:%s\tshuffle:%s",
+		info.State,
+		info.Artist,
+		info.Title,
+		info.Album,
+		formatClock(info.Elapsed),
+		formatClock(info.Total),
+		info.Track,
+		info.Tracks,
+		info.Repeat,
+		info.Shuffle)
+}
+
+func formatClock(d time.Duration) string {
+	seconds := int(d / time.Second)
+
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, (seconds/60)%60, seconds%60)
+	}
+
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+func readRecording(filename string) ([]onkyo.RecordedFrame, error) {
+	if filename == `` {
+		return nil, fmt.Errorf("Must specify a recording to replay")
+	}
+
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+
+		return onkyo.ReadRecording(file)
+	} else {
+		return nil, err
+	}
+}
+
+func printRecording(frames []onkyo.RecordedFrame) {
+	for _, frame := range frames {
+		timestamp := frame.Time.Format(`15:04:05.000`)
+
+		switch frame.Direction {
+		case onkyo.DirectionOpen:
+			if frame.Device != nil {
+				fmt.Printf("%s\topen\t%s (%s) at %s\n", timestamp, frame.Device.Model, frame.Device.Identifier, frame.Address)
+			} else {
+				fmt.Printf("%s\topen\t%s\n", timestamp, frame.Address)
+			}
+		default:
+			decoded := DecodeMessage(frame.Message)
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n", timestamp, frame.Direction, decoded.Code, decoded.Value, decoded.Name, decoded.Decoded)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/ghetzel/onkyo-remote"
+)
+
+// runRules performs the actions of the configured rules as the given device reports changes,
+// until its connection is closed.
+func runRules(target *Target) error {
+	if engine, err := onkyo.NewRuleEngine(target.Device, rules, catalogResolver{target.Aliases}, target.Timeout); err == nil {
+		prefix := targetPrefix(target)
+
+		engine.Trace = func(format string, args ...interface{}) {
+			log.Infof(prefix+format, args...)
+		}
+
+		return engine.Run()
+	} else {
+		return err
+	}
+}
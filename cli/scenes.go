@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+// catalogResolver resolves the command and value names used in scenes using the catalog and
+// the aliases of the device the scene is run on.
+type catalogResolver struct {
+	Aliases ValueAliases
+}
+
+func (self catalogResolver) Resolve(zone string, command string, value string) (string, string, error) {
+	cmd, err := FindCommand(zone, command)
+
+	if err != nil {
+		if c, ok := codeToCmd[strings.ToUpper(command)]; ok && c != nil {
+			cmd = c
+		} else {
+			return ``, ``, err
+		}
+	}
+
+	if value == `` {
+		return cmd.Code, ``, nil
+	} else if param, err := self.Aliases.Encode(cmd, value); err == nil {
+		return cmd.Code, param, nil
+	} else {
+		return ``, ``, err
+	}
+}
+
+func defaultSceneFile() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, `onkyo-remote`, `scenes.yaml`)
+	}
+
+	return `scenes.yaml`
+}
+
+// runScene runs the named scene, returning the messages that were (or would have been) sent.
+func runScene(scenes onkyo.Scenes, name string, target *Target, dryRun bool) ([]onkyo.Message, error) {
+	scene, ok := scenes[name]
+
+	if !ok {
+		return nil, fmt.Errorf("Scene %q not found", name)
+	}
+
+	return runSceneOn(scene, target, dryRun)
+}
+
+func runSceneOn(scene *onkyo.Scene, target *Target, dryRun bool) ([]onkyo.Message, error) {
+	runner := onkyo.NewSceneRunner(target.Device, catalogResolver{target.Aliases}, target.Timeout)
+	runner.Zone = target.Zone
+	runner.DryRun = dryRun
+	runner.Trace = log.Infof
+
+	return runner.Run(scene)
+}
+
+func printScenes(scenes onkyo.Scenes) {
+	for _, name := range scenes.Names() {
+		fmt.Printf("%s\t%d steps\t%s\n", name, len(scenes[name].Steps), scenes[name].Description)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+const DEFAULT_SCHEDULE_LIST_COUNT = 10
+
+func defaultScheduleFile() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, `onkyo-remote`, `schedule.yaml`)
+	}
+
+	return `schedule.yaml`
+}
+
+// scheduleStateFile returns the file the scheduler keeps its state in, alongside the schedule.
+func scheduleStateFile(scheduleFile string) string {
+	return scheduleFile + `.state`
+}
+
+// runSchedule runs the jobs in the schedule on every selected device until the process is
+// stopped.  If an address is given, the HTTP API (including the /schedule endpoint) is served
+// on it as well.
+func runSchedule(schedule *onkyo.Schedule, stateFile string, address string) error {
+	for _, name := range schedule.Names() {
+		if _, err := schedule.Jobs[name].SceneIn(scenes); err != nil {
+			return fmt.Errorf("Job %q: %v", name, err)
+		}
+	}
+
+	if len(schedule.Jobs) == 0 {
+		log.Warningf("The schedule contains no jobs")
+	}
+
+	for _, target := range targets {
+		target.Device.SetAutoReconnect(true)
+	}
+
+	scheduler := onkyo.NewScheduler(schedule, stateFile, func(job *onkyo.Job) error {
+		scene, err := job.SceneIn(scenes)
+
+		if err != nil {
+			return err
+		}
+
+		if failures := forEachTarget(func(target *Target, w io.Writer) error {
+			_, err := runSceneOn(scene, target, false)
+			return err
+		}); failures > 0 {
+			return fmt.Errorf("Failed on %d of %d devices", failures, len(targets))
+		}
+
+		return nil
+	})
+
+	if address != `` {
+		server := NewHttpServer(device, address, targets[0].Timeout)
+		server.Scenes = scenes
+		server.Scheduler = scheduler
+
+		go func() {
+			if err := server.ListenAndServe(); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	for _, run := range schedule.Upcoming(time.Now(), 1) {
+		log.Noticef("Next job: %q at %v", run.Job, run.Time)
+	}
+
+	return scheduler.Run(nil)
+}
+
+func printUpcoming(schedule *onkyo.Schedule, count int) {
+	for _, run := range schedule.Upcoming(time.Now(), count) {
+		job := schedule.Jobs[run.Job]
+		fmt.Printf("%s\t%s\t%s\n", run.Time.Format(`Mon 2006-01-02 15:04 MST`), run.Job, job.Days)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/ghetzel/onkyo-remote"
+)
+
+var shellBuiltins = []string{`zone`, `raw`, `help`, `?`, `events`, `quit`, `exit`}
+
+const shellUsage = `Commands:
+  [ZONE] NAME [VALUE]   Set a value (or query it, if no value is given)
+  zone ZONE             Change the default zone
+  raw MESSAGE           Send a raw message (e.g.: "PWR01")
+  ? NAME / help NAME    Show the documentation for a command
+  events [on|off]       Show or hide events from the device while typing
+  quit                  Exit the shell
+`
+
+// Shell is an interactive prompt for controlling a device.
+type Shell struct {
+	Zone    string
+	Timeout time.Duration
+	device  *onkyo.Device
+	rl      *readline.Instance
+	events  bool
+	pending string
+	lock    sync.Mutex
+}
+
+func NewShell(device *onkyo.Device, timeout time.Duration) *Shell {
+	return &Shell{
+		Zone:    primaryTarget().Zone,
+		Timeout: timeout,
+		device:  device,
+		events:  true,
+	}
+}
+
+// defaultHistoryFile returns the path of the file shell history is kept in.
+func defaultHistoryFile() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, `onkyo-remote`, `history`)
+	}
+
+	return ``
+}
+
+// Run reads and executes commands until the user exits.
+func (self *Shell) Run(historyFile string) error {
+	if historyFile != `` {
+		if err := os.MkdirAll(filepath.Dir(historyFile), 0755); err != nil {
+			log.Warningf("Failed to create history directory: %v", err)
+		}
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          self.prompt(),
+		HistoryFile:     historyFile,
+		AutoComplete:    self,
+		InterruptPrompt: `^C`,
+		EOFPrompt:       `quit`,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	self.rl = rl
+	defer rl.Close()
+
+	sub := self.device.Subscribe()
+	defer self.device.Unsubscribe(sub)
+
+	go self.printEvents(sub)
+
+	for {
+		line, err := rl.Readline()
+
+		if err == readline.ErrInterrupt {
+			continue
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if quit := self.Execute(line); quit {
+			return nil
+		}
+	}
+}
+
+func (self *Shell) prompt() string {
+	return fmt.Sprintf("onkyo:%s> ", self.Zone)
+}
+
+func (self *Shell) stdout() io.Writer {
+	if self.rl != nil {
+		return self.rl.Stdout()
+	}
+
+	return os.Stdout
+}
+
+// Execute runs a single line of input, returning whether the shell should exit.
+func (self *Shell) Execute(line string) bool {
+	args := strings.Fields(line)
+
+	if len(args) == 0 {
+		return false
+	}
+
+	// allow "?name" as well as "? name"
+	if strings.HasPrefix(args[0], `?`) && len(args[0]) > 1 {
+		args = append([]string{`?`, args[0][1:]}, args[1:]...)
+	}
+
+	switch strings.ToLower(args[0]) {
+	case `quit`, `exit`:
+		return true
+	case `?`, `help`:
+		self.help(args[1:])
+	case `zone`:
+		if len(args) < 2 {
+			fmt.Fprintf(self.stdout(), "Current zone: %s (available: %s)\n", self.Zone, strings.Join(Zones(), `, `))
+		} else if _, ok := zoneToCmds[args[1]]; ok {
+			self.Zone = args[1]
+
+			if self.rl != nil {
+				self.rl.SetPrompt(self.prompt())
+			}
+		} else {
+			fmt.Fprintf(self.stdout(), "Unknown zone %q\n", args[1])
+		}
+	case `events`:
+		self.lock.Lock()
+
+		if len(args) < 2 {
+			self.events = !self.events
+		} else {
+			self.events = (args[1] == `on`)
+		}
+
+		fmt.Fprintf(self.stdout(), "Events are %s\n", map[bool]string{true: `shown`, false: `hidden`}[self.events])
+		self.lock.Unlock()
+	case `raw`:
+		if len(args) < 2 {
+			fmt.Fprintf(self.stdout(), "Usage: raw MESSAGE\n")
+		} else if code, value, err := onkyo.ParseRawMessage(strings.Join(args[1:], ``)); err == nil {
+			self.request(code, value)
+		} else {
+			fmt.Fprintf(self.stdout(), "%v\n", err)
+		}
+	default:
+		zone := self.Zone
+
+		if _, ok := zoneToCmds[args[0]]; ok && len(args) > 1 {
+			zone = args[0]
+			args = args[1:]
+		}
+
+		if cmd, err := FindCommand(zone, args[0]); err == nil {
+			if !supported(primaryTarget(), cmd) {
+				fmt.Fprintf(self.stdout(), "%s is not supported by this model (use \"raw\" to send it anyway)\n", cmd.Name)
+			} else if len(args) > 1 {
+				if value, err := primaryTarget().Aliases.Encode(cmd, strings.Join(args[1:], ` `)); err == nil {
+					self.request(cmd.Code, value)
+				} else {
+					fmt.Fprintf(self.stdout(), "%v\n", err)
+				}
+			} else if cmd.Queryable() {
+				self.request(cmd.Code, `QSTN`)
+			} else {
+				fmt.Fprintf(self.stdout(), "%s cannot be queried; specify a value (try \"? %s\")\n", cmd.Name, cmd.Name)
+			}
+		} else {
+			fmt.Fprintf(self.stdout(), "%v (type \"help\" for usage)\n", err)
+		}
+	}
+
+	return false
+}
+
+func (self *Shell) help(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(self.stdout(), shellUsage)
+		return
+	}
+
+	zone := self.Zone
+	name := args[0]
+
+	if _, ok := zoneToCmds[name]; ok && len(args) > 1 {
+		zone = name
+		name = args[1]
+	}
+
+	if cmd, err := FindCommand(zone, name); err == nil {
+		printCommandHelp(self.stdout(), cmd)
+	} else if cmd, ok := codeToCmd[strings.ToUpper(name)]; ok && cmd != nil {
+		printCommandHelp(self.stdout(), cmd)
+	} else {
+		fmt.Fprintf(self.stdout(), "%v\n", err)
+	}
+}
+
+func (self *Shell) request(code string, value string) {
+	self.lock.Lock()
+	self.pending = code
+	self.lock.Unlock()
+
+	defer func() {
+		self.lock.Lock()
+		self.pending = ``
+		self.lock.Unlock()
+	}()
+
+	if message, err := self.device.Request(self.Timeout, code, value); err == nil {
+		fmt.Fprintln(self.stdout(), formatDecoded(DecodeMessage(message)))
+	} else {
+		fmt.Fprintf(self.stdout(), "%s: %v\n", code, err)
+	}
+}
+
+// printEvents shows messages from the device that weren't sent in reply to a command entered
+// at the prompt.
+func (self *Shell) printEvents(sub chan onkyo.Message) {
+	for message := range sub {
+		self.lock.Lock()
+		show := self.events && message.Code() != self.pending
+		self.lock.Unlock()
+
+		if show {
+			fmt.Fprintf(self.stdout(), "* %s\n", formatDecoded(DecodeMessage(message)))
+		}
+	}
+}
+
+func formatDecoded(decoded *DecodedMessage) string {
+	if !decoded.Known {
+		return fmt.Sprintf("%s = %s", decoded.Code, decoded.Value)
+	}
+
+	if decoded.Decoded != `` && decoded.Decoded != decoded.Value {
+		return fmt.Sprintf("%s %s = %s (%s)", decoded.Zone, decoded.Name, decoded.Decoded, decoded.Value)
+	}
+
+	return fmt.Sprintf("%s %s = %s", decoded.Zone, decoded.Name, decoded.Value)
+}
+
+// Do implements readline.AutoCompleter, completing builtins, zones, command names and values.
+func (self *Shell) Do(line []rune, pos int) ([][]rune, int) {
+	input := string(line[:pos])
+	words := strings.Fields(input)
+
+	// the word being completed is empty if the cursor follows a space
+	if len(words) == 0 || strings.HasSuffix(input, ` `) {
+		words = append(words, ``)
+	}
+
+	prefix := words[len(words)-1]
+	previous := words[:len(words)-1]
+	var candidates []string
+
+	switch len(previous) {
+	case 0:
+		candidates = append(candidates, shellBuiltins...)
+		candidates = append(candidates, Zones()...)
+		candidates = append(candidates, commandNames(self.Zone)...)
+	default:
+		first := previous[0]
+
+		switch {
+		case first == `zone`:
+			if len(previous) == 1 {
+				candidates = Zones()
+			}
+		case first == `events`:
+			candidates = []string{`on`, `off`}
+		case first == `?` || first == `help`:
+			if len(previous) == 1 {
+				candidates = append(Zones(), commandNames(self.Zone)...)
+			} else if _, ok := zoneToCmds[previous[1]]; ok && len(previous) == 2 {
+				candidates = commandNames(previous[1])
+			}
+		default:
+			zone := self.Zone
+			rest := previous
+
+			if _, ok := zoneToCmds[first]; ok {
+				zone = first
+				rest = previous[1:]
+			}
+
+			switch len(rest) {
+			case 0:
+				candidates = commandNames(zone)
+			case 1:
+				if cmd, err := FindCommand(zone, rest[0]); err == nil {
+					candidates = valueNames(cmd)
+				}
+			}
+		}
+	}
+
+	matches := make([][]rune, 0)
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, []rune(candidate[len(prefix):]+` `))
+		}
+	}
+
+	return matches, len([]rune(prefix))
+}
+
+func commandNames(zone string) []string {
+	target := primaryTarget()
+	names := make([]string, 0)
+
+	for _, cmd := range zoneToCmds[zone] {
+		if supported(target, cmd) {
+			names = append(names, cmd.Name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// valueNames returns the names of every fixed value of a command, including queries and
+// relative adjustments.
+func valueNames(cmd *CommandInfo) []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, value := range cmd.Values {
+		if !value.Literal() {
+			continue
+		}
+
+		for _, name := range value.Names() {
+			if !seen[name] && !strings.Contains(name, ` `) {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+	}
+
+	return names
+}
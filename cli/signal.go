@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ghetzel/onkyo-remote"
+)
+
+type SignalInfo struct {
+	Audio *onkyo.AudioInfo `json:"audio,omitempty"`
+	Video *onkyo.VideoInfo `json:"video,omitempty"`
+}
+
+// querySignalInfo retrieves and decodes the audio and video information of the current input.
+func querySignalInfo(device *onkyo.Device, timeout time.Duration) (*SignalInfo, error) {
+	info := &SignalInfo{}
+
+	if message, err := device.Query(timeout, `IFA`); err == nil {
+		if audio, err := onkyo.ParseAudioInfo(message.Value()); err == nil {
+			info.Audio = audio
+		} else {
+			log.Warning(err)
+		}
+	} else {
+		return nil, err
+	}
+
+	if message, err := device.Query(timeout, `IFV`); err == nil {
+		if video, err := onkyo.ParseVideoInfo(message.Value()); err == nil {
+			info.Video = video
+		} else {
+			log.Warning(err)
+		}
+	} else {
+		log.Debugf("Failed to query video information: %v", err)
+	}
+
+	return info, nil
+}
+
+func printSignalInfo(info *SignalInfo, asJson bool) error {
+	if asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent(``, `  `)
+		return encoder.Encode(info)
+	}
+
+	if audio := info.Audio; audio != nil {
+		fmt.Printf("audio-input\t%s\n", audio.InputPort)
+		fmt.Printf("audio-format\t%s\n", audio.InputFormat)
+		fmt.Printf("audio-sample-rate\t%d\n", audio.SampleRate)
+		fmt.Printf("audio-input-channels\t%s\n", audio.InputChannels)
+		fmt.Printf("audio-listening-mode\t%s\n", audio.ListeningMode)
+		fmt.Printf("audio-output-channels\t%s\n", audio.OutputChannels)
+		fmt.Printf("audio-object-based\t%v\n", audio.IsObjectBased())
+	}
+
+	if video := info.Video; video != nil {
+		fmt.Printf("video-input\t%s\n", video.InputPort)
+		fmt.Printf("video-input-resolution\t%v\n", video.InputResolution)
+		fmt.Printf("video-input-color\t%s %s\n", video.InputColorSpace, video.InputColorDepth)
+		fmt.Printf("video-output\t%s\n", video.OutputPort)
+		fmt.Printf("video-output-resolution\t%v\n", video.OutputResolution)
+		fmt.Printf("video-output-color\t%s %s\n", video.OutputColorSpace, video.OutputColorDepth)
+		fmt.Printf("video-picture-mode\t%s\n", video.PictureMode)
+	}
+
+	return nil
+}
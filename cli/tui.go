@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/ghetzel/onkyo-remote"
+	"github.com/op/go-logging"
+	"github.com/rivo/tview"
+)
+
+const DEFAULT_TUI_EVENT_LOG_LINES = 500
+const DEFAULT_TUI_VOLUME_BAR_WIDTH = 24
+
+var tuiZones = []string{`main`, `zone2`, `zone3`, `zone4`}
+
+const tuiHelp = ` Tab: zone  ↑/↓: volume  ←/→: input  m: mute  p: power  l: listening mode  r: refresh  q: quit`
+
+// Dashboard is a full-screen view of the state of every zone, the current track, the input
+// signal and the messages received from the device.
+type Dashboard struct {
+	Timeout    time.Duration
+	device     *onkyo.Device
+	app        *tview.Application
+	zones      map[string]*tview.TextView
+	nowPlaying *tview.TextView
+	signal     *tview.TextView
+	events     *tview.TextView
+	np         *onkyo.NowPlaying
+	selected   int
+}
+
+func NewDashboard(device *onkyo.Device, timeout time.Duration) *Dashboard {
+	dashboard := &Dashboard{
+		Timeout:    timeout,
+		device:     device,
+		app:        tview.NewApplication(),
+		zones:      make(map[string]*tview.TextView),
+		nowPlaying: tview.NewTextView(),
+		signal:     tview.NewTextView(),
+		events:     tview.NewTextView(),
+		np:         onkyo.NewNowPlaying(),
+	}
+
+	for i, zone := range tuiZones {
+		if zone == primaryTarget().Zone {
+			dashboard.selected = i
+		}
+	}
+
+	zoneRow := tview.NewFlex()
+
+	for _, zone := range tuiZones {
+		panel := tview.NewTextView()
+		panel.SetBorder(true).SetTitle(` ` + zone + ` `)
+		dashboard.zones[zone] = panel
+		zoneRow.AddItem(panel, 0, 1, false)
+	}
+
+	dashboard.nowPlaying.SetBorder(true).SetTitle(` Now Playing `)
+	dashboard.signal.SetBorder(true).SetTitle(` Signal `)
+
+	dashboard.events.SetMaxLines(DEFAULT_TUI_EVENT_LOG_LINES)
+	dashboard.events.SetScrollable(true)
+	dashboard.events.SetBorder(true).SetTitle(` Events `)
+
+	infoRow := tview.NewFlex().
+		AddItem(dashboard.nowPlaying, 0, 1, false).
+		AddItem(dashboard.signal, 0, 1, false)
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(zoneRow, 7, 0, false).
+		AddItem(infoRow, 9, 0, false).
+		AddItem(dashboard.events, 0, 1, false).
+		AddItem(tview.NewTextView().SetText(tuiHelp), 1, 0, false)
+
+	dashboard.app.SetRoot(layout, true)
+	dashboard.app.SetInputCapture(dashboard.handleKey)
+
+	return dashboard
+}
+
+// Run displays the dashboard until the user quits or the connection to the device is lost.
+func (self *Dashboard) Run() error {
+	sub := self.device.Subscribe()
+	defer self.device.Unsubscribe(sub)
+
+	go func() {
+		for message := range sub {
+			self.app.QueueUpdateDraw(func() {
+				self.update(message)
+			})
+		}
+
+		self.app.Stop()
+	}()
+
+	// log messages would otherwise be written over the dashboard
+	logging.SetBackend(logging.NewLogBackend(&tuiLogWriter{self}, ``, 0))
+
+	self.render()
+	go self.refresh()
+
+	return self.app.Run()
+}
+
+// tuiLogWriter shows log messages in the event log.
+type tuiLogWriter struct {
+	dashboard *Dashboard
+}
+
+func (self *tuiLogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSpace(string(p))
+
+	go self.dashboard.app.QueueUpdateDraw(func() {
+		self.dashboard.logf("%s", line)
+	})
+
+	return len(p), nil
+}
+
+// refresh queries the state of everything shown on the dashboard.
+func (self *Dashboard) refresh() {
+	codes := make([]string, 0)
+
+	for _, zone := range tuiZones {
+		codes = append(codes, zoneControls[zone].Codes()...)
+	}
+
+	codes = append(codes, onkyo.NowPlayingCodes...)
+	codes = append(codes, `IFA`, `IFV`)
+
+	for _, code := range codes {
+		if err := self.device.Send(code, `QSTN`); err != nil {
+			log.Warningf("Failed to query %s: %v", code, err)
+			return
+		}
+	}
+}
+
+func (self *Dashboard) update(message onkyo.Message) {
+	if _, err := self.np.Update(message); err != nil {
+		log.Debugf("Now playing: %v", err)
+	}
+
+	switch message.Code() {
+	case `NLT`, `NLS`, `NJA`:
+		// too chatty to be worth logging
+	default:
+		self.logf("%s", formatDecoded(DecodeMessage(message)))
+	}
+
+	self.render()
+}
+
+func (self *Dashboard) logf(format string, args ...interface{}) {
+	fmt.Fprintf(self.events, "%s  %s\n", time.Now().Format(`15:04:05`), fmt.Sprintf(format, args...))
+	self.events.ScrollToEnd()
+}
+
+func (self *Dashboard) render() {
+	for i, zone := range tuiZones {
+		panel := self.zones[zone]
+		panel.SetText(self.renderZone(zone))
+
+		if i == self.selected {
+			panel.SetBorderColor(tcell.ColorYellow).SetTitleColor(tcell.ColorYellow)
+		} else {
+			panel.SetBorderColor(tcell.ColorWhite).SetTitleColor(tcell.ColorWhite)
+		}
+	}
+
+	self.nowPlaying.SetText(renderNowPlaying(self.np.Current()))
+	self.signal.SetText(self.renderSignal())
+}
+
+// current returns the decoded value of the given code, or "-" if it isn't known.
+func (self *Dashboard) current(code string) string {
+	if code == `` {
+		return `-`
+	}
+
+	if message, ok := self.device.State().Get(code); ok {
+		decoded := DecodeMessage(message)
+
+		if decoded.Decoded != `` {
+			return decoded.Decoded
+		} else if decoded.Value != `` {
+			return decoded.Value
+		}
+	}
+
+	return `-`
+}
+
+func (self *Dashboard) renderZone(zone string) string {
+	controls := zoneControls[zone]
+	lines := []string{
+		fmt.Sprintf("Power:  %s", self.current(controls.Power)),
+		fmt.Sprintf("Volume: %s", self.current(controls.Volume)),
+		fmt.Sprintf("Mute:   %s", self.current(controls.Mute)),
+		fmt.Sprintf("Input:  %s", self.current(controls.Input)),
+		fmt.Sprintf("Mode:   %s", self.current(controls.ListeningMode)),
+	}
+
+	if volume, err := strconv.Atoi(self.current(controls.Volume)); err == nil {
+		lines[1] = fmt.Sprintf("Volume: %3d %s", volume, volumeBar(controls.Volume, volume))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (self *Dashboard) renderSignal() string {
+	lines := make([]string, 0)
+
+	if message, ok := self.device.State().Get(`IFA`); ok {
+		if audio, err := onkyo.ParseAudioInfo(message.Value()); err == nil {
+			lines = append(lines,
+				fmt.Sprintf("Audio:  %s %s via %s", audio.InputFormat, audio.InputChannels, audio.InputPort),
+				fmt.Sprintf("        %d Hz, %s -> %s", audio.SampleRate, audio.ListeningMode, audio.OutputChannels))
+		}
+	}
+
+	if message, ok := self.device.State().Get(`IFV`); ok {
+		if video, err := onkyo.ParseVideoInfo(message.Value()); err == nil {
+			lines = append(lines,
+				fmt.Sprintf("Video:  %v %s %s via %s", video.InputResolution, video.InputColorSpace, video.InputColorDepth, video.InputPort),
+				fmt.Sprintf("        -> %v on %s", video.OutputResolution, video.OutputPort))
+
+			if video.PictureMode != `` {
+				lines = append(lines, fmt.Sprintf("        picture mode: %s", video.PictureMode))
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		return `No signal information`
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func renderNowPlaying(info onkyo.NowPlayingInfo) string {
+	if info.Title == `` && info.Artist == `` && info.Album == `` {
+		return `Nothing playing`
+	}
+
+	return strings.Join([]string{
+		fmt.Sprintf("Title:  %s", info.Title),
+		fmt.Sprintf("Artist: %s", info.Artist),
+		fmt.Sprintf("Album:  %s", info.Album),
+		fmt.Sprintf("Time:   %s / %s", formatClock(info.Elapsed), formatClock(info.Total)),
+		fmt.Sprintf("Track:  %d of %d", info.Track, info.Tracks),
+		fmt.Sprintf("State:  %s (repeat: %s, shuffle: %s)", info.State, info.Repeat, info.Shuffle),
+	}, "\n")
+}
+
+// volumeBar draws the volume as a proportion of the maximum the command accepts.
+func volumeBar(code string, volume int) string {
+	max := int64(100)
+
+	if cmd, ok := codeToCmd[code]; ok && cmd != nil {
+		for i := range cmd.Values {
+			if _, high, ok := cmd.Values[i].numericRange(); ok {
+				max = high
+				break
+			}
+		}
+	}
+
+	filled := int(int64(volume) * DEFAULT_TUI_VOLUME_BAR_WIDTH / max)
+
+	if filled > DEFAULT_TUI_VOLUME_BAR_WIDTH {
+		filled = DEFAULT_TUI_VOLUME_BAR_WIDTH
+	}
+
+	return strings.Repeat(`█`, filled) + strings.Repeat(`░`, DEFAULT_TUI_VOLUME_BAR_WIDTH-filled)
+}
+
+func (self *Dashboard) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	controls := zoneControls[tuiZones[self.selected]]
+
+	switch event.Key() {
+	case tcell.KeyTab:
+		self.selected = (self.selected + 1) % len(tuiZones)
+	case tcell.KeyBacktab:
+		self.selected = (self.selected + len(tuiZones) - 1) % len(tuiZones)
+	case tcell.KeyUp:
+		self.send(controls.Volume, `UP`)
+	case tcell.KeyDown:
+		self.send(controls.Volume, `DOWN`)
+	case tcell.KeyRight:
+		self.cycle(controls.Input, 1)
+	case tcell.KeyLeft:
+		self.cycle(controls.Input, -1)
+	case tcell.KeyEscape:
+		self.app.Stop()
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case '+', '=':
+			self.send(controls.Volume, `UP`)
+		case '-':
+			self.send(controls.Volume, `DOWN`)
+		case ']':
+			self.cycle(controls.Input, 1)
+		case '[':
+			self.cycle(controls.Input, -1)
+		case 'm':
+			self.send(controls.Mute, `TG`)
+		case 'p':
+			if self.current(controls.Power) == `on` {
+				self.send(controls.Power, `00`)
+			} else {
+				self.send(controls.Power, `01`)
+			}
+		case 'l':
+			self.cycle(controls.ListeningMode, 1)
+		case 'r':
+			go self.refresh()
+		case 'q':
+			self.app.Stop()
+		default:
+			return event
+		}
+	default:
+		return event
+	}
+
+	self.render()
+	return nil
+}
+
+func (self *Dashboard) send(code string, value string) {
+	if code == `` {
+		self.logf("Not supported in this zone")
+		return
+	}
+
+	if err := self.device.Send(code, value); err != nil {
+		self.logf("Failed to send %s%s: %v", code, value, err)
+	}
+}
+
+// cycle selects the next (or previous) value of a command with a fixed set of choices.
+func (self *Dashboard) cycle(code string, direction int) {
+	if code == `` {
+		self.logf("Not supported in this zone")
+		return
+	}
+
+	cmd, ok := codeToCmd[code]
+
+	if !ok || cmd == nil {
+		return
+	}
+
+	choices := cmd.Choices()
+
+	if len(choices) == 0 {
+		return
+	}
+
+	next := 0
+
+	if direction < 0 {
+		next = len(choices) - 1
+	}
+
+	current := self.current(code)
+
+	for i, choice := range choices {
+		if choice == current {
+			next = (i + direction + len(choices)) % len(choices)
+			break
+		}
+	}
+
+	if value, err := primaryTarget().Aliases.Encode(cmd, choices[next]); err == nil {
+		self.send(code, value)
+	} else {
+		self.logf("%v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/ghetzel/cli"
+	"github.com/ghetzel/onkyo-remote"
+)
+
+// PresetEntry is a single tuner preset, as exported to and imported from a file.
+type PresetEntry struct {
+	Preset    int        `json:"preset"`
+	Band      onkyo.Band `json:"band"`
+	Frequency float64    `json:"frequency"`
+}
+
+// exportPresets recalls every preset in turn to find out what it is tuned to, then returns to
+// the original frequency.
+func exportPresets(tuner *onkyo.Tuner) ([]PresetEntry, error) {
+	original, err := tuner.Frequency()
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]PresetEntry, 0)
+
+	for n := 1; n <= onkyo.MaxPreset; n++ {
+		if freq, err := tuner.Preset(n); err == nil {
+			entries = append(entries, PresetEntry{
+				Preset:    n,
+				Band:      freq.Band,
+				Frequency: freq.Value,
+			})
+		} else {
+			log.Debugf("Skipping preset %d: %v", n, err)
+		}
+	}
+
+	if _, err := tuner.Tune(original.Band, original.Value); err != nil {
+		log.Warningf("Failed to restore frequency %v: %v", original, err)
+	}
+
+	return entries, nil
+}
+
+func importPresets(tuner *onkyo.Tuner, entries []PresetEntry) error {
+	for _, entry := range entries {
+		if _, err := tuner.Tune(entry.Band, entry.Frequency); err != nil {
+			return fmt.Errorf("Preset %d: %v", entry.Preset, err)
+		}
+
+		if err := tuner.StorePreset(entry.Preset); err != nil {
+			return fmt.Errorf("Preset %d: %v", entry.Preset, err)
+		}
+
+		log.Infof("Stored preset %d: %s %v", entry.Preset, entry.Band, entry.Frequency)
+	}
+
+	return nil
+}
+
+func tunerCommands() []cli.Command {
+	newTuner := func(c *cli.Context) *onkyo.Tuner {
+		return onkyo.NewTuner(device, c.GlobalDuration(`response-timeout`))
+	}
+
+	presetArg := func(c *cli.Context) int {
+		if n, err := strconv.Atoi(c.Args().First()); err == nil {
+			return n
+		} else {
+			log.Fatalf("Must specify a preset number")
+			return 0
+		}
+	}
+
+	return []cli.Command{
+		{
+			Name:  `frequency`,
+			Usage: `Show the current band and frequency`,
+			Action: func(c *cli.Context) {
+				if freq, err := newTuner(c).Frequency(); err == nil {
+					fmt.Printf("%s\t%v\n", freq.Band, freq)
+				} else {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:      `tune`,
+			Usage:     `Tune to a frequency (MHz for FM, kHz for AM)`,
+			ArgsUsage: `BAND FREQUENCY`,
+			Action: func(c *cli.Context) {
+				if band, err := onkyo.ParseBand(c.Args().First()); err == nil {
+					if value, err := strconv.ParseFloat(c.Args().Get(1), 64); err == nil {
+						if freq, err := newTuner(c).Tune(band, value); err == nil {
+							fmt.Printf("%s\t%v\n", freq.Band, freq)
+						} else {
+							log.Fatal(err)
+						}
+					} else {
+						log.Fatalf("Invalid frequency %q", c.Args().Get(1))
+					}
+				} else {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:      `preset`,
+			Usage:     `Recall a preset`,
+			ArgsUsage: `NUMBER`,
+			Action: func(c *cli.Context) {
+				if freq, err := newTuner(c).Preset(presetArg(c)); err == nil {
+					fmt.Printf("%s\t%v\n", freq.Band, freq)
+				} else {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:      `store`,
+			Usage:     `Store the current frequency as a preset`,
+			ArgsUsage: `NUMBER`,
+			Action: func(c *cli.Context) {
+				if err := newTuner(c).StorePreset(presetArg(c)); err != nil {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:  `rds`,
+			Usage: `Show the RDS radio text and programme type`,
+			Action: func(c *cli.Context) {
+				if info, err := newTuner(c).RDS(); err == nil {
+					fmt.Printf("%s\t%d\t%s\n", info.Text, info.PTY, info.PTYName)
+				} else {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:      `export`,
+			Usage:     `Write all presets to a file (or standard output)`,
+			ArgsUsage: `[FILE]`,
+			Action: func(c *cli.Context) {
+				var output io.Writer = os.Stdout
+
+				if filename := c.Args().First(); filename != `` {
+					if file, err := os.Create(filename); err == nil {
+						defer file.Close()
+						output = file
+					} else {
+						log.Fatal(err)
+					}
+				}
+
+				if entries, err := exportPresets(newTuner(c)); err == nil {
+					encoder := json.NewEncoder(output)
+					encoder.SetIndent(``, `  `)
+
+					if err := encoder.Encode(entries); err != nil {
+						log.Fatal(err)
+					}
+				} else {
+					log.Fatal(err)
+				}
+			},
+		}, {
+			Name:      `import`,
+			Usage:     `Restore presets from a file written by "export"`,
+			ArgsUsage: `FILE`,
+			Action: func(c *cli.Context) {
+				var entries []PresetEntry
+
+				if file, err := os.Open(c.Args().First()); err == nil {
+					defer file.Close()
+
+					if err := json.NewDecoder(file).Decode(&entries); err != nil {
+						log.Fatal(err)
+					}
+				} else {
+					log.Fatal(err)
+				}
+
+				if err := importPresets(newTuner(c), entries); err != nil {
+					log.Fatal(err)
+				}
+			},
+		},
+	}
+}
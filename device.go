@@ -1,11 +1,24 @@
 package onkyo
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const DEFAULT_MESSAGE_BUFFER = 64
+const DEFAULT_RECONNECT_MIN_BACKOFF = time.Duration(1) * time.Second
+const DEFAULT_RECONNECT_MAX_BACKOFF = time.Duration(30) * time.Second
+
+var ErrResponseTimeout = errors.New(`Timed out waiting for response`)
+
 type IDevice interface {
 	Info() DeviceInfo
 	Address() net.Addr
@@ -27,6 +40,19 @@ func (d DeviceCategory) String() string {
 	return string(d)
 }
 
+func (d DeviceCategory) MarshalText() ([]byte, error) {
+	return []byte{byte(d)}, nil
+}
+
+func (d *DeviceCategory) UnmarshalText(text []byte) error {
+	if len(text) != 1 {
+		return fmt.Errorf("Invalid device category %q", text)
+	}
+
+	*d = DeviceCategory(text[0])
+	return nil
+}
+
 const (
 	CategoryDevice = DeviceCategory('1') // Denotes receivers.
 	CategoryAny    = DeviceCategory('x') // Used for discovery
@@ -34,27 +60,204 @@ const (
 
 type Device struct {
 	IDevice
-	conn   net.Conn
-	info   DeviceInfo
-	recv   chan Message
-	remote net.Addr
+	transport     Transport
+	info          DeviceInfo
+	recv          chan Message
+	remote        net.Addr
+	state         *State
+	stats         *Stats
+	subscribers   map[chan Message]bool
+	subLock       sync.Mutex
+	autoReconnect int32
+	closed        int32
+	connected     int32
+	sendFilter    func(*Device, string, string) (string, error)
+	watchers      []func(connected bool)
 }
 
+// DeviceOptions controls how a device is connected to.  The zero value describes an eISCP
+// connection without automatic reconnection.
+type DeviceOptions struct {
+	// Address is reported by Address(); when unset, the remote address of the connection is
+	// used if it is a net.Conn.
+	Address net.Addr
+
+	// Framing is the wire format used by NewDeviceFromConn.
+	Framing Framing
+
+	AutoReconnect bool
+
+	// Recorder, if set, records every message sent to and received from the device.
+	Recorder *Recorder
+
+	// SendFilter, if set, is called before each message is sent.  It may change the parameter,
+	// or return an error to prevent the message from being sent at all.
+	SendFilter func(device *Device, cmd string, param string) (string, error)
+}
+
+// NewDevice connects to a device over eISCP (TCP).
 func NewDevice(addr net.Addr, info DeviceInfo) (*Device, error) {
-	if conn, err := net.Dial(`tcp`, addr.String()); err == nil {
-		d := &Device{
-			conn:   conn,
-			info:   info,
-			recv:   make(chan Message),
-			remote: addr,
+	return NewDeviceFromTransport(NewTCPTransport(addr.String()), info, &DeviceOptions{
+		Address: addr,
+	})
+}
+
+// Connect connects directly to the device at the given address ("host" or "host:port", the
+// port defaulting to DEFAULT_DISCOVERY_PORT) without performing discovery, for devices that
+// don't answer discovery requests.  If infoTimeout is non-zero, the device is then asked for
+// its model information with an ECN query; devices that don't reply are still returned.
+func Connect(address string, infoTimeout time.Duration, opts *DeviceOptions) (*Device, error) {
+	options := DeviceOptions{}
+
+	if opts != nil {
+		options = *opts
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(DEFAULT_DISCOVERY_PORT))
+	}
+
+	addr, err := net.ResolveTCPAddr(`tcp`, address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options.Address = addr
+
+	device, err := NewDeviceFromTransport(NewTCPTransport(addr.String()), DeviceInfo{
+		Port: addr.Port,
+	}, &options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if infoTimeout > 0 {
+		if info, err := device.queryInfo(infoTimeout); err == nil {
+			device.info = info
+		} else {
+			log.Debugf("Failed to retrieve device information from %s: %v", addr.String(), err)
 		}
+	}
 
-		go d.listen()
+	return device, nil
+}
 
-		return d, nil
-	} else {
+// queryInfo asks the device to describe itself, the same way it would in reply to discovery.
+func (self *Device) queryInfo(timeout time.Duration) (DeviceInfo, error) {
+	var info DeviceInfo
+
+	sub := self.Subscribe()
+	defer self.Unsubscribe(sub)
+
+	if err := self.transport.WriteFrame(Message(`!` + CategoryAny.String() + `ECNQSTN`)); err != nil {
+		return info, err
+	}
+
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case message, ok := <-sub:
+			if !ok {
+				return info, errors.New(`Connection closed`)
+			}
+
+			if message.Code() == `ECN` {
+				err := parseDeviceInfo([]byte(message), &info)
+				return info, err
+			}
+		case <-deadline:
+			return info, ErrResponseTimeout
+		}
+	}
+}
+
+// NewDeviceFromConn talks to a device over an already-established connection, such as a
+// forwarded socket or one end of a net.Pipe.  Such devices cannot be reconnected to.
+func NewDeviceFromConn(rw io.ReadWriteCloser, info DeviceInfo, opts *DeviceOptions) (*Device, error) {
+	if opts == nil {
+		opts = &DeviceOptions{}
+	}
+
+	if opts.Address == nil {
+		if conn, ok := rw.(net.Conn); ok {
+			opts.Address = conn.RemoteAddr()
+		} else {
+			opts.Address = connAddr(fmt.Sprintf("%T", rw))
+		}
+	}
+
+	return NewDeviceFromTransport(NewConnTransport(rw, opts.Framing), info, opts)
+}
+
+// NewDeviceFromTransport dials the given transport and starts receiving messages from it.
+func NewDeviceFromTransport(transport Transport, info DeviceInfo, opts *DeviceOptions) (*Device, error) {
+	if opts == nil {
+		opts = &DeviceOptions{}
+	}
+
+	if info.Category == 0 {
+		info.Category = CategoryDevice
+	}
+
+	if err := transport.Dial(); err != nil {
 		return nil, err
 	}
+
+	if opts.Recorder != nil {
+		address := ``
+
+		if opts.Address != nil {
+			address = opts.Address.String()
+		}
+
+		opts.Recorder.Record(RecordedFrame{
+			Address:   address,
+			Direction: DirectionOpen,
+			Device:    &info,
+		})
+
+		transport = &recordingTransport{
+			Transport: transport,
+			recorder:  opts.Recorder,
+			address:   address,
+		}
+	}
+
+	d := &Device{
+		transport:   transport,
+		info:        info,
+		recv:        make(chan Message, DEFAULT_MESSAGE_BUFFER),
+		remote:      opts.Address,
+		state:       NewState(),
+		stats:       NewStats(),
+		subscribers: make(map[chan Message]bool),
+		sendFilter:  opts.SendFilter,
+	}
+
+	if d.remote == nil {
+		d.remote = connAddr(`unknown`)
+	}
+
+	d.SetAutoReconnect(opts.AutoReconnect)
+	atomic.StoreInt32(&d.connected, 1)
+
+	go d.listen()
+
+	return d, nil
+}
+
+// connAddr is the address of a device reached over a connection that isn't a net.Conn.
+type connAddr string
+
+func (self connAddr) Network() string {
+	return `stream`
+}
+
+func (self connAddr) String() string {
+	return string(self)
 }
 
 func (self *Device) Info() DeviceInfo {
@@ -69,52 +272,262 @@ func (self *Device) Messages() <-chan Message {
 	return self.recv
 }
 
+// State returns the most recently-received message for every command code seen so far.
+func (self *Device) State() *State {
+	return self.state
+}
+
+// Stats returns counters describing the health of the connection to the device.
+func (self *Device) Stats() *Stats {
+	return self.stats
+}
+
+// SetAutoReconnect controls whether the connection to the device is re-established when it
+// is lost.  When disabled (the default), the Messages() channel is closed when the connection
+// drops.
+func (self *Device) SetAutoReconnect(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&self.autoReconnect, 1)
+	} else {
+		atomic.StoreInt32(&self.autoReconnect, 0)
+	}
+}
+
+// Connected returns whether the connection to the device is currently established.
+func (self *Device) Connected() bool {
+	return atomic.LoadInt32(&self.connected) == 1
+}
+
+// OnConnectionChange registers a function that is called whenever the connection to the
+// device is lost or re-established.  It is called from the goroutine receiving messages, so
+// it must not block.
+func (self *Device) OnConnectionChange(fn func(connected bool)) {
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+
+	self.watchers = append(self.watchers, fn)
+}
+
+func (self *Device) setConnected(connected bool) {
+	value := int32(0)
+
+	if connected {
+		value = 1
+	}
+
+	if atomic.SwapInt32(&self.connected, value) == value {
+		return
+	}
+
+	self.subLock.Lock()
+	watchers := self.watchers
+	self.subLock.Unlock()
+
+	for _, fn := range watchers {
+		fn(connected)
+	}
+}
+
+// Close disconnects from the device.
+func (self *Device) Close() error {
+	atomic.StoreInt32(&self.closed, 1)
+
+	return self.transport.Close()
+}
+
+// Subscribe returns a channel that receives every message from the device, independent of
+// the channel returned by Messages().  Slow subscribers will miss messages rather than block
+// other consumers.
+func (self *Device) Subscribe() chan Message {
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+
+	sub := make(chan Message, DEFAULT_MESSAGE_BUFFER)
+	self.subscribers[sub] = true
+
+	return sub
+}
+
+func (self *Device) Unsubscribe(sub chan Message) {
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+
+	if _, ok := self.subscribers[sub]; ok {
+		delete(self.subscribers, sub)
+		close(sub)
+	}
+}
+
 func (self *Device) Send(cmd string, params ...string) error {
-	cmdstring := cmd + strings.Join(params, ``)
-	packet := encodePacket(cmdstring, self.info.Category)
-	_, err := self.conn.Write(packet.bytes())
+	param := strings.Join(params, ``)
+
+	if self.sendFilter != nil {
+		if p, err := self.sendFilter(self, cmd, param); err == nil {
+			param = p
+		} else {
+			return err
+		}
+	}
+
+	message := Message(`!` + self.info.Category.String() + cmd + param)
+
+	err := self.transport.WriteFrame(message)
+
+	if err != nil {
+		atomic.AddUint64(&self.stats.sendErrors, 1)
+	}
+
 	return err
 }
 
+// Request sends a command and waits for the device to reply with a message for the same
+// command code, returning ErrResponseTimeout if none arrives in time.
+func (self *Device) Request(timeout time.Duration, cmd string, params ...string) (Message, error) {
+	sub := self.Subscribe()
+	defer self.Unsubscribe(sub)
+
+	if err := self.Send(cmd, params...); err != nil {
+		return ``, err
+	}
+
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case message, ok := <-sub:
+			if !ok {
+				return ``, errors.New(`Connection closed`)
+			}
+
+			if message.Code() == cmd {
+				return message, nil
+			}
+		case <-deadline:
+			return ``, ErrResponseTimeout
+		}
+	}
+}
+
+// Query requests the current value of the given command code (using "QSTN").
+func (self *Device) Query(timeout time.Duration, cmd string) (Message, error) {
+	return self.Request(timeout, cmd, `QSTN`)
+}
+
+func (self *Device) publish(message Message) {
+	self.subLock.Lock()
+	defer self.subLock.Unlock()
+
+	for sub := range self.subscribers {
+		select {
+		case sub <- message:
+		default:
+			log.Warningf("Subscriber is not keeping up, dropped message %q", message)
+		}
+	}
+}
+
+// enqueue delivers a message to the Messages() channel, discarding the oldest unread message
+// if nothing is consuming them.
+func (self *Device) enqueue(message Message) {
+	for {
+		select {
+		case self.recv <- message:
+			return
+		default:
+			select {
+			case dropped := <-self.recv:
+				log.Debugf("Message buffer full, dropped message %q", dropped)
+			default:
+			}
+		}
+	}
+}
+
+// reconnect attempts to re-establish the connection to the device until it succeeds or the
+// device is closed.
+func (self *Device) reconnect() bool {
+	backoff := DEFAULT_RECONNECT_MIN_BACKOFF
+
+	for atomic.LoadInt32(&self.closed) == 0 {
+		if err := self.transport.Dial(); err == nil {
+			atomic.AddUint64(&self.stats.reconnects, 1)
+			log.Noticef("Reconnected to %s", self.remote.String())
+			return true
+		} else {
+			if err == ErrCannotRedial {
+				return false
+			}
+
+			log.Warningf("Failed to reconnect to %s, retrying in %v: %v", self.remote.String(), backoff, err)
+			time.Sleep(backoff)
+
+			if backoff *= 2; backoff > DEFAULT_RECONNECT_MAX_BACKOFF {
+				backoff = DEFAULT_RECONNECT_MAX_BACKOFF
+			}
+		}
+	}
+
+	return false
+}
+
 func (self *Device) listen() {
 	runtime.SetFinalizer(self, func(self *Device) {
-		self.conn.Close()
+		self.transport.Close()
 	})
 
-	data := make([]byte, maxPacketSize)
 	var lastMessage Message
 
 	for {
-		if datalen, err := self.conn.Read(data); err == nil {
-			packets, err := decodePackets(data[:datalen])
+		if message, err := self.transport.ReadFrame(); err == nil {
+			self.stats.messageReceived(message.Code())
 
-			if err != nil {
-				log.Warningf("Failed to decode packet: %v", err)
-			}
-
-			for _, pkt := range packets {
-				message := pkt.Message()
+			switch message.Code() {
+			case `NLT`, `NLS`:
+				// list updates are very chatty, so they are only delivered to subscribers
+				self.publish(message)
+			case `ECN`:
+				// device information (see Connect) isn't state
+				self.publish(message)
+			default:
+				self.state.Set(message)
+				self.publish(message)
 
-				switch message.Code() {
-				case `NLT`, `NLS`:
-					continue
-				default:
-					msg := pkt.Message()
+				if message != lastMessage {
+					self.enqueue(message)
+				}
 
-					if msg != lastMessage {
-						self.recv <- msg
-					}
+				lastMessage = message
+			}
+		} else if _, ok := err.(*DecodeError); ok {
+			atomic.AddUint64(&self.stats.decodeFailures, 1)
+			log.Warningf("Failed to decode packet: %v", err)
+		} else if atomic.LoadInt32(&self.closed) == 0 && atomic.LoadInt32(&self.autoReconnect) == 1 {
+			log.Warningf("Lost connection to %s: %v", self.remote.String(), err)
+			self.setConnected(false)
 
-					lastMessage = msg
-				}
+			if !self.reconnect() {
+				break
 			}
+
+			self.setConnected(true)
 		} else {
-			log.Errorf("Failed to read response: %v", err)
+			if atomic.LoadInt32(&self.closed) == 0 {
+				log.Errorf("Failed to read response: %v", err)
+			}
+
 			break
 		}
 	}
 
 	runtime.SetFinalizer(self, nil)
-	self.conn.Close()
+	self.transport.Close()
+	self.setConnected(false)
 	close(self.recv)
+
+	self.subLock.Lock()
+	for sub := range self.subscribers {
+		delete(self.subscribers, sub)
+		close(sub)
+	}
+	self.subLock.Unlock()
 }
@@ -0,0 +1,274 @@
+package onkyo
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipeReceiver is the receiver's end of a net.Pipe connected to a Device.
+type pipeReceiver struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newPipeDevice(t *testing.T, opts *DeviceOptions) (*Device, *pipeReceiver) {
+	client, server := net.Pipe()
+
+	device, err := NewDeviceFromConn(client, DeviceInfo{Model: `TX-TEST`}, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		device.Close()
+		server.Close()
+	})
+
+	return device, &pipeReceiver{
+		conn:   server,
+		reader: bufio.NewReader(server),
+	}
+}
+
+func (self *pipeReceiver) read(t *testing.T) Message {
+	self.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	if message, err := readEISCPFrame(self.reader); err == nil {
+		return message
+	} else {
+		t.Errorf("Failed to read from device: %v", err)
+		return ``
+	}
+}
+
+func (self *pipeReceiver) write(t *testing.T, messages ...string) {
+	self.conn.SetWriteDeadline(time.Now().Add(time.Second))
+
+	for _, message := range messages {
+		if _, err := self.conn.Write(encodePacket(message, CategoryDevice).bytes()); err != nil {
+			t.Errorf("Failed to write to device: %v", err)
+		}
+	}
+}
+
+// reply answers the next message from the device with the given replies.
+func (self *pipeReceiver) reply(t *testing.T, replies ...string) chan Message {
+	received := make(chan Message, 1)
+
+	go func() {
+		received <- self.read(t)
+		self.write(t, replies...)
+	}()
+
+	return received
+}
+
+func TestDeviceSendFraming(t *testing.T) {
+	device, receiver := newPipeDevice(t, nil)
+	received := make(chan []byte, 1)
+
+	go func() {
+		data := make([]byte, 26)
+		receiver.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		if _, err := io.ReadFull(receiver.conn, data); err != nil {
+			t.Errorf("Failed to read from device: %v", err)
+		}
+
+		received <- data
+	}()
+
+	if err := device.Send(`PWR`, `01`); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte("ISCP\x00\x00\x00\x10\x00\x00\x00\x0a\x01\x00\x00\x00!1PWR01\x00\r\n")
+
+	if data := <-received; !bytes.Equal(data, expected) {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestDeviceReceiveFraming(t *testing.T) {
+	device, receiver := newPipeDevice(t, nil)
+
+	go func() {
+		// garbage preceding a packet is skipped
+		receiver.conn.Write([]byte(`noise`))
+		receiver.write(t, `PWR01`, `MVL2A`)
+	}()
+
+	for _, expected := range []Message{`!1PWR01`, `!1MVL2A`} {
+		select {
+		case message := <-device.Messages():
+			if message != expected {
+				t.Errorf("expected %q, got %q", expected, message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+
+	if message, ok := device.State().Get(`MVL`); !ok || message.Value() != `2A` {
+		t.Errorf("expected MVL2A in state, got %q", message)
+	}
+
+	if failures := device.Stats().DecodeFailures(); failures != 1 {
+		t.Errorf("expected 1 decode failure, got %d", failures)
+	}
+}
+
+func TestDeviceRequest(t *testing.T) {
+	device, receiver := newPipeDevice(t, nil)
+
+	// replies for other commands are ignored
+	sent := receiver.reply(t, `MVL20`, `SLI10`, `PWR01`)
+
+	if message, err := device.Request(time.Second, `PWR`, `01`); err != nil {
+		t.Fatal(err)
+	} else if message != `!1PWR01` {
+		t.Errorf("expected !1PWR01, got %q", message)
+	}
+
+	if message := <-sent; message != `!1PWR01` {
+		t.Errorf("expected the device to be sent !1PWR01, got %q", message)
+	}
+
+	sent = receiver.reply(t, `MVL2A`)
+
+	if message, err := device.Query(time.Second, `MVL`); err != nil {
+		t.Fatal(err)
+	} else if message.Value() != `2A` {
+		t.Errorf("expected 2A, got %q", message.Value())
+	}
+
+	if message := <-sent; message != `!1MVLQSTN` {
+		t.Errorf("expected the device to be sent !1MVLQSTN, got %q", message)
+	}
+}
+
+func TestDeviceRequestTimeout(t *testing.T) {
+	device, receiver := newPipeDevice(t, nil)
+	sent := receiver.reply(t, `MVL20`)
+
+	start := time.Now()
+
+	if _, err := device.Request(100*time.Millisecond, `PWR`, `QSTN`); err != ErrResponseTimeout {
+		t.Errorf("expected ErrResponseTimeout, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("returned after %v, before the timeout", elapsed)
+	}
+
+	<-sent
+}
+
+func TestConnTransportCannotRedial(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	transport := NewConnTransport(client, FramingEISCP)
+
+	if err := transport.Dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transport.Dial(); err != ErrCannotRedial {
+		t.Errorf("expected ErrCannotRedial, got %v", err)
+	}
+}
+
+func TestDeviceFromConnDoesNotReconnect(t *testing.T) {
+	device, receiver := newPipeDevice(t, &DeviceOptions{
+		AutoReconnect: true,
+	})
+
+	changes := make(chan bool, 1)
+	device.OnConnectionChange(func(connected bool) {
+		changes <- connected
+	})
+
+	receiver.conn.Close()
+
+	select {
+	case _, ok := <-device.Messages():
+		if ok {
+			t.Errorf("expected the message channel to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for the device to give up reconnecting")
+	}
+
+	if device.Connected() {
+		t.Errorf("expected the device to be disconnected")
+	} else if connected := <-changes; connected {
+		t.Errorf("expected a change to disconnected")
+	}
+}
+
+func TestDeviceConnectionChange(t *testing.T) {
+	listener, err := net.Listen(`tcp`, `127.0.0.1:0`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	conns := make(chan net.Conn, 2)
+
+	go func() {
+		for {
+			if conn, err := listener.Accept(); err == nil {
+				conns <- conn
+			} else {
+				return
+			}
+		}
+	}()
+
+	device, err := NewDeviceFromTransport(NewTCPTransport(listener.Addr().String()), DeviceInfo{}, &DeviceOptions{
+		AutoReconnect: true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer device.Close()
+
+	changes := make(chan bool, 4)
+	device.OnConnectionChange(func(connected bool) {
+		changes <- connected
+	})
+
+	if !device.Connected() {
+		t.Errorf("expected the device to be connected")
+	}
+
+	// drop the connection; the device should notice and reconnect
+	(<-conns).Close()
+
+	for _, expected := range []bool{false, true} {
+		select {
+		case connected := <-changes:
+			if connected != expected {
+				t.Errorf("expected a change to connected=%v, got %v", expected, connected)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for a change to connected=%v", expected)
+		}
+	}
+
+	if !device.Connected() {
+		t.Errorf("expected the device to be reconnected")
+	}
+
+	(<-conns).Close()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/op/go-logging"
@@ -16,7 +17,13 @@ const DEFAULT_RESPONSE_TIMEOUT = time.Duration(3) * time.Second
 const DEFAULT_DISCOVERY_PORT = 60128
 
 func Discover(timeout time.Duration, discoveryRange string) ([]*Device, error) {
+	return DiscoverWithOptions(timeout, discoveryRange, nil)
+}
+
+// DiscoverWithOptions discovers devices, connecting to them using the given options.
+func DiscoverWithOptions(timeout time.Duration, discoveryRange string, opts *DeviceOptions) ([]*Device, error) {
 	d := NewDiscoverer(timeout)
+	d.DeviceOptions = opts
 
 	if discoveryRange != `` && discoveryRange != `auto` {
 		if strings.Contains(discoveryRange, `/`) {
@@ -48,6 +55,7 @@ func Discover(timeout time.Duration, discoveryRange string) ([]*Device, error) {
 type Discoverer struct {
 	Timeout        time.Duration
 	FirstOnly      bool
+	DeviceOptions  *DeviceOptions
 	listenAddr     *net.UDPAddr
 	discoveryRange *net.UDPAddr
 }
@@ -90,6 +98,7 @@ func (self *Discoverer) Perform() ([]*Device, error) {
 						// create a device from any response packets that aren't the reflected discovery packet
 						if responsePacket := packet(data[:msglen]); !responsePacket.Equals(discoverPacket) {
 							if device, err := self.createDeviceFromResponse(responsePacket, from); err == nil {
+								atomic.AddUint64(&discoveryResponders, 1)
 								devices = append(devices, device)
 
 								if self.FirstOnly {
@@ -128,7 +137,15 @@ func (self *Discoverer) createDeviceFromResponse(responsePacket packet, from *ne
 	var info DeviceInfo
 
 	if err := responsePacket.parseInfo(&info); err == nil {
-		if device, err := NewDevice(from, info); err == nil {
+		opts := DeviceOptions{}
+
+		if self.DeviceOptions != nil {
+			opts = *self.DeviceOptions
+		}
+
+		opts.Address = from
+
+		if device, err := NewDeviceFromTransport(NewTCPTransport(from.String()), info, &opts); err == nil {
 			return device, nil
 		} else {
 			return nil, err
@@ -137,3 +154,33 @@ func (self *Discoverer) createDeviceFromResponse(responsePacket packet, from *ne
 		return nil, err
 	}
 }
+
+// discoveryMessage returns the ECN message announcing a device reachable on the given port.
+func discoveryMessage(info DeviceInfo, port int) Message {
+	return Message(fmt.Sprintf("!%sECN%s/%05d/%s/%s", info.Category, info.Model, port, info.DestArea, info.Identifier))
+}
+
+func isDiscoveryRequest(message Message) bool {
+	return len(message) > 2 && message[2:] == `ECNQSTN`
+}
+
+// answerDiscovery replies to discovery requests received on the given connection with the
+// message returned by response, until the connection is closed.
+func answerDiscovery(conn *net.UDPConn, response func() Message) {
+	data := make([]byte, maxPacketSize)
+
+	for {
+		if n, from, err := conn.ReadFromUDP(data); err == nil {
+			if p := packet(data[:n]); p.validate() == nil && isDiscoveryRequest(p.Message()) {
+				message := response()
+				log.Debugf("Answering discovery request from %s", from.String())
+
+				if _, err := conn.WriteToUDP(encodePacket(string(message[2:]), DeviceCategory(message[1])).bytes(), from); err != nil {
+					log.Warningf("Failed to answer discovery request from %s: %v", from.String(), err)
+				}
+			}
+		} else {
+			return
+		}
+	}
+}
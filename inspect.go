@@ -0,0 +1,54 @@
+package onkyo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PacketInfo describes an eISCP packet found in captured data.
+type PacketInfo struct {
+	HeaderSize uint32
+	Length     int
+	Version    uint8
+	Reserved   []byte
+	Message    Message
+	Errors     []string
+	Raw        []byte
+}
+
+// Hexdump returns a hex dump of the entire packet.
+func (self *PacketInfo) Hexdump() string {
+	return packet(self.Raw).debug()
+}
+
+// InspectPackets decodes the eISCP packets in the given data, returning details of each
+// packet that was decoded along with any error that stopped decoding the remaining data.
+func InspectPackets(data []byte) ([]*PacketInfo, error) {
+	packets, err := decodePackets(data)
+	infos := make([]*PacketInfo, 0)
+
+	for _, p := range packets {
+		info := &PacketInfo{
+			HeaderSize: p.headerSize(),
+			Length:     p.messageLen(),
+			Version:    p.version(),
+			Reserved:   p[13:16].bytes(),
+			Message:    p.Message(),
+			Raw:        p.bytes(),
+		}
+
+		if err := p.validate(); err != nil {
+			info.Errors = append(info.Errors, err.Error())
+		}
+
+		if message := p.message(); len(message) < 2 || message[0] != '!' {
+			info.Errors = append(info.Errors, fmt.Sprintf("Message does not start with a start character and unit type: %q", message))
+		} else if !strings.ContainsAny(string(message[len(message)-1:]), terminator+"\x1a") {
+			info.Errors = append(info.Errors, fmt.Sprintf("Message is not terminated: %q", message))
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, err
+}
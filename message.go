@@ -1,12 +1,25 @@
 package onkyo
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Message string
 
 func (m Message) Code() string {
+	if len(m) < 5 {
+		return ``
+	}
+
 	return string(m[2:5])
 }
 
 func (m Message) Value() string {
+	if len(m) < 5 {
+		return ``
+	}
+
 	s := string(m[5:])
 
 	switch s {
@@ -16,3 +29,20 @@ func (m Message) Value() string {
 
 	return s
 }
+
+// ParseRawMessage splits a message typed by hand (e.g. "PWR01", "!1PWR01" or "!xPWR01") into
+// its command code and value.  The start character and device type are optional.
+func ParseRawMessage(raw string) (string, string, error) {
+	message := strings.TrimPrefix(strings.TrimSpace(raw), `!`)
+
+	// skip the device type, if given
+	if len(message) > 0 && (message[0] >= '0' && message[0] <= '9' || message[0] == 'x') {
+		message = message[1:]
+	}
+
+	if len(message) < 3 {
+		return ``, ``, fmt.Errorf("Malformed raw message %q", raw)
+	}
+
+	return message[:3], message[3:], nil
+}
@@ -0,0 +1,36 @@
+package onkyo
+
+import (
+	"testing"
+)
+
+func TestParseRawMessage(t *testing.T) {
+	tests := []struct {
+		raw   string
+		code  string
+		value string
+		err   bool
+	}{
+		{`PWR01`, `PWR`, `01`, false},
+		{`!1PWR01`, `PWR`, `01`, false},
+		{`!xPWR01`, `PWR`, `01`, false},
+		{`!4PWR01`, `PWR`, `01`, false},
+		{`1MVL2A`, `MVL`, `2A`, false},
+		{`PWR`, `PWR`, ``, false},
+		{` !1SLIQSTN `, `SLI`, `QSTN`, false},
+		{`!1PW`, ``, ``, true},
+		{`!1`, ``, ``, true},
+		{`!`, ``, ``, true},
+		{``, ``, ``, true},
+	}
+
+	for _, test := range tests {
+		code, value, err := ParseRawMessage(test.raw)
+
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error state: %v", test.raw, err)
+		} else if code != test.code || value != test.value {
+			t.Errorf("%q: expected %s/%s, got %s/%s", test.raw, test.code, test.value, code, value)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package onkyo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const NetListLines = 10
+
+// NetServices maps the two-character network service codes (as used by NSV and reported in
+// NLT) to their names.
+var NetServices = map[string]string{
+	`00`: `dlna`,
+	`01`: `favorites`,
+	`02`: `vtuner`,
+	`03`: `siriusxm`,
+	`04`: `pandora`,
+	`05`: `rhapsody`,
+	`06`: `last.fm`,
+	`07`: `napster`,
+	`08`: `slacker`,
+	`09`: `mediafly`,
+	`0A`: `spotify`,
+	`0B`: `aupeo`,
+	`0C`: `radiko`,
+	`0D`: `e-onkyo`,
+	`0E`: `tunein`,
+	`0F`: `mp3tunes`,
+	`10`: `simfy`,
+	`11`: `home-media`,
+	`12`: `deezer`,
+	`13`: `iheartradio`,
+	`18`: `airplay`,
+	`1A`: `onkyo-music`,
+	`1B`: `tidal`,
+	`41`: `fireconnect`,
+	`F0`: `usb-front`,
+	`F1`: `usb-rear`,
+	`F2`: `internet-radio`,
+	`F3`: `net`,
+	`FF`: `none`,
+}
+
+type NetUIType int
+
+const (
+	NetUIList NetUIType = iota
+	NetUIMenu
+	NetUIPlayback
+	NetUIPopup
+	NetUIKeyboard
+	NetUIMenuList
+)
+
+// NetListInfo is a single decoded NLS message: either the contents of one line of the list,
+// or an update to the cursor position.
+type NetListInfo struct {
+	Type       byte // 'A' (ASCII line), 'U' (Unicode line) or 'C' (cursor)
+	Line       int  // the line number (or cursor position), -1 for none
+	Property   byte
+	Text       string
+	PageUpdate bool
+}
+
+// ParseNetListInfo decodes the value of an NLS message, which is in the format "tlpnnnn...".
+func ParseNetListInfo(value string) (*NetListInfo, error) {
+	if len(value) < 3 {
+		return nil, fmt.Errorf("List info %q is too short", value)
+	}
+
+	info := &NetListInfo{
+		Type: value[0],
+		Line: -1,
+	}
+
+	if value[1] != '-' {
+		if line, err := strconv.Atoi(value[1:2]); err == nil {
+			info.Line = line
+		} else {
+			return nil, fmt.Errorf("Invalid line in list info %q", value)
+		}
+	}
+
+	switch info.Type {
+	case 'A', 'U':
+		info.Property = value[2]
+		info.Text = value[3:]
+	case 'C':
+		info.PageUpdate = (value[2] == 'P')
+	default:
+		return nil, fmt.Errorf("Unknown list info type %q", value[0])
+	}
+
+	return info, nil
+}
+
+// NetListTitle is a decoded NLT message, describing the list currently being displayed.
+type NetListTitle struct {
+	ServiceType string
+	Service     string
+	UIType      NetUIType
+	Layer       int
+	Cursor      int
+	Items       int
+	Layers      int
+	Title       string
+}
+
+// ParseNetListTitle decodes the value of an NLT message.  Newer models send a structured
+// header ("xxuycccciiiillsraabbss" followed by the title), older ones send only the title.
+func ParseNetListTitle(value string) (*NetListTitle, error) {
+	title := &NetListTitle{
+		Title: value,
+	}
+
+	if len(value) >= 22 {
+		if _, ok := NetServices[value[0:2]]; !ok {
+			return title, nil
+		}
+
+		var fields [5]int64
+
+		for i, field := range []string{value[2:3], value[3:4], value[4:8], value[8:12], value[12:14]} {
+			if v, err := strconv.ParseInt(field, 16, 32); err == nil {
+				fields[i] = v
+			} else {
+				return title, nil
+			}
+		}
+
+		title.ServiceType = value[0:2]
+		title.Service = NetServices[title.ServiceType]
+		title.UIType = NetUIType(fields[0])
+		title.Layer = int(fields[1])
+		title.Cursor = int(fields[2])
+		title.Items = int(fields[3])
+		title.Layers = int(fields[4])
+		title.Title = strings.TrimSpace(value[22:])
+	}
+
+	return title, nil
+}
+
+// NetMenu is the current state of the NET/USB list being displayed by the device.
+type NetMenu struct {
+	Title       string
+	Service     string
+	ServiceType string
+	UIType      NetUIType
+	Layer       int
+	Items       int
+	Cursor      int
+	Lines       []string
+}
+
+// NetBrowser tracks the NET/USB list displayed by the device and provides helpers for
+// navigating it.
+type NetBrowser struct {
+	// Timeout is how long to wait for the device to report the cursor after moving it.
+	Timeout time.Duration
+
+	device  *Device
+	menu    NetMenu
+	lock    sync.RWMutex
+	sub     chan Message
+	updates chan NetMenu
+	cursors chan NetListInfo
+}
+
+func NewNetBrowser(device *Device) *NetBrowser {
+	browser := &NetBrowser{
+		Timeout: DEFAULT_RESPONSE_TIMEOUT,
+		device:  device,
+		sub:     device.Subscribe(),
+		updates: make(chan NetMenu, 1),
+		cursors: make(chan NetListInfo, 1),
+		menu: NetMenu{
+			Cursor: -1,
+			Lines:  make([]string, NetListLines),
+		},
+	}
+
+	go func() {
+		for message := range browser.sub {
+			if browser.Update(message) {
+				browser.notify()
+			}
+		}
+
+		close(browser.updates)
+	}()
+
+	return browser
+}
+
+// Menu returns a copy of the current menu state.
+func (self *NetBrowser) Menu() NetMenu {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	menu := self.menu
+	menu.Lines = make([]string, len(self.menu.Lines))
+	copy(menu.Lines, self.menu.Lines)
+
+	return menu
+}
+
+// Updates returns a channel that receives the menu whenever it changes.  Only the most
+// recent state is kept if the receiver falls behind.
+func (self *NetBrowser) Updates() <-chan NetMenu {
+	return self.updates
+}
+
+// Update applies an NLS or NLT message to the menu, returning whether anything changed.
+func (self *NetBrowser) Update(message Message) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	switch message.Code() {
+	case `NLS`:
+		if info, err := ParseNetListInfo(message.Value()); err == nil {
+			switch info.Type {
+			case 'C':
+				if info.PageUpdate {
+					self.menu.Lines = make([]string, NetListLines)
+				}
+
+				self.menu.Cursor = info.Line
+
+				// only the latest position matters to anyone waiting on the cursor
+				select {
+				case <-self.cursors:
+				default:
+				}
+
+				self.cursors <- *info
+			default:
+				if info.Line >= 0 && info.Line < NetListLines {
+					self.menu.Lines[info.Line] = info.Text
+				}
+			}
+
+			return true
+		} else {
+			log.Debugf("Ignoring list info: %v", err)
+		}
+
+	case `NLT`:
+		if title, err := ParseNetListTitle(message.Value()); err == nil {
+			self.menu.Title = title.Title
+
+			if title.ServiceType != `` {
+				self.menu.Service = title.Service
+				self.menu.ServiceType = title.ServiceType
+				self.menu.UIType = title.UIType
+				self.menu.Layer = title.Layer
+				self.menu.Items = title.Items
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self *NetBrowser) notify() {
+	menu := self.Menu()
+
+	for {
+		select {
+		case self.updates <- menu:
+			return
+		default:
+			select {
+			case <-self.updates:
+			default:
+			}
+		}
+	}
+}
+
+// Refresh asks the device to resend the list title.
+func (self *NetBrowser) Refresh() error {
+	return self.device.Send(`NLT`, `QSTN`)
+}
+
+// SelectService switches directly to the given network service (e.g.: "dlna", "favorites",
+// "vtuner").
+func (self *NetBrowser) SelectService(name string) error {
+	for code, service := range NetServices {
+		if strings.EqualFold(service, name) || strings.EqualFold(code, name) {
+			return self.device.Send(`NSV`, code+`0`)
+		}
+	}
+
+	return fmt.Errorf("Unknown network service %q", name)
+}
+
+// Select moves the cursor to the given line and selects it.
+func (self *NetBrowser) Select(line int) error {
+	if line < 0 || line >= NetListLines {
+		return fmt.Errorf("Line must be between 0 and %d", NetListLines-1)
+	}
+
+	if err := self.moveCursor(line); err != nil {
+		return err
+	}
+
+	return self.key(`SELECT`)
+}
+
+// Back returns to the previous layer of the list.
+func (self *NetBrowser) Back() error {
+	return self.key(`RETURN`)
+}
+
+// Top returns to the top layer of the current service.
+func (self *NetBrowser) Top() error {
+	return self.key(`TOP`)
+}
+
+// PageDown moves the cursor past the last line, causing the device to display the next page.
+func (self *NetBrowser) PageDown() error {
+	return self.moveCursor(NetListLines)
+}
+
+// PageUp moves the cursor before the first line, causing the device to display the previous
+// page.
+func (self *NetBrowser) PageUp() error {
+	return self.moveCursor(-1)
+}
+
+func (self *NetBrowser) Close() {
+	self.device.Unsubscribe(self.sub)
+}
+
+// moveCursor presses up or down until the cursor reaches the given line, waiting for the
+// device to report where the cursor is after each key.  Moving past either end of the list
+// changes the page, which ends the move.
+func (self *NetBrowser) moveCursor(line int) error {
+	cursor := self.Menu().Cursor
+
+	if cursor < 0 {
+		if info, err := self.awaitCursor(`NLS`, `QSTN`); err == nil && info.Line >= 0 {
+			cursor = info.Line
+		} else {
+			log.Debugf("Cursor position unknown, assuming the first line")
+			cursor = 0
+		}
+	}
+
+	for moves := 0; cursor != line; moves++ {
+		key := `DOWN`
+
+		if cursor > line {
+			key = `UP`
+		}
+
+		if moves > NetListLines {
+			return fmt.Errorf("Cursor did not reach line %d", line)
+		}
+
+		if info, err := self.awaitCursor(`NTC`, key); err == nil {
+			if info.PageUpdate {
+				if line >= 0 && line < NetListLines {
+					return fmt.Errorf("List changed page before the cursor reached line %d", line)
+				}
+
+				return nil
+			}
+
+			cursor = info.Line
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// awaitCursor sends a command and waits for the device to report the cursor position.
+func (self *NetBrowser) awaitCursor(code string, param string) (NetListInfo, error) {
+	select {
+	case <-self.cursors:
+	default:
+	}
+
+	if err := self.device.Send(code, param); err != nil {
+		return NetListInfo{}, err
+	}
+
+	select {
+	case info := <-self.cursors:
+		return info, nil
+	case <-time.After(self.Timeout):
+		return NetListInfo{}, ErrResponseTimeout
+	}
+}
+
+func (self *NetBrowser) key(key string) error {
+	return self.device.Send(`NTC`, key)
+}
@@ -0,0 +1,112 @@
+package onkyo
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listReceiver emulates the cursor of a NET list, reporting it only after a delay so that a
+// browser which doesn't wait for it would act on a stale position.
+type listReceiver struct {
+	cursor int
+	keys   []string
+	lock   sync.Mutex
+}
+
+func (self *listReceiver) serve(receiver *pipeReceiver) {
+	for {
+		message, err := readEISCPFrame(receiver.reader)
+
+		if err != nil {
+			return
+		}
+
+		self.lock.Lock()
+		reply := ``
+
+		switch message {
+		case `!1NLSQSTN`:
+			reply = fmt.Sprintf("NLSC%dC", self.cursor)
+		case `!1NTCDOWN`, `!1NTCUP`:
+			self.keys = append(self.keys, message.Value())
+			page := `C`
+
+			if message.Value() == `DOWN` {
+				self.cursor++
+			} else {
+				self.cursor--
+			}
+
+			if self.cursor >= NetListLines {
+				self.cursor, page = 0, `P`
+			} else if self.cursor < 0 {
+				self.cursor, page = NetListLines-1, `P`
+			}
+
+			reply = fmt.Sprintf("NLSC%d%s", self.cursor, page)
+		default:
+			self.keys = append(self.keys, message.Value())
+		}
+
+		self.lock.Unlock()
+
+		if reply != `` {
+			time.Sleep(10 * time.Millisecond)
+			receiver.conn.Write(encodePacket(reply, CategoryDevice).bytes())
+		}
+	}
+}
+
+func (self *listReceiver) pressed() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	keys := self.keys
+	self.keys = nil
+
+	return keys
+}
+
+func TestNetBrowserMoveCursor(t *testing.T) {
+	device, receiver := newPipeDevice(t, nil)
+	list := &listReceiver{cursor: 2}
+
+	go list.serve(receiver)
+
+	browser := NewNetBrowser(device)
+	browser.Timeout = time.Second
+	defer browser.Close()
+
+	tests := []struct {
+		name   string
+		action func() error
+		keys   []string
+		cursor int
+	}{
+		{`select from an unknown position`, func() error { return browser.Select(5) }, []string{`DOWN`, `DOWN`, `DOWN`, `SELECT`}, 5},
+		{`select above`, func() error { return browser.Select(3) }, []string{`UP`, `UP`, `SELECT`}, 3},
+		{`select the current line`, func() error { return browser.Select(3) }, []string{`SELECT`}, 3},
+		{`page down`, browser.PageDown, []string{`DOWN`, `DOWN`, `DOWN`, `DOWN`, `DOWN`, `DOWN`, `DOWN`}, 0},
+		{`page up`, browser.PageUp, []string{`UP`}, NetListLines - 1},
+	}
+
+	for _, test := range tests {
+		if err := test.action(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		// SELECT has no reply, so give it a moment to arrive
+		time.Sleep(20 * time.Millisecond)
+
+		if keys := list.pressed(); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.keys, keys)
+		}
+
+		if cursor := browser.Menu().Cursor; cursor != test.cursor {
+			t.Errorf("%s: expected the cursor at %d, got %d", test.name, test.cursor, cursor)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package onkyo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_NOW_PLAYING_SETTLE = time.Duration(100) * time.Millisecond
+
+// The commands that make up the now playing information.
+var NowPlayingCodes = []string{`NTI`, `NAT`, `NAL`, `NTM`, `NTR`, `NST`}
+
+type PlayState int
+
+const (
+	Stopped PlayState = iota
+	Playing
+	Paused
+	FastForward
+	Rewind
+	EndOfFile
+)
+
+func (self PlayState) String() string {
+	switch self {
+	case Playing:
+		return `playing`
+	case Paused:
+		return `paused`
+	case FastForward:
+		return `fast-forward`
+	case Rewind:
+		return `rewind`
+	case EndOfFile:
+		return `eof`
+	default:
+		return `stopped`
+	}
+}
+
+var repeatModes = map[byte]string{
+	'-': `off`,
+	'R': `all`,
+	'F': `folder`,
+	'1': `one`,
+	'x': `disabled`,
+}
+
+var shuffleModes = map[byte]string{
+	'-': `off`,
+	'S': `all`,
+	'A': `album`,
+	'F': `folder`,
+	'x': `disabled`,
+}
+
+// NowPlayingInfo describes the track currently playing from a NET/USB source.
+type NowPlayingInfo struct {
+	Title   string
+	Artist  string
+	Album   string
+	Elapsed time.Duration
+	Total   time.Duration
+	Track   int
+	Tracks  int
+	State   PlayState
+	Repeat  string
+	Shuffle string
+}
+
+// ParseTrackTime decodes an NTM value ("mm:ss/mm:ss" or "hh:mm:ss/hh:mm:ss") into the
+// elapsed and total time of the track.
+func ParseTrackTime(value string) (time.Duration, time.Duration, error) {
+	parts := strings.SplitN(value, `/`, 2)
+
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Malformed track time %q", value)
+	}
+
+	if elapsed, err := parseClock(parts[0]); err == nil {
+		if total, err := parseClock(parts[1]); err == nil {
+			return elapsed, total, nil
+		} else {
+			return 0, 0, err
+		}
+	} else {
+		return 0, 0, err
+	}
+}
+
+// ParseTrackCount decodes an NTR value ("cccc/tttt") into the current track number and the
+// total number of tracks.
+func ParseTrackCount(value string) (int, int, error) {
+	parts := strings.SplitN(value, `/`, 2)
+
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Malformed track count %q", value)
+	}
+
+	counts := make([]int, 2)
+
+	for i, part := range parts {
+		if strings.Trim(part, `-`) == `` {
+			continue
+		}
+
+		if v, err := strconv.Atoi(part); err == nil {
+			counts[i] = v
+		} else {
+			return 0, 0, fmt.Errorf("Malformed track count %q", value)
+		}
+	}
+
+	return counts[0], counts[1], nil
+}
+
+// ParsePlayStatus decodes an NST value ("prs") into the play state, repeat mode and shuffle
+// mode.
+func ParsePlayStatus(value string) (PlayState, string, string, error) {
+	if len(value) != 3 {
+		return Stopped, ``, ``, fmt.Errorf("Malformed play status %q", value)
+	}
+
+	var state PlayState
+
+	switch value[0] {
+	case 'S':
+		state = Stopped
+	case 'P':
+		state = Playing
+	case 'p':
+		state = Paused
+	case 'F':
+		state = FastForward
+	case 'R':
+		state = Rewind
+	case 'E':
+		state = EndOfFile
+	default:
+		return Stopped, ``, ``, fmt.Errorf("Unknown play state %q", value[0])
+	}
+
+	repeat, ok := repeatModes[value[1]]
+
+	if !ok {
+		return state, ``, ``, fmt.Errorf("Unknown repeat mode %q", value[1])
+	}
+
+	shuffle, ok := shuffleModes[value[2]]
+
+	if !ok {
+		return state, repeat, ``, fmt.Errorf("Unknown shuffle mode %q", value[2])
+	}
+
+	return state, repeat, shuffle, nil
+}
+
+func parseClock(value string) (time.Duration, error) {
+	var duration time.Duration
+
+	if strings.Trim(value, `-:`) == `` {
+		return 0, nil
+	}
+
+	for _, part := range strings.Split(value, `:`) {
+		if v, err := strconv.Atoi(part); err == nil {
+			duration = duration*60 + time.Duration(v)
+		} else {
+			return 0, fmt.Errorf("Malformed time %q", value)
+		}
+	}
+
+	return duration * time.Second, nil
+}
+
+// NowPlaying aggregates the separate NET/USB metadata messages into a single view of the
+// track that is currently playing.
+type NowPlaying struct {
+	lock    sync.RWMutex
+	current NowPlayingInfo
+	updates chan NowPlayingInfo
+}
+
+func NewNowPlaying() *NowPlaying {
+	return &NowPlaying{
+		updates: make(chan NowPlayingInfo, 1),
+	}
+}
+
+// Current returns the current track information.
+func (self *NowPlaying) Current() NowPlayingInfo {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.current
+}
+
+// Updates returns a channel that receives the track information whenever it changes while
+// watching a device.  Bursts of changes (such as those that occur when the track changes)
+// are coalesced into a single update.
+func (self *NowPlaying) Updates() <-chan NowPlayingInfo {
+	return self.updates
+}
+
+// Refresh requests all of the now playing information from the device.
+func (self *NowPlaying) Refresh(device *Device) error {
+	for _, code := range NowPlayingCodes {
+		if err := device.Send(code, `QSTN`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Watch applies every message received from the device until the connection is closed,
+// sending updates once changes have settled.
+func (self *NowPlaying) Watch(device *Device) {
+	sub := device.Subscribe()
+
+	go func() {
+		var settle <-chan time.Time
+
+		defer close(self.updates)
+
+		for {
+			select {
+			case message, ok := <-sub:
+				if !ok {
+					return
+				}
+
+				if changed, err := self.Update(message); err != nil {
+					log.Debugf("Now playing: %v", err)
+				} else if changed && settle == nil {
+					settle = time.After(DEFAULT_NOW_PLAYING_SETTLE)
+				}
+
+			case <-settle:
+				settle = nil
+				current := self.Current()
+
+				select {
+				case <-self.updates:
+				default:
+				}
+
+				self.updates <- current
+			}
+		}
+	}()
+}
+
+// Update applies a now playing message, returning whether the track information changed.
+func (self *NowPlaying) Update(message Message) (bool, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	previous := self.current
+	value := message.Value()
+
+	switch message.Code() {
+	case `NTI`:
+		self.current.Title = value
+	case `NAT`:
+		self.current.Artist = value
+	case `NAL`:
+		self.current.Album = value
+	case `NTM`:
+		if elapsed, total, err := ParseTrackTime(value); err == nil {
+			self.current.Elapsed = elapsed
+			self.current.Total = total
+		} else {
+			return false, err
+		}
+	case `NTR`:
+		if track, tracks, err := ParseTrackCount(value); err == nil {
+			self.current.Track = track
+			self.current.Tracks = tracks
+		} else {
+			return false, err
+		}
+	case `NST`:
+		if state, repeat, shuffle, err := ParsePlayStatus(value); err == nil {
+			self.current.State = state
+			self.current.Repeat = repeat
+			self.current.Shuffle = shuffle
+		} else {
+			return false, err
+		}
+	default:
+		return false, nil
+	}
+
+	return self.current != previous, nil
+}
@@ -0,0 +1,98 @@
+package onkyo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTrackTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		elapsed time.Duration
+		total   time.Duration
+		err     bool
+	}{
+		{`00:00/03:45`, 0, 3*time.Minute + 45*time.Second, false},
+		{`01:23/04:56`, time.Minute + 23*time.Second, 4*time.Minute + 56*time.Second, false},
+		{`01:02:03/02:00:00`, time.Hour + 2*time.Minute + 3*time.Second, 2 * time.Hour, false},
+		{`12:34/--:--`, 12*time.Minute + 34*time.Second, 0, false},
+		{`--:--/--:--`, 0, 0, false},
+		{`123:45/--:--`, 123*time.Minute + 45*time.Second, 0, false},
+		{`01:23`, 0, 0, true},
+		{``, 0, 0, true},
+		{`aa:bb/03:45`, 0, 0, true},
+		{`01:23/cc:dd`, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		elapsed, total, err := ParseTrackTime(test.value)
+
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error state: %v", test.value, err)
+		} else if elapsed != test.elapsed || total != test.total {
+			t.Errorf("%q: expected %v/%v, got %v/%v", test.value, test.elapsed, test.total, elapsed, total)
+		}
+	}
+}
+
+func TestParsePlayStatus(t *testing.T) {
+	tests := []struct {
+		value   string
+		state   PlayState
+		repeat  string
+		shuffle string
+		err     bool
+	}{
+		{`S--`, Stopped, `off`, `off`, false},
+		{`P--`, Playing, `off`, `off`, false},
+		{`pRS`, Paused, `all`, `all`, false},
+		{`F1A`, FastForward, `one`, `album`, false},
+		{`RFF`, Rewind, `folder`, `folder`, false},
+		{`E--`, EndOfFile, `off`, `off`, false},
+		{`Pxx`, Playing, `disabled`, `disabled`, false},
+		{`X--`, Stopped, ``, ``, true},
+		{`PZ-`, Playing, ``, ``, true},
+		{`P-Z`, Playing, `off`, ``, true},
+		{`P-`, Stopped, ``, ``, true},
+		{``, Stopped, ``, ``, true},
+	}
+
+	for _, test := range tests {
+		state, repeat, shuffle, err := ParsePlayStatus(test.value)
+
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error state: %v", test.value, err)
+		} else if state != test.state || repeat != test.repeat || shuffle != test.shuffle {
+			t.Errorf("%q: expected %v/%s/%s, got %v/%s/%s", test.value, test.state, test.repeat, test.shuffle, state, repeat, shuffle)
+		}
+	}
+
+	if EndOfFile.String() != `eof` {
+		t.Errorf("expected EndOfFile to be eof, got %s", EndOfFile)
+	}
+}
+
+func TestParseTrackCount(t *testing.T) {
+	tests := []struct {
+		value   string
+		current int
+		total   int
+		err     bool
+	}{
+		{`0001/0012`, 1, 12, false},
+		{`0003/----`, 3, 0, false},
+		{`----/----`, 0, 0, false},
+		{`0001`, 0, 0, true},
+		{`00x1/0012`, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		current, total, err := ParseTrackCount(test.value)
+
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error state: %v", test.value, err)
+		} else if current != test.current || total != test.total {
+			t.Errorf("%q: expected %d/%d, got %d/%d", test.value, test.current, test.total, current, total)
+		}
+	}
+}
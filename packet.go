@@ -76,6 +76,11 @@ func (p packet) parseInfo(info *DeviceInfo) error {
 		return err
 	}
 
+	return parseDeviceInfo(p.message(), info)
+}
+
+// parseDeviceInfo parses the reply to a discovery (ECNQSTN) request.
+func parseDeviceInfo(message []byte, info *DeviceInfo) (err error) {
 	// Expecting:
 	//   !cECNnnnnnn/ppppp/dd/iiiiiiiiiiiitt
 	// where
@@ -85,18 +90,18 @@ func (p packet) parseInfo(info *DeviceInfo) error {
 	//   dd = destination area (2 char)
 	//   iiiiii = identifier (up to 12 char)
 	//   tt = terminating chars (one or both of \r\n)
-	parts := bytes.Split(p.message(), []byte("/"))
+	parts := bytes.Split(message, []byte("/"))
 	if len(parts) != 4 ||
 		len(parts[0]) < 5 || len(parts[1]) != 5 || len(parts[2]) != 2 ||
 		parts[0][0] != '!' || !bytes.Equal(parts[0][2:5], []byte("ECN")) {
-		return fmt.Errorf("Malformed device info: %v", p)
+		return fmt.Errorf("Malformed device info: %q", message)
 	}
 
 	info.Category = DeviceCategory(parts[0][1])
 	info.Model = string(parts[0][5:])
 	info.Port, err = strconv.Atoi(string(parts[1]))
 	info.DestArea = string(parts[2])
-	info.Identifier = strings.TrimSpace(string(parts[3]))
+	info.Identifier = strings.TrimSpace(strings.TrimRight(string(parts[3]), terminator+"\x1a"))
 
 	return err
 }
@@ -137,6 +142,10 @@ func decodePackets(data []byte) ([]packet, error) {
 			break
 		}
 		totalSize := int(p.headerSize()) + p.messageLen()
+		if len(data) < totalSize {
+			errs.Pushf("Packet too short, expected %d bytes but %d remaining", totalSize, len(data))
+			break
+		}
 		p, data = packet(data[:totalSize]), data[totalSize:]
 		packets = append(packets, p)
 	}
@@ -0,0 +1,196 @@
+package onkyo
+
+import (
+	"net"
+	"sync"
+)
+
+const DEFAULT_PROXY_CLIENT_BUFFER = 64
+
+// Proxy shares a single connection to a device between many eISCP clients.  Commands from
+// clients are forwarded to the device and every message from the device is sent to all
+// clients.  Discovery requests are answered on behalf of the device so that existing
+// applications find the proxy instead.
+type Proxy struct {
+	device    *Device
+	listener  net.Listener
+	discovery *net.UDPConn
+	clients   map[*proxyClient]bool
+	lock      sync.Mutex
+}
+
+type proxyClient struct {
+	conn      net.Conn
+	transport *StreamTransport
+	outbox    chan Message
+}
+
+func NewProxy(device *Device) *Proxy {
+	return &Proxy{
+		device:  device,
+		clients: make(map[*proxyClient]bool),
+	}
+}
+
+// Clients returns the number of connected clients.
+func (self *Proxy) Clients() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return len(self.clients)
+}
+
+// ListenAndServe accepts clients on the given TCP address and answers discovery requests on
+// the same UDP port until the proxy is closed or the connection to the device is lost.
+func (self *Proxy) ListenAndServe(address string) error {
+	if listener, err := net.Listen(`tcp`, address); err == nil {
+		self.listener = listener
+	} else {
+		return err
+	}
+
+	tcpAddr := self.listener.Addr().(*net.TCPAddr)
+
+	if conn, err := net.ListenUDP(`udp`, &net.UDPAddr{
+		IP:   tcpAddr.IP,
+		Port: tcpAddr.Port,
+	}); err == nil {
+		self.discovery = conn
+	} else {
+		self.listener.Close()
+		return err
+	}
+
+	log.Infof("Proxying %s on %s", self.device.Address().String(), tcpAddr.String())
+
+	go answerDiscovery(self.discovery, self.discoveryResponse)
+	go self.broadcast()
+
+	for {
+		if conn, err := self.listener.Accept(); err == nil {
+			go self.serveClient(conn)
+		} else {
+			self.Close()
+			return err
+		}
+	}
+}
+
+// Close stops accepting clients and disconnects all connected clients.  The device is left
+// connected.
+func (self *Proxy) Close() error {
+	if self.discovery != nil {
+		self.discovery.Close()
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	for client := range self.clients {
+		client.conn.Close()
+	}
+
+	if self.listener != nil {
+		return self.listener.Close()
+	}
+
+	return nil
+}
+
+// discoveryResponse returns the ECN message describing the device, as reachable via the proxy.
+func (self *Proxy) discoveryResponse() Message {
+	port := DEFAULT_DISCOVERY_PORT
+
+	if self.listener != nil {
+		port = self.listener.Addr().(*net.TCPAddr).Port
+	}
+
+	return discoveryMessage(self.device.Info(), port)
+}
+
+// broadcast sends every message from the device to all clients, disconnecting them once the
+// connection to the device is lost.
+func (self *Proxy) broadcast() {
+	sub := self.device.Subscribe()
+
+	for message := range sub {
+		self.lock.Lock()
+
+		for client := range self.clients {
+			select {
+			case client.outbox <- message:
+			default:
+				log.Warningf("Client %s is not keeping up, dropped message %q", client.conn.RemoteAddr().String(), message)
+			}
+		}
+
+		self.lock.Unlock()
+	}
+
+	log.Warningf("Lost connection to %s, stopping proxy", self.device.Address().String())
+	self.Close()
+}
+
+func (self *Proxy) serveClient(conn net.Conn) {
+	client := &proxyClient{
+		conn:      conn,
+		transport: NewConnTransport(conn, FramingEISCP),
+		outbox:    make(chan Message, DEFAULT_PROXY_CLIENT_BUFFER),
+	}
+
+	if err := client.transport.Dial(); err != nil {
+		conn.Close()
+		return
+	}
+
+	self.lock.Lock()
+	self.clients[client] = true
+	self.lock.Unlock()
+
+	log.Infof("Client %s connected", conn.RemoteAddr().String())
+
+	defer func() {
+		self.lock.Lock()
+		delete(self.clients, client)
+		self.lock.Unlock()
+
+		close(client.outbox)
+		conn.Close()
+
+		log.Infof("Client %s disconnected", conn.RemoteAddr().String())
+	}()
+
+	go func() {
+		for message := range client.outbox {
+			if err := client.transport.WriteFrame(message); err != nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	for {
+		if message, err := client.transport.ReadFrame(); err == nil {
+			if isDiscoveryRequest(message) {
+				select {
+				case client.outbox <- self.discoveryResponse():
+				default:
+				}
+
+				continue
+			}
+
+			log.Debugf("Forwarding %q from %s", message, conn.RemoteAddr().String())
+
+			// split into the command and its parameter so the device's send filter sees both
+			if code, value, err := ParseRawMessage(string(message)); err != nil {
+				log.Warningf("Ignoring %q from %s: %v", message, conn.RemoteAddr().String(), err)
+			} else if err := self.device.Send(code, value); err != nil {
+				log.Warningf("Failed to forward %q: %v", message, err)
+			}
+		} else if _, ok := err.(*DecodeError); ok {
+			log.Warningf("Failed to decode packet from %s: %v", conn.RemoteAddr().String(), err)
+		} else {
+			return
+		}
+	}
+}
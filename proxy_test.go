@@ -0,0 +1,74 @@
+package onkyo
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProxyForwardsThroughSendFilter(t *testing.T) {
+	filtered := make(chan string, 8)
+
+	// limits the volume the way a configured maximum would
+	device, receiver := newPipeDevice(t, &DeviceOptions{
+		SendFilter: func(device *Device, cmd string, param string) (string, error) {
+			filtered <- cmd + `:` + param
+
+			if cmd == `MVL` {
+				if v, err := strconv.ParseInt(param, 16, 32); err == nil && v > 0x32 {
+					return `32`, nil
+				}
+			} else if cmd == `PWR` && param == `00` {
+				return ``, fmt.Errorf("Standby is not allowed")
+			}
+
+			return param, nil
+		},
+	})
+
+	proxy := NewProxy(device)
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go proxy.serveClient(server)
+
+	clientTransport := NewConnTransport(client, FramingEISCP)
+	clientTransport.Dial()
+
+	tests := []struct {
+		sent      Message
+		filtered  string
+		forwarded Message
+	}{
+		{`!1MVL64`, `MVL:64`, `!1MVL32`},
+		{`!1MVL20`, `MVL:20`, `!1MVL20`},
+		{`!1PWR00`, `PWR:00`, ``},
+		{`!xSLI10`, `SLI:10`, `!1SLI10`},
+		{`!1MVLQSTN`, `MVL:QSTN`, `!1MVLQSTN`},
+	}
+
+	for _, test := range tests {
+		client.SetWriteDeadline(time.Now().Add(time.Second))
+
+		if err := clientTransport.WriteFrame(test.sent); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case seen := <-filtered:
+			if seen != test.filtered {
+				t.Errorf("%s: expected the filter to see %s, got %s", test.sent, test.filtered, seen)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: timed out waiting for the send filter", test.sent)
+		}
+
+		if test.forwarded != `` {
+			if message := receiver.read(t); message != test.forwarded {
+				t.Errorf("%s: expected %s to reach the device, got %s", test.sent, test.forwarded, message)
+			}
+		}
+	}
+}
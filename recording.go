@@ -0,0 +1,112 @@
+package onkyo
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Direction string
+
+const (
+	DirectionOpen    Direction = `open`
+	DirectionSend    Direction = `send`
+	DirectionReceive Direction = `recv`
+)
+
+// RecordedFrame is a single entry in a session recording.  Recordings are stored as JSON
+// lines, starting with an "open" frame describing the device followed by every message sent
+// and received.
+type RecordedFrame struct {
+	Time      time.Time   `json:"time"`
+	Address   string      `json:"address,omitempty"`
+	Direction Direction   `json:"direction"`
+	Message   Message     `json:"message,omitempty"`
+	Device    *DeviceInfo `json:"device,omitempty"`
+}
+
+// Recorder writes session recordings.  A single recorder may be shared by several devices.
+type Recorder struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (self *Recorder) Record(frame RecordedFrame) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if frame.Time.IsZero() {
+		frame.Time = time.Now()
+	}
+
+	return self.encoder.Encode(frame)
+}
+
+// ReadRecording reads all of the frames from a session recording.
+func ReadRecording(r io.Reader) ([]RecordedFrame, error) {
+	frames := make([]RecordedFrame, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, maxPacketSize), 16*maxPacketSize)
+	line := 0
+
+	for scanner.Scan() {
+		line += 1
+
+		if strings.TrimSpace(scanner.Text()) == `` {
+			continue
+		}
+
+		var frame RecordedFrame
+
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err == nil {
+			frames = append(frames, frame)
+		} else {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+
+	return frames, scanner.Err()
+}
+
+// recordingTransport records every message passing through the wrapped transport.
+type recordingTransport struct {
+	Transport
+	recorder *Recorder
+	address  string
+}
+
+func (self *recordingTransport) ReadFrame() (Message, error) {
+	message, err := self.Transport.ReadFrame()
+
+	if err == nil {
+		self.record(DirectionReceive, message)
+	}
+
+	return message, err
+}
+
+func (self *recordingTransport) WriteFrame(message Message) error {
+	self.record(DirectionSend, message)
+
+	return self.Transport.WriteFrame(message)
+}
+
+func (self *recordingTransport) record(direction Direction, message Message) {
+	if err := self.recorder.Record(RecordedFrame{
+		Address:   self.address,
+		Direction: direction,
+		Message:   message,
+	}); err != nil {
+		log.Warningf("Failed to record message: %v", err)
+	}
+}
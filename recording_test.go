@@ -0,0 +1,190 @@
+package onkyo
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that can be written to while the device is running.
+type syncBuffer struct {
+	bytes.Buffer
+	lock sync.Mutex
+}
+
+func (self *syncBuffer) Write(data []byte) (int, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.Buffer.Write(data)
+}
+
+func (self *syncBuffer) String() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.Buffer.String()
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	recording := &syncBuffer{}
+	device, receiver := newPipeDevice(t, &DeviceOptions{
+		Recorder: NewRecorder(recording),
+	})
+
+	sent := receiver.reply(t, `PWR01`)
+
+	if _, err := device.Query(time.Second, `PWR`); err != nil {
+		t.Fatal(err)
+	}
+
+	<-sent
+
+	// one JSON object per line: the device, then what was sent and received
+	lines := strings.Split(strings.TrimSpace(recording.String()), "\n")
+
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d:\n%s", len(lines), recording.String())
+	}
+
+	for i, expected := range []string{`"direction":"open"`, `"direction":"send","message":"!1PWRQSTN"`, `"direction":"recv","message":"!1PWR01"`} {
+		if !strings.Contains(lines[i], expected) {
+			t.Errorf("line %d: expected %s in %s", i+1, expected, lines[i])
+		}
+	}
+
+	frames, err := ReadRecording(strings.NewReader(recording.String()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != 3 {
+		t.Fatalf("expected 3 frames, got %d", len(frames))
+	} else if frames[0].Device == nil || frames[0].Device.Model != `TX-TEST` {
+		t.Errorf("expected the open frame to describe the device, got %+v", frames[0].Device)
+	}
+
+	for _, frame := range frames {
+		if frame.Time.IsZero() {
+			t.Errorf("expected %s frame to have a time", frame.Direction)
+		}
+	}
+
+	replayer := NewReplayer(frames)
+
+	if replayer.Info.Model != `TX-TEST` {
+		t.Errorf("expected the replayer to emulate TX-TEST, got %s", replayer.Info.Model)
+	}
+
+	if responses := replayer.Respond(`!1PWRQSTN`); !reflect.DeepEqual(responses, []Message{`!1PWR01`}) {
+		t.Errorf("expected the recorded reply, got %v", responses)
+	}
+}
+
+func TestReadRecording(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		frames int
+		err    string
+	}{
+		{
+			name:   `frames`,
+			data:   "{\"time\":\"2026-01-02T03:04:05Z\",\"direction\":\"open\",\"device\":{\"model\":\"TX-NR626\"}}\n{\"time\":\"2026-01-02T03:04:06Z\",\"direction\":\"send\",\"message\":\"!1PWRQSTN\"}\n",
+			frames: 2,
+		}, {
+			name:   `blank lines`,
+			data:   "\n{\"direction\":\"recv\",\"message\":\"!1PWR01\"}\n   \n\n{\"direction\":\"recv\",\"message\":\"!1MVL2A\"}",
+			frames: 2,
+		}, {
+			name: `empty`,
+			data: ``,
+		}, {
+			name: `malformed line`,
+			data: "{\"direction\":\"recv\",\"message\":\"!1PWR01\"}\n{\"direction\":\n",
+			err:  `line 2:`,
+		},
+	}
+
+	for _, test := range tests {
+		frames, err := ReadRecording(strings.NewReader(test.data))
+
+		if test.err != `` {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%s: expected an error starting with %q, got %v", test.name, test.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if len(frames) != test.frames {
+			t.Errorf("%s: expected %d frames, got %d", test.name, test.frames, len(frames))
+		}
+	}
+}
+
+func TestReplayerRespond(t *testing.T) {
+	replayer := NewReplayer([]RecordedFrame{
+		{Direction: DirectionOpen, Device: &DeviceInfo{Model: `TX-NR626`}},
+		{Direction: DirectionReceive, Message: `!1SLI10`},
+		{Direction: DirectionSend, Message: `!1PWR01`},
+		{Direction: DirectionReceive, Message: `!1PWR01`},
+		{Direction: DirectionReceive, Message: `!1MVL20`},
+		{Direction: DirectionSend, Message: `!1MVLUP`},
+		{Direction: DirectionReceive, Message: `!1MVL21`},
+		{Direction: DirectionSend, Message: `!1MVLUP`},
+		{Direction: DirectionReceive, Message: `!1MVL22`},
+	})
+
+	tests := []struct {
+		message   Message
+		responses []Message
+	}{
+		{`!1PWR01`, []Message{`!1PWR01`, `!1MVL20`}},
+		{`!1MVLUP`, []Message{`!1MVL21`}},
+		{`!1MVLUP`, []Message{`!1MVL22`}},
+		{`!1MVLUP`, []Message{`!1MVL21`}},
+		{`!1MVLQSTN`, []Message{`!1MVL21`}},
+		{`!1SLIQSTN`, []Message{`!1SLI10`}},
+		{`!1AMTQSTN`, nil},
+		{`!1AMT01`, nil},
+		{`!1`, nil},
+	}
+
+	for i, test := range tests {
+		if responses := replayer.Respond(test.message); !reflect.DeepEqual(responses, test.responses) {
+			t.Errorf("%d (%s): expected %v, got %v", i+1, test.message, test.responses, responses)
+		}
+	}
+}
+
+func TestReplayerDiscoveryPort(t *testing.T) {
+	replayer := NewReplayer([]RecordedFrame{
+		{Direction: DirectionOpen, Device: &DeviceInfo{Model: `TX-NR626`, Category: CategoryDevice, DestArea: `DX`, Identifier: `0009B0D4A6F1`}},
+	})
+
+	// the port the replayer listens on, rather than the default
+	replayer.port = 60555
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	go replayer.serveClient(server)
+
+	transport := NewConnTransport(client, FramingEISCP)
+	transport.Dial()
+	client.SetDeadline(time.Now().Add(time.Second))
+
+	if err := transport.WriteFrame(`!xECNQSTN`); err != nil {
+		t.Fatal(err)
+	}
+
+	if message, err := transport.ReadFrame(); err != nil {
+		t.Fatal(err)
+	} else if message != `!1ECNTX-NR626/60555/DX/0009B0D4A6F1` {
+		t.Errorf("expected the listening port to be advertised, got %s", message)
+	}
+}
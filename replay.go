@@ -0,0 +1,155 @@
+package onkyo
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// Replayer emulates a device using the responses captured in a session recording.  When a
+// client sends a command that was sent during the recording, the messages the device replied
+// with are sent back (cycling through them if the command was sent several times).  Queries
+// for anything else are answered with the last value the device reported.
+type Replayer struct {
+	Info      DeviceInfo
+	responses map[Message][][]Message
+	next      map[Message]int
+	state     map[string]Message
+	port      int
+	lock      sync.Mutex
+}
+
+func NewReplayer(frames []RecordedFrame) *Replayer {
+	replayer := &Replayer{
+		Info: DeviceInfo{
+			Model:      `Replay`,
+			Category:   CategoryDevice,
+			DestArea:   `XX`,
+			Identifier: `000000000000`,
+		},
+		responses: make(map[Message][][]Message),
+		next:      make(map[Message]int),
+		state:     make(map[string]Message),
+	}
+
+	var last Message
+
+	for _, frame := range frames {
+		switch frame.Direction {
+		case DirectionOpen:
+			if frame.Device != nil {
+				replayer.Info = *frame.Device
+			}
+		case DirectionSend:
+			if len(frame.Message) > 2 {
+				last = frame.Message[2:]
+				replayer.responses[last] = append(replayer.responses[last], nil)
+			}
+		case DirectionReceive:
+			replayer.state[frame.Message.Code()] = frame.Message
+
+			if sequences := replayer.responses[last]; len(sequences) > 0 {
+				sequences[len(sequences)-1] = append(sequences[len(sequences)-1], frame.Message)
+			}
+		}
+	}
+
+	return replayer
+}
+
+// Respond returns the messages the device would send in response to the given message.
+func (self *Replayer) Respond(message Message) []Message {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if len(message) <= 2 {
+		return nil
+	}
+
+	key := message[2:]
+	var responses []Message
+
+	if sequences := self.responses[key]; len(sequences) > 0 {
+		responses = sequences[self.next[key]%len(sequences)]
+		self.next[key] += 1
+	} else if strings.HasSuffix(string(key), `QSTN`) {
+		if current, ok := self.state[message.Code()]; ok {
+			responses = []Message{current}
+		}
+	}
+
+	for _, response := range responses {
+		self.state[response.Code()] = response
+	}
+
+	return responses
+}
+
+// ListenAndServe accepts eISCP clients on the given TCP address and answers discovery
+// requests on the same UDP port.
+func (self *Replayer) ListenAndServe(address string) error {
+	listener, err := net.Listen(`tcp`, address)
+
+	if err != nil {
+		return err
+	}
+
+	defer listener.Close()
+
+	tcpAddr := listener.Addr().(*net.TCPAddr)
+	self.port = tcpAddr.Port
+
+	if conn, err := net.ListenUDP(`udp`, &net.UDPAddr{
+		IP:   tcpAddr.IP,
+		Port: tcpAddr.Port,
+	}); err == nil {
+		defer conn.Close()
+
+		go answerDiscovery(conn, func() Message {
+			return discoveryMessage(self.Info, tcpAddr.Port)
+		})
+	} else {
+		return err
+	}
+
+	log.Infof("Replaying %s on %s", self.Info.Model, tcpAddr.String())
+
+	for {
+		if conn, err := listener.Accept(); err == nil {
+			go self.serveClient(conn)
+		} else {
+			return err
+		}
+	}
+}
+
+func (self *Replayer) serveClient(conn net.Conn) {
+	transport := NewConnTransport(conn, FramingEISCP)
+	defer transport.Close()
+
+	if err := transport.Dial(); err != nil {
+		return
+	}
+
+	for {
+		if message, err := transport.ReadFrame(); err == nil {
+			var responses []Message
+
+			if isDiscoveryRequest(message) {
+				responses = []Message{discoveryMessage(self.Info, self.port)}
+			} else {
+				responses = self.Respond(message)
+			}
+
+			for _, response := range responses {
+				if err := transport.WriteFrame(response); err != nil {
+					return
+				}
+			}
+		} else if _, ok := err.(*DecodeError); ok {
+			log.Warningf("Failed to decode packet from %s: %v", conn.RemoteAddr().String(), err)
+		} else {
+			return
+		}
+	}
+}
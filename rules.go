@@ -0,0 +1,433 @@
+package onkyo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_RULE_ACTION_TIMEOUT = time.Duration(30) * time.Second
+
+// Rule performs actions when the device reports a change, such as:
+//
+//	rules:
+//	  game-mode:
+//	    when:
+//	      command: input-selector    # by name or code
+//	      is: game                   # or "above"/"below" a number; omit to match any change
+//	    do:
+//	      - set: listening-mode
+//	        value: game-action
+//	      - set: dimmer-level
+//	        value: bright
+//	  late-night-volume:
+//	    when:
+//	      command: master-volume
+//	      above: 60
+//	    if:
+//	      - after: "22:00"           # conditions on the time, or the state of other commands
+//	        before: "06:00"
+//	    do:
+//	      - set: master-volume
+//	        value: 60
+//	  standby:
+//	    when:
+//	      command: system-power
+//	      is: standby
+//	    do:
+//	      - webhook: http://example.com/hooks/standby
+//	      - exec: [/usr/local/bin/lights, off]
+//
+// A rule is triggered when the value of a command changes to one that matches.  Actions are
+// performed in order, stopping at the first that fails.
+type Rule struct {
+	Name string          `yaml:"-" json:"name"`
+	Zone string          `yaml:"zone,omitempty" json:"zone,omitempty"`
+	When RuleMatch       `yaml:"when" json:"when"`
+	If   []RuleCondition `yaml:"if,omitempty" json:"if,omitempty"`
+	Do   []RuleAction    `yaml:"do" json:"do"`
+}
+
+// RuleMatch matches the value of a command.
+type RuleMatch struct {
+	Command string `yaml:"command,omitempty" json:"command,omitempty"`
+	Zone    string `yaml:"zone,omitempty" json:"zone,omitempty"`
+	Is      string `yaml:"is,omitempty" json:"is,omitempty"`
+	Above   *int64 `yaml:"above,omitempty" json:"above,omitempty"`
+	Below   *int64 `yaml:"below,omitempty" json:"below,omitempty"`
+}
+
+// RuleCondition must hold for a triggered rule to perform its actions.  It either matches the
+// current value of a command, or the time of day (After and Before may be given together, and
+// may span midnight).
+type RuleCondition struct {
+	RuleMatch `yaml:",inline"`
+	After     string `yaml:"after,omitempty" json:"after,omitempty"`
+	Before    string `yaml:"before,omitempty" json:"before,omitempty"`
+}
+
+// RuleAction is either a scene step, a local executable to run or a URL to POST the triggering
+// event to.  Executables receive the event in the ONKYO_RULE, ONKYO_CODE, ONKYO_VALUE and
+// ONKYO_MESSAGE environment variables.
+type RuleAction struct {
+	SceneStep `yaml:",inline"`
+	Exec      []string `yaml:"exec,omitempty" json:"exec,omitempty"`
+	Webhook   string   `yaml:"webhook,omitempty" json:"webhook,omitempty"`
+}
+
+// RuleEvent describes the message that triggered a rule.
+type RuleEvent struct {
+	Rule    string    `json:"rule"`
+	Code    string    `json:"code"`
+	Value   string    `json:"value"`
+	Message Message   `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Rules is a collection of rules, keyed by name.
+type Rules map[string]*Rule
+
+// Names returns the names of all rules, sorted.
+func (self Rules) Names() []string {
+	names := make([]string, 0)
+
+	for name := range self {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks the structure of every rule (but not the names of commands and values,
+// which are checked when a RuleEngine is created).
+func (self Rules) Validate() error {
+	for name, rule := range self {
+		if rule == nil {
+			return fmt.Errorf("Rule %q is empty", name)
+		}
+
+		rule.Name = name
+
+		if rule.When.Command == `` {
+			return fmt.Errorf("Rule %q: a command to trigger on is required", name)
+		} else if len(rule.Do) == 0 {
+			return fmt.Errorf("Rule %q: at least one action is required", name)
+		}
+
+		for i, condition := range rule.If {
+			if condition.Command == `` && condition.After == `` && condition.Before == `` {
+				return fmt.Errorf("Rule %q: condition %d must have a command, after or before", name, i+1)
+			}
+
+			for _, clock := range []string{condition.After, condition.Before} {
+				if clock != `` {
+					if _, _, err := parseTimeOfDay(clock); err != nil {
+						return fmt.Errorf("Rule %q: condition %d: %v", name, i+1, err)
+					}
+				}
+			}
+		}
+
+		for i, action := range rule.Do {
+			kinds := 0
+			step := action.Set != `` || action.Raw != `` || action.Delay > 0 || action.Wait != `` || action.Fade != `` || action.If != ``
+
+			for _, set := range []bool{len(action.Exec) > 0, action.Webhook != ``, step} {
+				if set {
+					kinds += 1
+				}
+			}
+
+			if kinds != 1 {
+				return fmt.Errorf("Rule %q: action %d must be one of a scene step, exec or webhook", name, i+1)
+			} else if len(action.Exec) == 0 && action.Webhook == `` {
+				if err := validateSteps([]SceneStep{action.SceneStep}); err != nil {
+					return fmt.Errorf("Rule %q: action %d: %v", name, i+1, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolvedMatch is a RuleMatch with the command and value translated into codes.
+type resolvedMatch struct {
+	code  string
+	is    string
+	above *int64
+	below *int64
+}
+
+func (self resolvedMatch) matches(value string) bool {
+	if self.is != `` && !strings.EqualFold(self.is, value) {
+		return false
+	}
+
+	if self.above != nil || self.below != nil {
+		number, err := strconv.ParseInt(value, 16, 32)
+
+		if err != nil {
+			return false
+		} else if self.above != nil && number <= *self.above {
+			return false
+		} else if self.below != nil && number >= *self.below {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RuleEngine performs the actions of rules as the device they are watching reports changes.
+type RuleEngine struct {
+	Device        *Device
+	Rules         Rules
+	Resolver      ValueResolver
+	Timeout       time.Duration
+	ActionTimeout time.Duration
+
+	// Trace, if set, is called to describe the actions of each rule as they are performed.
+	Trace     func(format string, args ...interface{})
+	triggers  map[string]resolvedMatch
+	condition map[string][]resolvedMatch
+	last      map[string]string
+}
+
+// NewRuleEngine checks that every command and value named in the rules is valid.
+func NewRuleEngine(device *Device, rules Rules, resolver ValueResolver, timeout time.Duration) (*RuleEngine, error) {
+	if resolver == nil {
+		resolver = RawResolver{}
+	}
+
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+
+	engine := &RuleEngine{
+		Device:        device,
+		Rules:         rules,
+		Resolver:      resolver,
+		Timeout:       timeout,
+		ActionTimeout: DEFAULT_RULE_ACTION_TIMEOUT,
+		triggers:      make(map[string]resolvedMatch),
+		condition:     make(map[string][]resolvedMatch),
+		last:          make(map[string]string),
+	}
+
+	for name, rule := range rules {
+		if match, err := engine.resolve(rule, rule.When); err == nil {
+			engine.triggers[name] = match
+		} else {
+			return nil, fmt.Errorf("Rule %q: %v", name, err)
+		}
+
+		for _, condition := range rule.If {
+			var match resolvedMatch
+
+			if condition.Command != `` {
+				if m, err := engine.resolve(rule, condition.RuleMatch); err == nil {
+					match = m
+				} else {
+					return nil, fmt.Errorf("Rule %q: %v", name, err)
+				}
+			}
+
+			engine.condition[name] = append(engine.condition[name], match)
+		}
+	}
+
+	return engine, nil
+}
+
+func (self *RuleEngine) resolve(rule *Rule, match RuleMatch) (resolvedMatch, error) {
+	zone := firstNonEmpty(match.Zone, rule.Zone, `main`)
+	resolved := resolvedMatch{
+		above: match.Above,
+		below: match.Below,
+	}
+
+	if code, param, err := self.Resolver.Resolve(zone, match.Command, match.Is); err == nil {
+		resolved.code = code
+		resolved.is = param
+	} else {
+		return resolved, err
+	}
+
+	return resolved, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != `` {
+			return value
+		}
+	}
+
+	return ``
+}
+
+// Run evaluates the rules against every message from the device, until it is closed.
+func (self *RuleEngine) Run() error {
+	sub := self.Device.Subscribe()
+	defer self.Device.Unsubscribe(sub)
+
+	// values that were already known before starting aren't changes
+	for _, trigger := range self.triggers {
+		if message, ok := self.Device.State().Get(trigger.code); ok {
+			self.last[trigger.code] = message.Value()
+		}
+	}
+
+	log.Noticef("Watching for %d rule(s)", len(self.Rules))
+
+	for message := range sub {
+		for _, rule := range self.triggered(message, time.Now()) {
+			go self.perform(rule, message)
+		}
+	}
+
+	return fmt.Errorf("Connection closed")
+}
+
+// triggered returns the rules triggered by the given message whose conditions hold at the
+// given time.
+func (self *RuleEngine) triggered(message Message, now time.Time) []*Rule {
+	triggered := make([]*Rule, 0)
+	code := message.Code()
+	value := message.Value()
+	previous, known := self.last[code]
+	self.last[code] = value
+
+	// only changes trigger rules (devices repeat values, e.g.: in reply to queries)
+	if known && previous == value {
+		return triggered
+	}
+
+	for _, name := range self.Rules.Names() {
+		if trigger := self.triggers[name]; trigger.code == code && trigger.matches(value) {
+			if ok, err := self.conditionsHold(self.Rules[name], now); err != nil {
+				log.Warningf("Rule %q: failed to evaluate conditions: %v", name, err)
+			} else if ok {
+				triggered = append(triggered, self.Rules[name])
+			}
+		}
+	}
+
+	return triggered
+}
+
+func (self *RuleEngine) conditionsHold(rule *Rule, now time.Time) (bool, error) {
+	for i, condition := range rule.If {
+		if condition.After != `` || condition.Before != `` {
+			if !withinTimes(now, condition.After, condition.Before) {
+				return false, nil
+			}
+		}
+
+		if match := self.condition[rule.Name][i]; match.code != `` {
+			message, ok := self.Device.State().Get(match.code)
+
+			if !ok {
+				if m, err := self.Device.Query(self.Timeout, match.code); err == nil {
+					message = m
+				} else {
+					return false, err
+				}
+			}
+
+			if !match.matches(message.Value()) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// withinTimes returns whether the time of day is at or after one time and before another,
+// either of which may be empty.  If "after" is later than "before", the range spans midnight.
+func withinTimes(now time.Time, after string, before string) bool {
+	minutes := now.Hour()*60 + now.Minute()
+	start, end := 0, 24*60
+
+	if h, m, err := parseTimeOfDay(after); err == nil {
+		start = h*60 + m
+	}
+
+	if h, m, err := parseTimeOfDay(before); err == nil {
+		end = h*60 + m
+	}
+
+	if start <= end {
+		return minutes >= start && minutes < end
+	}
+
+	return minutes >= start || minutes < end
+}
+
+func (self *RuleEngine) trace(format string, args ...interface{}) {
+	if self.Trace != nil {
+		self.Trace(format, args...)
+	}
+}
+
+// perform runs the actions of a rule, stopping at the first that fails.
+func (self *RuleEngine) perform(rule *Rule, message Message) {
+	event := RuleEvent{
+		Rule:    rule.Name,
+		Code:    message.Code(),
+		Value:   message.Value(),
+		Message: message,
+		Time:    time.Now(),
+	}
+
+	log.Noticef("Rule %q triggered by %s", rule.Name, message)
+
+	for i, action := range rule.Do {
+		var err error
+
+		switch {
+		case len(action.Exec) > 0:
+			self.trace("Run %s", strings.Join(action.Exec, ` `))
+			err = self.exec(action.Exec, event)
+		case action.Webhook != ``:
+			self.trace("POST %s", action.Webhook)
+			err = self.webhook(action.Webhook, event)
+		default:
+			runner := NewSceneRunner(self.Device, self.Resolver, self.Timeout)
+			runner.Zone = firstNonEmpty(rule.Zone, `main`)
+			runner.Trace = self.Trace
+			_, err = runner.runSteps(runner.Zone, []SceneStep{action.SceneStep})
+		}
+
+		if err != nil {
+			log.Errorf("Rule %q: action %d failed: %v", rule.Name, i+1, err)
+			return
+		}
+	}
+
+	log.Noticef("Rule %q completed", rule.Name)
+}
+
+func (self *RuleEngine) exec(command []string, event RuleEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), self.ActionTimeout)
+	defer cancel()
+
+	return RunCommand(ctx, command,
+		`ONKYO_RULE=`+event.Rule,
+		`ONKYO_CODE=`+event.Code,
+		`ONKYO_VALUE=`+event.Value,
+		`ONKYO_MESSAGE=`+string(event.Message))
+}
+
+func (self *RuleEngine) webhook(url string, event RuleEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), self.ActionTimeout)
+	defer cancel()
+
+	return PostJSON(ctx, url, event)
+}
@@ -0,0 +1,130 @@
+package onkyo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithinTimes(t *testing.T) {
+	tests := []struct {
+		after  string
+		before string
+		clock  string
+		within bool
+	}{
+		{`22:00`, `06:00`, `21:59`, false},
+		{`22:00`, `06:00`, `22:00`, true},
+		{`22:00`, `06:00`, `23:59`, true},
+		{`22:00`, `06:00`, `00:00`, true},
+		{`22:00`, `06:00`, `05:59`, true},
+		{`22:00`, `06:00`, `06:00`, false},
+		{`22:00`, `06:00`, `12:00`, false},
+		{`08:00`, `17:00`, `07:59`, false},
+		{`08:00`, `17:00`, `08:00`, true},
+		{`08:00`, `17:00`, `16:59`, true},
+		{`08:00`, `17:00`, `17:00`, false},
+		{`22:00`, ``, `23:00`, true},
+		{`22:00`, ``, `01:00`, false},
+		{``, `06:00`, `05:00`, true},
+		{``, `06:00`, `07:00`, false},
+		{``, ``, `03:00`, true},
+		{`12:00`, `12:00`, `12:00`, false},
+		{`00:00`, `00:00`, `12:00`, false},
+	}
+
+	for _, test := range tests {
+		hour, minute, _ := parseTimeOfDay(test.clock)
+		now := time.Date(2026, 10, 19, hour, minute, 30, 0, time.Local)
+
+		if within := withinTimes(now, test.after, test.before); within != test.within {
+			t.Errorf("%s-%s at %s: expected %v, got %v", test.after, test.before, test.clock, test.within, within)
+		}
+	}
+}
+
+func TestRuleEngineTriggered(t *testing.T) {
+	device, receiver := newPipeDevice(t, nil)
+	loud := int64(0x3c)
+	standby := []RuleAction{{SceneStep: SceneStep{Raw: `PWR00`}}}
+
+	engine, err := NewRuleEngine(device, Rules{
+		`power-on`: {
+			When: RuleMatch{Command: `PWR`, Is: `01`},
+			Do:   standby,
+		},
+		`loud-at-night`: {
+			When: RuleMatch{Command: `MVL`, Above: &loud},
+			If:   []RuleCondition{{After: `22:00`, Before: `06:00`}},
+			Do:   standby,
+		},
+		`unmuted-dvd`: {
+			When: RuleMatch{Command: `SLI`, Is: `10`},
+			If:   []RuleCondition{{RuleMatch: RuleMatch{Command: `AMT`, Is: `00`}}},
+			Do:   standby,
+		},
+	}, nil, time.Second)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// conditions on other commands use the state reported by the device
+	setState := func(message string) {
+		receiver.write(t, message)
+
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+			if current, ok := device.State().Get(message[:3]); ok && current.Value() == message[3:] {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", message)
+			}
+		}
+	}
+
+	at := func(day int, clock string) time.Time {
+		hour, minute, _ := parseTimeOfDay(clock)
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		state   string
+		message Message
+		now     time.Time
+		rules   []string
+	}{
+		{``, `!1PWR01`, at(19, `12:00`), []string{`power-on`}},
+		{``, `!1PWR01`, at(19, `12:01`), nil},
+		{``, `!1PWR00`, at(19, `12:02`), nil},
+		{``, `!1PWR01`, at(19, `12:03`), []string{`power-on`}},
+
+		// the time condition spans midnight
+		{``, `!1MVL3D`, at(19, `23:30`), []string{`loud-at-night`}},
+		{``, `!1MVL3E`, at(19, `12:00`), nil},
+		{``, `!1MVL3F`, at(20, `00:30`), []string{`loud-at-night`}},
+		{``, `!1MVL40`, at(20, `05:59`), []string{`loud-at-night`}},
+		{``, `!1MVL41`, at(20, `06:00`), nil},
+		{``, `!1MVL20`, at(20, `01:00`), nil},
+		{``, `!1MVL3C`, at(20, `01:01`), nil},
+
+		{`AMT01`, `!1SLI10`, at(19, `12:00`), nil},
+		{`AMT00`, `!1SLI02`, at(19, `12:00`), nil},
+		{`AMT00`, `!1SLI10`, at(19, `12:00`), []string{`unmuted-dvd`}},
+	}
+
+	for i, test := range tests {
+		if test.state != `` {
+			setState(test.state)
+		}
+
+		names := make([]string, 0)
+
+		for _, rule := range engine.triggered(test.message, test.now) {
+			names = append(names, rule.Name)
+		}
+
+		if len(names) != len(test.rules) || (len(names) > 0 && !reflect.DeepEqual(names, test.rules)) {
+			t.Errorf("%d (%s at %s): expected %v, got %v", i+1, test.message, test.now.Format(`15:04`), test.rules, names)
+		}
+	}
+}
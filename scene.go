@@ -0,0 +1,444 @@
+package onkyo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DEFAULT_SCENE_WAIT_TIMEOUT = time.Duration(10) * time.Second
+
+// Scene is a named sequence of steps, such as turning on the device, selecting an input and
+// setting the volume.
+type Scene struct {
+	Name        string      `yaml:"-" json:"name"`
+	Description string      `yaml:"description,omitempty" json:"description,omitempty"`
+	Zone        string      `yaml:"zone,omitempty" json:"zone,omitempty"`
+	Steps       []SceneStep `yaml:"steps" json:"steps"`
+}
+
+// SceneStep is a single step in a scene.  Exactly one of Set, Raw, Delay, Wait, Fade or If
+// should be specified:
+//
+//	steps:
+//	  - set: master-volume       # set a command (by name or code) to a value (by name or number)
+//	    value: 45
+//	  - raw: DIM08               # send a raw message
+//	  - delay: 2s                # pause
+//	  - wait: system-power       # wait until a command reports the given value
+//	    is: on
+//	    timeout: 15s
+//	  - fade: master-volume      # change a level one step at a time
+//	    to: 25
+//	    over: 10m
+//	  - if: input-selector       # run steps depending on the current value of a command
+//	    is: dvd
+//	    then: [...]
+//	    else: [...]
+type SceneStep struct {
+	Zone    string        `yaml:"zone,omitempty" json:"zone,omitempty"`
+	Set     string        `yaml:"set,omitempty" json:"set,omitempty"`
+	Value   string        `yaml:"value,omitempty" json:"value,omitempty"`
+	Raw     string        `yaml:"raw,omitempty" json:"raw,omitempty"`
+	Delay   time.Duration `yaml:"delay,omitempty" json:"delay,omitempty"`
+	Wait    string        `yaml:"wait,omitempty" json:"wait,omitempty"`
+	If      string        `yaml:"if,omitempty" json:"if,omitempty"`
+	Is      string        `yaml:"is,omitempty" json:"is,omitempty"`
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Fade    string        `yaml:"fade,omitempty" json:"fade,omitempty"`
+	To      string        `yaml:"to,omitempty" json:"to,omitempty"`
+	Over    time.Duration `yaml:"over,omitempty" json:"over,omitempty"`
+	Then    []SceneStep   `yaml:"then,omitempty" json:"then,omitempty"`
+	Else    []SceneStep   `yaml:"else,omitempty" json:"else,omitempty"`
+}
+
+// ValueResolver converts the command and value names used in scenes into a command code and
+// parameter.  If the value is empty, only the command is resolved.
+type ValueResolver interface {
+	Resolve(zone string, command string, value string) (string, string, error)
+}
+
+// RawResolver uses command and value names as-is, so scenes must be written using codes.
+type RawResolver struct{}
+
+func (self RawResolver) Resolve(zone string, command string, value string) (string, string, error) {
+	return strings.ToUpper(command), value, nil
+}
+
+// Scenes is a collection of scenes, keyed by name.
+type Scenes map[string]*Scene
+
+// Names returns the names of all scenes, sorted.
+func (self Scenes) Names() []string {
+	names := make([]string, 0)
+
+	for name := range self {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// LoadScenes reads scenes from YAML in the form:
+//
+//	scenes:
+//	  movie-night:
+//	    description: Movie night
+//	    steps: [...]
+func LoadScenes(r io.Reader) (Scenes, error) {
+	var file struct {
+		Scenes Scenes `yaml:"scenes"`
+	}
+
+	if err := yaml.NewDecoder(r).Decode(&file); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if file.Scenes == nil {
+		file.Scenes = make(Scenes)
+	}
+
+	for name, scene := range file.Scenes {
+		if scene == nil {
+			return nil, fmt.Errorf("Scene %q is empty", name)
+		}
+
+		scene.Name = name
+
+		if err := validateSteps(scene.Steps); err != nil {
+			return nil, fmt.Errorf("Scene %q: %v", name, err)
+		}
+	}
+
+	return file.Scenes, nil
+}
+
+// LoadSceneFile reads scenes from the given file.  A missing file contains no scenes.
+func LoadSceneFile(filename string) (Scenes, error) {
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+
+		return LoadScenes(file)
+	} else if os.IsNotExist(err) {
+		return make(Scenes), nil
+	} else {
+		return nil, err
+	}
+}
+
+func validateSteps(steps []SceneStep) error {
+	for i, step := range steps {
+		actions := 0
+
+		for _, set := range []bool{step.Set != ``, step.Raw != ``, step.Delay > 0, step.Wait != ``, step.Fade != ``, step.If != ``} {
+			if set {
+				actions += 1
+			}
+		}
+
+		if actions != 1 {
+			return fmt.Errorf("step %d must have exactly one of set, raw, delay, wait, fade or if", i+1)
+		}
+
+		if step.Fade != `` && step.To == `` {
+			return fmt.Errorf("step %d: fade requires a value to fade to (to)", i+1)
+		}
+
+		if step.Wait != `` && step.Is == `` {
+			return fmt.Errorf("step %d: wait requires a value to wait for (is)", i+1)
+		}
+
+		if step.If != `` {
+			if step.Is == `` {
+				return fmt.Errorf("step %d: if requires a value to compare with (is)", i+1)
+			} else if err := validateSteps(step.Then); err != nil {
+				return fmt.Errorf("step %d: then: %v", i+1, err)
+			} else if err := validateSteps(step.Else); err != nil {
+				return fmt.Errorf("step %d: else: %v", i+1, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SceneRunner performs the steps of scenes against a device.
+type SceneRunner struct {
+	Device   *Device
+	Resolver ValueResolver
+	Timeout  time.Duration
+
+	// Zone is used by scenes that don't specify one.
+	Zone string
+
+	// DryRun resolves every step without sending anything, delaying or waiting.  Conditions
+	// are evaluated against the device if there is one, otherwise the "then" steps are assumed.
+	DryRun bool
+
+	// Trace, if set, is called to describe each step as it is performed.
+	Trace func(format string, args ...interface{})
+}
+
+func NewSceneRunner(device *Device, resolver ValueResolver, timeout time.Duration) *SceneRunner {
+	if resolver == nil {
+		resolver = RawResolver{}
+	}
+
+	return &SceneRunner{
+		Device:   device,
+		Resolver: resolver,
+		Timeout:  timeout,
+		Zone:     `main`,
+	}
+}
+
+// Run performs all of the steps of the scene, returning the messages that were (or, in a dry
+// run, would have been) sent.
+func (self *SceneRunner) Run(scene *Scene) ([]Message, error) {
+	if !self.DryRun && self.Device == nil {
+		return nil, fmt.Errorf("No device to run scene %q on", scene.Name)
+	}
+
+	zone := scene.Zone
+
+	if zone == `` {
+		zone = self.Zone
+	}
+
+	self.trace("Running scene %q", scene.Name)
+
+	return self.runSteps(zone, scene.Steps)
+}
+
+func (self *SceneRunner) runSteps(zone string, steps []SceneStep) ([]Message, error) {
+	sent := make([]Message, 0)
+
+	for _, step := range steps {
+		stepZone := zone
+
+		if step.Zone != `` {
+			stepZone = step.Zone
+		}
+
+		switch {
+		case step.Set != ``:
+			if code, param, err := self.Resolver.Resolve(stepZone, step.Set, step.Value); err == nil {
+				if message, err := self.send(code, param); err == nil {
+					sent = append(sent, message)
+				} else {
+					return sent, err
+				}
+			} else {
+				return sent, err
+			}
+
+		case step.Raw != ``:
+			code, value, err := ParseRawMessage(step.Raw)
+
+			if err != nil {
+				return sent, err
+			}
+
+			if message, err := self.send(code, value); err == nil {
+				sent = append(sent, message)
+			} else {
+				return sent, err
+			}
+
+		case step.Delay > 0:
+			self.trace("Delay %v", step.Delay)
+
+			if !self.DryRun {
+				time.Sleep(step.Delay)
+			}
+
+		case step.Wait != ``:
+			if err := self.wait(stepZone, step); err != nil {
+				return sent, err
+			}
+
+		case step.Fade != ``:
+			messages, err := self.fade(stepZone, step)
+			sent = append(sent, messages...)
+
+			if err != nil {
+				return sent, err
+			}
+
+		case step.If != ``:
+			if matched, err := self.evaluate(stepZone, step.If, step.Is); err == nil {
+				branch := step.Else
+
+				if matched {
+					branch = step.Then
+				}
+
+				messages, err := self.runSteps(stepZone, branch)
+				sent = append(sent, messages...)
+
+				if err != nil {
+					return sent, err
+				}
+			} else {
+				return sent, err
+			}
+		}
+	}
+
+	return sent, nil
+}
+
+func (self *SceneRunner) trace(format string, args ...interface{}) {
+	if self.Trace != nil {
+		self.Trace(format, args...)
+	}
+}
+
+func (self *SceneRunner) send(code string, param string) (Message, error) {
+	category := CategoryDevice
+
+	if self.Device != nil {
+		category = self.Device.Info().Category
+	}
+
+	message := Message(`!` + category.String() + code + param)
+	self.trace("Send %s", message)
+
+	if self.DryRun {
+		return message, nil
+	}
+
+	return message, self.Device.Send(code, param)
+}
+
+// evaluate returns whether the command currently has the given value.
+func (self *SceneRunner) evaluate(zone string, command string, value string) (bool, error) {
+	code, param, err := self.Resolver.Resolve(zone, command, value)
+
+	if err != nil {
+		return false, err
+	}
+
+	if self.Device == nil {
+		self.trace("If %s is %s (assumed true)", command, value)
+		return true, nil
+	}
+
+	if message, err := self.Device.Query(self.Timeout, code); err == nil {
+		matched := strings.EqualFold(message.Value(), param)
+		self.trace("If %s is %s (%v)", command, value, matched)
+		return matched, nil
+	} else {
+		return false, err
+	}
+}
+
+// fade changes a numeric value (such as a volume) one step at a time, spreading the changes
+// evenly over the duration given in the step.
+func (self *SceneRunner) fade(zone string, step SceneStep) ([]Message, error) {
+	code, param, err := self.Resolver.Resolve(zone, step.Fade, step.To)
+
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := strconv.ParseInt(param, 16, 32)
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot fade %s to non-numeric value %q", step.Fade, step.To)
+	}
+
+	self.trace("Fade %s to %s over %v", step.Fade, step.To, step.Over)
+
+	if self.DryRun {
+		message, err := self.send(code, param)
+		return []Message{message}, err
+	}
+
+	current, err := self.Device.Query(self.Timeout, code)
+
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := strconv.ParseInt(current.Value(), 16, 32)
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot fade %s from non-numeric value %q", step.Fade, current.Value())
+	}
+
+	sent := make([]Message, 0)
+	direction := int64(1)
+	steps := target - level
+
+	if steps < 0 {
+		direction = -1
+		steps = -steps
+	}
+
+	for level != target {
+		if steps > 0 {
+			time.Sleep(step.Over / time.Duration(steps))
+		}
+
+		level += direction
+
+		if message, err := self.send(code, fmt.Sprintf("%02X", level)); err == nil {
+			sent = append(sent, message)
+		} else {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// wait blocks until the command reports the value given in the step.
+func (self *SceneRunner) wait(zone string, step SceneStep) error {
+	code, param, err := self.Resolver.Resolve(zone, step.Wait, step.Is)
+
+	if err != nil {
+		return err
+	}
+
+	self.trace("Wait until %s is %s", step.Wait, step.Is)
+
+	if self.DryRun {
+		return nil
+	}
+
+	timeout := step.Timeout
+
+	if timeout == 0 {
+		timeout = DEFAULT_SCENE_WAIT_TIMEOUT
+	}
+
+	sub := self.Device.Subscribe()
+	defer self.Device.Unsubscribe(sub)
+
+	if err := self.Device.Send(code, `QSTN`); err != nil {
+		return err
+	}
+
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case message, ok := <-sub:
+			if !ok {
+				return fmt.Errorf("Connection closed")
+			} else if message.Code() == code && strings.EqualFold(message.Value(), param) {
+				return nil
+			}
+		case <-deadline:
+			return fmt.Errorf("Timed out waiting for %s to be %s", step.Wait, step.Is)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package onkyo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// zoneResolver records the zone each command was resolved in.
+type zoneResolver struct {
+	resolved []string
+}
+
+func (self *zoneResolver) Resolve(zone string, command string, value string) (string, string, error) {
+	self.resolved = append(self.resolved, zone+`:`+command)
+	return command, value, nil
+}
+
+func TestSceneConditionalStepsInheritZone(t *testing.T) {
+	resolver := &zoneResolver{}
+	runner := NewSceneRunner(nil, resolver, time.Second)
+	runner.DryRun = true
+
+	scene := &Scene{
+		Name: `test`,
+		Zone: `main`,
+		Steps: []SceneStep{
+			{Set: `PWR`, Value: `01`},
+			{
+				Zone: `zone2`,
+				If:   `ZPW`,
+				Is:   `01`,
+				Then: []SceneStep{
+					{Set: `ZVL`, Value: `20`},
+					{Zone: `zone3`, Set: `VL3`, Value: `10`},
+				},
+			},
+			{Set: `MVL`, Value: `30`},
+		},
+	}
+
+	if _, err := runner.Run(scene); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{`main:PWR`, `zone2:ZPW`, `zone2:ZVL`, `zone3:VL3`, `main:MVL`}
+
+	if !reflect.DeepEqual(resolver.resolved, expected) {
+		t.Errorf("expected %v, got %v", expected, resolver.resolved)
+	}
+}
@@ -0,0 +1,503 @@
+package onkyo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DEFAULT_SCHEDULE_CATCH_UP = time.Duration(15) * time.Minute
+const DEFAULT_SCHEDULE_CHECK_INTERVAL = time.Duration(1) * time.Minute
+
+// Schedule runs jobs at given times of day, read from YAML in the form:
+//
+//	timezone: Europe/Berlin      # defaults to the local time zone
+//	catch-up: 1h                 # run jobs missed (e.g.: while stopped) up to this long ago
+//	jobs:
+//	  wake-up:
+//	    days: weekdays           # daily (the default), weekends, or days such as "mon,wed,fri"
+//	    at: "06:30"
+//	    zone: zone2
+//	    steps:                   # the same steps as in scenes
+//	      - set: power
+//	        value: on
+//	      - fade: volume
+//	        to: 25
+//	        over: 10m
+//	  bedtime:
+//	    at: "23:00"
+//	    scene: all-off           # run a scene from the scenes file
+type Schedule struct {
+	Timezone string          `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	CatchUp  time.Duration   `yaml:"catch-up,omitempty" json:"catch_up,omitempty"`
+	Jobs     map[string]*Job `yaml:"jobs" json:"jobs"`
+}
+
+// Job is a scene (or list of steps) that is run at the same time on certain days of the week.
+// Times are calculated in the job's time zone, so jobs keep to the wall clock across daylight
+// saving time changes.
+type Job struct {
+	Name     string      `yaml:"-" json:"name"`
+	At       string      `yaml:"at" json:"at"`
+	Days     Weekdays    `yaml:"days,omitempty" json:"days"`
+	Timezone string      `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	Scene    string      `yaml:"scene,omitempty" json:"scene,omitempty"`
+	Zone     string      `yaml:"zone,omitempty" json:"zone,omitempty"`
+	Steps    []SceneStep `yaml:"steps,omitempty" json:"steps,omitempty"`
+	hour     int
+	minute   int
+	location *time.Location
+}
+
+// Weekdays is a set of days of the week.  The empty set means every day.
+type Weekdays uint8
+
+var weekdayNames = []string{`sun`, `mon`, `tue`, `wed`, `thu`, `fri`, `sat`}
+
+// ParseWeekdays parses "daily", "weekdays", "weekends" or a comma-separated list of days (by
+// their first three letters).
+func ParseWeekdays(spec string) (Weekdays, error) {
+	var days Weekdays
+
+	for _, name := range strings.Split(strings.ToLower(spec), `,`) {
+		switch name = strings.TrimSpace(name); name {
+		case ``, `daily`:
+			days |= 0x7f
+		case `weekdays`:
+			days |= 0x3e
+		case `weekends`:
+			days |= 0x41
+		default:
+			found := false
+
+			for i, day := range weekdayNames {
+				if strings.HasPrefix(name, day) {
+					days |= (1 << uint(i))
+					found = true
+				}
+			}
+
+			if !found {
+				return 0, fmt.Errorf("Unknown day %q", name)
+			}
+		}
+	}
+
+	return days, nil
+}
+
+// Contains returns whether the given day is in the set.
+func (self Weekdays) Contains(day time.Weekday) bool {
+	return self == 0 || self&(1<<uint(day)) != 0
+}
+
+func (self Weekdays) String() string {
+	switch self {
+	case 0, 0x7f:
+		return `daily`
+	case 0x3e:
+		return `weekdays`
+	case 0x41:
+		return `weekends`
+	}
+
+	names := make([]string, 0)
+
+	for i, day := range weekdayNames {
+		if self&(1<<uint(i)) != 0 {
+			names = append(names, day)
+		}
+	}
+
+	return strings.Join(names, `,`)
+}
+
+func (self Weekdays) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+// UnmarshalYAML accepts a single string (see ParseWeekdays) or a list of days.
+func (self *Weekdays) UnmarshalYAML(node *yaml.Node) error {
+	var spec string
+
+	if node.Kind == yaml.SequenceNode {
+		var days []string
+
+		if err := node.Decode(&days); err != nil {
+			return err
+		}
+
+		spec = strings.Join(days, `,`)
+	} else if err := node.Decode(&spec); err != nil {
+		return err
+	}
+
+	days, err := ParseWeekdays(spec)
+	*self = days
+	return err
+}
+
+// LoadSchedule reads a schedule from YAML, checking that every job is valid.
+func LoadSchedule(r io.Reader) (*Schedule, error) {
+	schedule := &Schedule{}
+
+	if err := yaml.NewDecoder(r).Decode(schedule); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if schedule.Jobs == nil {
+		schedule.Jobs = make(map[string]*Job)
+	}
+
+	if schedule.CatchUp == 0 {
+		schedule.CatchUp = DEFAULT_SCHEDULE_CATCH_UP
+	}
+
+	location, err := loadLocation(schedule.Timezone)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for name, job := range schedule.Jobs {
+		if job == nil {
+			return nil, fmt.Errorf("Job %q is empty", name)
+		}
+
+		job.Name = name
+		job.location = location
+
+		if job.Timezone != `` {
+			if job.location, err = loadLocation(job.Timezone); err != nil {
+				return nil, fmt.Errorf("Job %q: %v", name, err)
+			}
+		}
+
+		if job.hour, job.minute, err = parseTimeOfDay(job.At); err != nil {
+			return nil, fmt.Errorf("Job %q: %v", name, err)
+		}
+
+		if (job.Scene == ``) == (len(job.Steps) == 0) {
+			return nil, fmt.Errorf("Job %q must have either a scene or steps", name)
+		} else if err := validateSteps(job.Steps); err != nil {
+			return nil, fmt.Errorf("Job %q: %v", name, err)
+		}
+	}
+
+	return schedule, nil
+}
+
+// LoadScheduleFile reads a schedule from the given file.
+func LoadScheduleFile(filename string) (*Schedule, error) {
+	if file, err := os.Open(filename); err == nil {
+		defer file.Close()
+
+		return LoadSchedule(file)
+	} else {
+		return nil, err
+	}
+}
+
+// parseTimeOfDay parses a time of day given as HH:MM.
+func parseTimeOfDay(clock string) (int, int, error) {
+	var hour, minute int
+
+	if _, err := fmt.Sscanf(clock, "%d:%d", &hour, &minute); err != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("Invalid time %q (expected HH:MM)", clock)
+	}
+
+	return hour, minute, nil
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == `` {
+		return time.Local, nil
+	}
+
+	return time.LoadLocation(name)
+}
+
+// Next returns the first time the job is scheduled to run after the given time.
+func (self *Job) Next(after time.Time) time.Time {
+	location := self.location
+
+	if location == nil {
+		location = time.Local
+	}
+
+	local := after.In(location)
+
+	// every day of the week is covered
+	for i := 0; i <= 8; i++ {
+		at := time.Date(local.Year(), local.Month(), local.Day()+i, self.hour, self.minute, 0, 0, location)
+
+		// time.Date moves a time skipped by a DST change earlier (e.g. 02:30 becomes 01:30);
+		// run it after the clocks have gone forward instead (at 03:30)
+		wanted := self.hour*60 + self.minute
+		actual := at.Hour()*60 + at.Minute()
+
+		if skipped := (wanted - actual + 24*60) % (24 * 60); skipped != 0 {
+			at = at.Add(time.Duration(skipped) * time.Minute)
+		}
+
+		if at.After(after) && self.Days.Contains(at.Weekday()) {
+			return at
+		}
+	}
+
+	return time.Time{}
+}
+
+// SceneIn returns the scene the job runs, looking it up in the given scenes if necessary.  A
+// zone given by the job is used in place of the named scene's own.
+func (self *Job) SceneIn(scenes Scenes) (*Scene, error) {
+	if self.Scene != `` {
+		if scene, ok := scenes[self.Scene]; ok {
+			if self.Zone != `` {
+				zoned := *scene
+				zoned.Zone = self.Zone
+				return &zoned, nil
+			}
+
+			return scene, nil
+		} else {
+			return nil, fmt.Errorf("Scene %q not found", self.Scene)
+		}
+	}
+
+	return &Scene{
+		Name:  self.Name,
+		Zone:  self.Zone,
+		Steps: self.Steps,
+	}, nil
+}
+
+// ScheduledRun is a time a job is due to run.
+type ScheduledRun struct {
+	Job  string    `json:"job"`
+	Time time.Time `json:"time"`
+}
+
+// Upcoming returns the next runs of every job after the given time, in order.
+func (self *Schedule) Upcoming(after time.Time, count int) []ScheduledRun {
+	runs := make([]ScheduledRun, 0)
+	next := make(map[string]time.Time)
+
+	for name, job := range self.Jobs {
+		next[name] = job.Next(after)
+	}
+
+	for len(runs) < count && len(next) > 0 {
+		earliest := ``
+
+		for name, at := range next {
+			if earliest == `` || at.Before(next[earliest]) || (at.Equal(next[earliest]) && name < earliest) {
+				earliest = name
+			}
+		}
+
+		runs = append(runs, ScheduledRun{
+			Job:  earliest,
+			Time: next[earliest],
+		})
+
+		next[earliest] = self.Jobs[earliest].Next(next[earliest])
+	}
+
+	return runs
+}
+
+// JobResult describes the last time a job was run (or skipped).
+type JobResult struct {
+	Job       string        `json:"job"`
+	Scheduled time.Time     `json:"scheduled"`
+	Started   *time.Time    `json:"started,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+	Skipped   bool          `json:"skipped,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Scheduler runs the jobs in a schedule as they become due.  The time it last checked for due
+// jobs is kept in a state file, so that jobs missed while it wasn't running can be caught up
+// on when it is restarted.
+type Scheduler struct {
+	Schedule  *Schedule
+	StateFile string
+	Execute   func(job *Job) error
+	checked   time.Time
+	results   map[string]*JobResult
+	running   map[string]bool
+	lock      sync.Mutex
+}
+
+type schedulerState struct {
+	Checked time.Time `json:"checked"`
+}
+
+func NewScheduler(schedule *Schedule, stateFile string, execute func(job *Job) error) *Scheduler {
+	return &Scheduler{
+		Schedule:  schedule,
+		StateFile: stateFile,
+		Execute:   execute,
+		results:   make(map[string]*JobResult),
+		running:   make(map[string]bool),
+	}
+}
+
+// Results returns the outcome of the last run of each job.
+func (self *Scheduler) Results() map[string]JobResult {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	results := make(map[string]JobResult)
+
+	for name, result := range self.results {
+		results[name] = *result
+	}
+
+	return results
+}
+
+// Run runs jobs as they become due, until the stop channel is closed.
+func (self *Scheduler) Run(stop <-chan bool) error {
+	self.checked = time.Now()
+
+	if self.StateFile != `` {
+		if data, err := ioutil.ReadFile(self.StateFile); err == nil {
+			var state schedulerState
+
+			if err := json.Unmarshal(data, &state); err == nil && !state.Checked.IsZero() {
+				self.checked = state.Checked
+			} else {
+				log.Warningf("Ignoring invalid scheduler state in %s", self.StateFile)
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for {
+		// jobs that became due while stopped (or suspended) are found the same way as ones
+		// that are due now
+		self.runDue(time.Now())
+
+		wait := DEFAULT_SCHEDULE_CHECK_INTERVAL
+
+		// the wall clock may jump, so it is checked at least this often
+		if upcoming := self.Schedule.Upcoming(self.checked, 1); len(upcoming) > 0 {
+			if until := time.Until(upcoming[0].Time); until < wait {
+				wait = until
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runDue starts every job that was due to run since the last check, then records the check.
+func (self *Scheduler) runDue(now time.Time) {
+	for name, job := range self.Schedule.Jobs {
+		var due time.Time
+
+		for at := job.Next(self.checked); !at.IsZero() && !at.After(now); at = job.Next(at) {
+			due = at
+		}
+
+		if due.IsZero() {
+			continue
+		}
+
+		if late := now.Sub(due); late > self.Schedule.CatchUp {
+			log.Warningf("Skipping job %q: it was due at %v (%v ago)", name, due, late.Round(time.Second))
+			self.setResult(&JobResult{Job: name, Scheduled: due, Skipped: true, Error: `missed`})
+		} else {
+			go self.run(job, due)
+		}
+	}
+
+	self.checked = now
+	self.saveState()
+}
+
+func (self *Scheduler) run(job *Job, scheduled time.Time) {
+	self.lock.Lock()
+
+	if self.running[job.Name] {
+		self.lock.Unlock()
+		log.Warningf("Skipping job %q: the previous run is still in progress", job.Name)
+		return
+	}
+
+	self.running[job.Name] = true
+	self.lock.Unlock()
+
+	defer func() {
+		self.lock.Lock()
+		delete(self.running, job.Name)
+		self.lock.Unlock()
+	}()
+
+	started := time.Now()
+	result := &JobResult{
+		Job:       job.Name,
+		Scheduled: scheduled,
+		Started:   &started,
+	}
+
+	log.Noticef("Running job %q (scheduled for %v)", job.Name, scheduled)
+
+	err := self.Execute(job)
+	result.Duration = time.Since(started)
+
+	if err == nil {
+		log.Noticef("Job %q completed in %v", job.Name, result.Duration.Round(time.Millisecond))
+	} else {
+		result.Error = err.Error()
+		log.Errorf("Job %q failed after %v: %v", job.Name, result.Duration.Round(time.Millisecond), err)
+	}
+
+	self.setResult(result)
+}
+
+func (self *Scheduler) setResult(result *JobResult) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.results[result.Job] = result
+}
+
+func (self *Scheduler) saveState() {
+	if self.StateFile == `` {
+		return
+	}
+
+	if data, err := json.Marshal(schedulerState{Checked: self.checked}); err == nil {
+		if err := ioutil.WriteFile(self.StateFile, data, 0644); err != nil {
+			log.Warningf("Failed to save scheduler state: %v", err)
+		}
+	}
+}
+
+// Names returns the names of every job in the schedule, sorted.
+func (self *Schedule) Names() []string {
+	names := make([]string, 0)
+
+	for name := range self.Jobs {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,162 @@
+package onkyo
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseWeekdays(t *testing.T) {
+	tests := []struct {
+		spec string
+		days Weekdays
+		str  string
+		err  bool
+	}{
+		{`daily`, 0x7f, `daily`, false},
+		{``, 0x7f, `daily`, false},
+		{`weekdays`, 0x3e, `weekdays`, false},
+		{`Weekends`, 0x41, `weekends`, false},
+		{`sat,sun`, 0x41, `weekends`, false},
+		{`mon,wed`, 0x0a, `mon,wed`, false},
+		{`Monday, Friday`, 0x22, `mon,fri`, false},
+		{`weekends,wed`, 0x49, `sun,wed,sat`, false},
+		{`funday`, 0, ``, true},
+		{`mon,,fri`, 0x7f, `daily`, false},
+	}
+
+	for _, test := range tests {
+		days, err := ParseWeekdays(test.spec)
+
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error state: %v", test.spec, err)
+		} else if !test.err && (days != test.days || days.String() != test.str) {
+			t.Errorf("%q: expected %#x (%s), got %#x (%s)", test.spec, test.days, test.str, days, days)
+		}
+	}
+
+	weekdays, _ := ParseWeekdays(`weekdays`)
+
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if weekdays.Contains(day) != (day != time.Saturday && day != time.Sunday) {
+			t.Errorf("weekdays: unexpected Contains(%s)", day)
+		}
+
+		if !Weekdays(0).Contains(day) {
+			t.Errorf("the empty set should contain %s", day)
+		}
+	}
+}
+
+func TestJobNext(t *testing.T) {
+	schedule, err := LoadSchedule(strings.NewReader(`
+timezone: America/New_York
+jobs:
+  early:
+    at: "02:30"
+    scene: test
+  ambiguous:
+    at: "01:30"
+    scene: test
+  morning:
+    at: "08:00"
+    scene: test
+  workdays:
+    at: "07:00"
+    days: weekdays
+    scene: test
+  listed:
+    at: "22:15"
+    days: [mon, fri]
+    scene: test
+  tokyo:
+    at: "09:00"
+    timezone: Asia/Tokyo
+    scene: test
+`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newYork, _ := time.LoadLocation(`America/New_York`)
+	at := func(value string) time.Time {
+		if v, err := time.ParseInLocation(`2006-01-02 15:04 MST`, value, newYork); err == nil {
+			return v
+		} else {
+			t.Fatal(err)
+			return time.Time{}
+		}
+	}
+
+	// in 2026 DST starts on March 8th at 02:00 EST and ends on November 1st at 02:00 EDT
+	tests := []struct {
+		job   string
+		after time.Time
+		next  time.Time
+	}{
+		{`morning`, at(`2026-03-07 08:00 EST`), at(`2026-03-08 08:00 EDT`)},
+		{`morning`, at(`2026-10-31 08:00 EDT`), at(`2026-11-01 08:00 EST`)},
+		{`morning`, at(`2026-03-08 07:59 EDT`), at(`2026-03-08 08:00 EDT`)},
+
+		// 02:30 doesn't exist when the clocks go forward, so the job runs an hour later
+		{`early`, at(`2026-03-07 03:00 EST`), at(`2026-03-08 03:30 EDT`)},
+		{`early`, at(`2026-03-08 03:30 EDT`), at(`2026-03-09 02:30 EDT`)},
+
+		// 01:30 happens twice when the clocks go back; the job only runs the first time
+		{`ambiguous`, at(`2026-10-31 12:00 EDT`), at(`2026-11-01 01:30 EDT`)},
+		{`ambiguous`, at(`2026-11-01 01:30 EDT`), at(`2026-11-02 01:30 EST`)},
+
+		{`workdays`, at(`2026-10-16 07:00 EDT`), at(`2026-10-19 07:00 EDT`)},
+		{`workdays`, at(`2026-10-17 12:00 EDT`), at(`2026-10-19 07:00 EDT`)},
+		{`workdays`, at(`2026-10-19 06:59 EDT`), at(`2026-10-19 07:00 EDT`)},
+		{`listed`, at(`2026-10-19 22:15 EDT`), at(`2026-10-23 22:15 EDT`)},
+		{`listed`, at(`2026-10-23 23:00 EDT`), at(`2026-10-26 22:15 EDT`)},
+
+		// a job's own timezone overrides the schedule's
+		{`tokyo`, at(`2026-10-19 12:00 EDT`), at(`2026-10-19 20:00 EDT`)},
+	}
+
+	for _, test := range tests {
+		if next := schedule.Jobs[test.job].Next(test.after); !next.Equal(test.next) {
+			t.Errorf("%s after %v: expected %v, got %v", test.job, test.after, test.next, next.In(newYork))
+		}
+	}
+}
+
+func TestJobSceneIn(t *testing.T) {
+	scenes := Scenes{
+		`movie`: {Name: `movie`, Zone: `main`, Steps: []SceneStep{{Raw: `PWR01`}}},
+		`music`: {Name: `music`, Steps: []SceneStep{{Raw: `SLI2B`}}},
+	}
+
+	tests := []struct {
+		job   Job
+		name  string
+		zone  string
+		steps string
+		err   bool
+	}{
+		{Job{Name: `evening`, Scene: `movie`}, `movie`, `main`, `PWR01`, false},
+		{Job{Name: `evening`, Scene: `movie`, Zone: `zone2`}, `movie`, `zone2`, `PWR01`, false},
+		{Job{Name: `morning`, Scene: `music`, Zone: `zone3`}, `music`, `zone3`, `SLI2B`, false},
+		{Job{Name: `wake-up`, Zone: `zone2`, Steps: []SceneStep{{Raw: `ZPW01`}}}, `wake-up`, `zone2`, `ZPW01`, false},
+		{Job{Name: `missing`, Scene: `party`, Zone: `zone2`}, ``, ``, ``, true},
+	}
+
+	for _, test := range tests {
+		scene, err := test.job.SceneIn(scenes)
+
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error state: %v", test.job.Name, err)
+		} else if !test.err && (scene.Name != test.name || scene.Zone != test.zone || scene.Steps[0].Raw != test.steps) {
+			t.Errorf("%s: expected %s in %s, got %+v", test.job.Name, test.name, test.zone, scene)
+		}
+	}
+
+	// the named scene itself is left as it was
+	if scenes[`movie`].Zone != `main` || scenes[`music`].Zone != `` {
+		t.Errorf("expected the scenes' own zones to be unchanged")
+	}
+}
@@ -0,0 +1,51 @@
+package onkyo
+
+import (
+	"io"
+
+	"github.com/tarm/serial"
+)
+
+const DEFAULT_SERIAL_BAUD = 9600
+
+// messages sent over RS-232 are terminated with a carriage return; the device terminates its
+// messages with an EOF character (and some models follow it with CR/LF)
+const iscpTerminator = "\r"
+const iscpEOF = '\x1a'
+
+// SerialAddr is the address of a device connected to a serial port.
+type SerialAddr string
+
+func (self SerialAddr) Network() string {
+	return `serial`
+}
+
+func (self SerialAddr) String() string {
+	return string(self)
+}
+
+// NewSerialTransport returns a transport that opens the given serial port at the given
+// speed using 8 data bits, no parity and one stop bit.
+func NewSerialTransport(path string, baud int) *StreamTransport {
+	return NewStreamTransport(FramingISCP, func() (io.ReadWriteCloser, error) {
+		return serial.OpenPort(&serial.Config{
+			Name:     path,
+			Baud:     baud,
+			Size:     8,
+			Parity:   serial.ParityNone,
+			StopBits: serial.Stop1,
+		})
+	})
+}
+
+// NewSerialDevice connects to a device on the given serial port.  Devices on a serial line
+// can't be discovered, so the given info is used as-is.
+func NewSerialDevice(path string, baud int, info DeviceInfo, opts *DeviceOptions) (*Device, error) {
+	if opts == nil {
+		opts = &DeviceOptions{}
+	}
+
+	opts.Address = SerialAddr(path)
+
+	return NewDeviceFromTransport(NewSerialTransport(path, baud), info, opts)
+}
@@ -0,0 +1,85 @@
+package onkyo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// openPty opens a pseudo-terminal pair, returning the master and the path of the slave.
+func openPty() (*os.File, string, error) {
+	master, err := os.OpenFile(`/dev/ptmx`, os.O_RDWR|syscall.O_NOCTTY, 0)
+
+	if err != nil {
+		return nil, ``, err
+	}
+
+	var unlock int32
+	var number uint32
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		return nil, ``, errno
+	}
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); errno != 0 {
+		master.Close()
+		return nil, ``, errno
+	}
+
+	return master, fmt.Sprintf("/dev/pts/%d", number), nil
+}
+
+func TestSerialDeviceOverPty(t *testing.T) {
+	if _, err := os.Stat(`/dev/ptmx`); err != nil {
+		t.Skipf("pseudo-terminals are unavailable: %v", err)
+	}
+
+	master, slave, err := openPty()
+
+	if err != nil {
+		t.Skipf("failed to open a pseudo-terminal: %v", err)
+	}
+
+	device, err := NewSerialDevice(slave, DEFAULT_SERIAL_BAUD, DeviceInfo{Model: `TX-TEST`}, nil)
+
+	if err != nil {
+		master.Close()
+		t.Fatal(err)
+	}
+
+	// reads from the port block, so hang up first to let the device close
+	defer device.Close()
+	defer master.Close()
+
+	if device.Address().String() != slave {
+		t.Errorf("expected the address to be %s, got %s", slave, device.Address())
+	}
+
+	// the receiver terminates its messages with EOF, some models follow it with CR/LF
+	go func() {
+		reader := bufio.NewReader(master)
+
+		if message, err := readISCPFrame(reader); err != nil {
+			t.Errorf("Failed to read from device: %v", err)
+		} else if message != `!1PWRQSTN` {
+			t.Errorf("expected !1PWRQSTN, got %q", message)
+		}
+
+		master.Write([]byte("!1MVL2A\x1a\r\n!1PWR01\x1a"))
+	}()
+
+	if message, err := device.Query(2*time.Second, `PWR`); err != nil {
+		t.Fatal(err)
+	} else if message != `!1PWR01` {
+		t.Errorf("expected !1PWR01, got %q", message)
+	}
+
+	if message, ok := device.State().Get(`MVL`); !ok || message != `!1MVL2A` {
+		t.Errorf("expected !1MVL2A in state, got %q", message)
+	}
+}
@@ -0,0 +1,98 @@
+package onkyo
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadISCPFrame(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		messages []Message
+	}{
+		{`CR`, "!1PWR01\r!1MVL2A\r", []Message{`!1PWR01`, `!1MVL2A`}},
+		{`CRLF`, "!1PWR01\r\n!1MVL2A\r\n", []Message{`!1PWR01`, `!1MVL2A`}},
+		{`EOF`, "!1PWR01\x1a!1MVL2A\x1a", []Message{`!1PWR01`, `!1MVL2A`}},
+		{`EOF followed by CRLF`, "!1PWR01\x1a\r\n!1MVL2A\x1a\r\n", []Message{`!1PWR01`, `!1MVL2A`}},
+		{`LF`, "!1PWR01\n", []Message{`!1PWR01`}},
+		{`leading line noise`, "\x00\xff!1SLI10\r", []Message{`!1SLI10`}},
+		{`empty frames`, "\r\n\r\n\x1a!1AMT00\r", []Message{`!1AMT00`}},
+	}
+
+	for _, test := range tests {
+		reader := bufio.NewReader(strings.NewReader(test.data))
+
+		for _, expected := range test.messages {
+			if message, err := readISCPFrame(reader); err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			} else if message != expected {
+				t.Errorf("%s: expected %q, got %q", test.name, expected, message)
+			}
+		}
+
+		if _, err := readISCPFrame(reader); err != io.EOF {
+			t.Errorf("%s: expected EOF after the last frame, got %v", test.name, err)
+		}
+	}
+}
+
+func TestReadISCPFrameMalformed(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("garbage\r!1P\r!1PWR01\r!1MVL"))
+
+	for i := 0; i < 2; i++ {
+		if _, err := readISCPFrame(reader); err == nil {
+			t.Errorf("expected an error for malformed frame %d", i+1)
+		} else if _, ok := err.(*DecodeError); !ok {
+			t.Errorf("expected a *DecodeError, got %T: %v", err, err)
+		}
+	}
+
+	// the reader is still usable after a malformed frame
+	if message, err := readISCPFrame(reader); err != nil || message != `!1PWR01` {
+		t.Errorf("expected !1PWR01, got %q (%v)", message, err)
+	}
+
+	// a frame cut off by the end of the stream is not returned
+	if _, err := readISCPFrame(reader); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+// bufferConn is an in-memory stream that records what is written to it.
+type bufferConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (self *bufferConn) Write(data []byte) (int, error) {
+	return self.Buffer.Write(data)
+}
+
+func (self *bufferConn) Read(data []byte) (int, error) {
+	return self.Reader.Read(data)
+}
+
+func (self *bufferConn) Close() error {
+	return nil
+}
+
+func TestISCPWriteFrame(t *testing.T) {
+	conn := &bufferConn{Reader: strings.NewReader(``)}
+	transport := NewConnTransport(conn, FramingISCP)
+
+	if err := transport.Dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := transport.WriteFrame(`!1PWRQSTN`); err != nil {
+		t.Fatal(err)
+	}
+
+	if written := conn.String(); written != "!1PWRQSTN\r" {
+		t.Errorf("expected %q, got %q", "!1PWRQSTN\r", written)
+	}
+}
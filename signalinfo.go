@@ -0,0 +1,269 @@
+package onkyo
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sampleRatePattern = regexp.MustCompile(`^([\d.]+)\s*kHz$`)
+var channelLayoutPattern = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?\s*ch$`)
+var resolutionPattern = regexp.MustCompile(`^(\d+)\s*x\s*(\d+)\s*([pi])?\s*(?:([\d.]+)\s*Hz)?$`)
+
+// ChannelLayout describes a speaker configuration such as "5.1.2 ch".
+type ChannelLayout struct {
+	Main   int
+	LFE    int
+	Height int
+}
+
+func (self ChannelLayout) String() string {
+	if self.Height > 0 {
+		return fmt.Sprintf("%d.%d.%d ch", self.Main, self.LFE, self.Height)
+	}
+
+	return fmt.Sprintf("%d.%d ch", self.Main, self.LFE)
+}
+
+// Total returns the total number of channels.
+func (self ChannelLayout) Total() int {
+	return self.Main + self.LFE + self.Height
+}
+
+// ParseChannelLayout decodes channel descriptions like "2.0 ch", "5.1ch" or "7.1.4 ch".
+func ParseChannelLayout(value string) (ChannelLayout, error) {
+	if match := channelLayoutPattern.FindStringSubmatch(strings.TrimSpace(value)); match != nil {
+		layout := ChannelLayout{}
+		layout.Main, _ = strconv.Atoi(match[1])
+		layout.LFE, _ = strconv.Atoi(match[2])
+
+		if match[3] != `` {
+			layout.Height, _ = strconv.Atoi(match[3])
+		}
+
+		return layout, nil
+	}
+
+	return ChannelLayout{}, fmt.Errorf("Malformed channel layout %q", value)
+}
+
+// AudioInfo is a decoded IFA message.
+type AudioInfo struct {
+	InputPort        string
+	InputFormat      string
+	SampleRate       int
+	InputChannels    string
+	ListeningMode    string
+	OutputChannels   string
+	OutputSampleRate int
+	Extra            []string
+	Raw              string
+}
+
+// String summarizes the signal, e.g. "HDMI 1 PCM 48 kHz 2.0 ch -> Stereo 5.1 ch".
+func (self *AudioInfo) String() string {
+	return joinFields(` -> `,
+		joinFields(` `, self.InputPort, self.InputFormat, formatSampleRate(self.SampleRate), self.InputChannels),
+		joinFields(` `, self.ListeningMode, self.OutputChannels))
+}
+
+// InputLayout returns the channel layout of the input signal.
+func (self *AudioInfo) InputLayout() (ChannelLayout, error) {
+	return ParseChannelLayout(self.InputChannels)
+}
+
+// OutputLayout returns the channel layout being output to the speakers.
+func (self *AudioInfo) OutputLayout() (ChannelLayout, error) {
+	return ParseChannelLayout(self.OutputChannels)
+}
+
+// IsPCM returns whether the input signal is uncompressed PCM.
+func (self *AudioInfo) IsPCM() bool {
+	return strings.Contains(strings.ToUpper(self.InputFormat), `PCM`)
+}
+
+// IsObjectBased returns whether the input signal or listening mode is an object-based
+// format (Dolby Atmos or DTS:X).
+func (self *AudioInfo) IsObjectBased() bool {
+	for _, field := range []string{self.InputFormat, self.ListeningMode} {
+		field = strings.ToUpper(field)
+
+		if strings.Contains(field, `ATMOS`) || strings.Contains(field, `DTS:X`) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseAudioInfo decodes the comma-separated IFA value, which is in the order: input port,
+// input signal format, sampling frequency, input channels, listening mode, output channels
+// and (on newer models) output sampling frequency followed by further model-specific fields.
+func ParseAudioInfo(value string) (*AudioInfo, error) {
+	fields := splitInfo(value)
+
+	if len(fields) < 6 {
+		return nil, fmt.Errorf("Audio information %q has too few fields", value)
+	}
+
+	info := &AudioInfo{
+		InputPort:      fields[0],
+		InputFormat:    fields[1],
+		SampleRate:     parseSampleRate(fields[2]),
+		InputChannels:  fields[3],
+		ListeningMode:  fields[4],
+		OutputChannels: fields[5],
+		Raw:            value,
+	}
+
+	if len(fields) > 6 {
+		info.OutputSampleRate = parseSampleRate(fields[6])
+		info.Extra = fields[7:]
+	}
+
+	return info, nil
+}
+
+// Resolution is a video resolution and refresh rate, e.g.: "1920 x 1080p  60 Hz".
+type Resolution struct {
+	Width       int
+	Height      int
+	Interlaced  bool
+	RefreshRate float64
+	Raw         string
+}
+
+func (self Resolution) String() string {
+	if self.Width == 0 {
+		return self.Raw
+	}
+
+	scan := `p`
+
+	if self.Interlaced {
+		scan = `i`
+	}
+
+	if self.RefreshRate > 0 {
+		return fmt.Sprintf("%dx%d%s%g", self.Width, self.Height, scan, self.RefreshRate)
+	}
+
+	return fmt.Sprintf("%dx%d%s", self.Width, self.Height, scan)
+}
+
+// ParseResolution decodes a resolution description.  Values that aren't resolutions (such as
+// "---" when there is no signal) are returned with only the Raw field set.
+func ParseResolution(value string) Resolution {
+	resolution := Resolution{
+		Raw: value,
+	}
+
+	if match := resolutionPattern.FindStringSubmatch(strings.TrimSpace(value)); match != nil {
+		resolution.Width, _ = strconv.Atoi(match[1])
+		resolution.Height, _ = strconv.Atoi(match[2])
+		resolution.Interlaced = (match[3] == `i`)
+		resolution.RefreshRate, _ = strconv.ParseFloat(match[4], 64)
+	}
+
+	return resolution
+}
+
+// VideoInfo is a decoded IFV message.
+type VideoInfo struct {
+	InputPort         string
+	InputResolution   Resolution
+	InputColorSpace   string
+	InputColorDepth   string
+	OutputPort        string
+	OutputResolution  Resolution
+	OutputColorSpace  string
+	OutputColorDepth  string
+	PictureMode       string
+	InputDynamicRange string
+	Extra             []string
+	Raw               string
+}
+
+// String summarizes the signal, e.g. "HDMI 1 1920x1080p60 RGB 24bit -> HDMI Main 3840x2160p60".
+func (self *VideoInfo) String() string {
+	return joinFields(` -> `,
+		joinFields(` `, self.InputPort, self.InputResolution.String(), self.InputColorSpace, self.InputColorDepth, self.InputDynamicRange),
+		joinFields(` `, self.OutputPort, self.OutputResolution.String(), self.OutputColorSpace, self.OutputColorDepth))
+}
+
+// ParseVideoInfo decodes the comma-separated IFV value, which is in the order: input port,
+// input resolution, input color space, input color depth, output port, output resolution,
+// output color space, output color depth, picture mode and (on newer models) input HDR.
+func ParseVideoInfo(value string) (*VideoInfo, error) {
+	fields := splitInfo(value)
+
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("Video information %q has too few fields", value)
+	}
+
+	info := &VideoInfo{
+		InputPort:        fields[0],
+		InputResolution:  ParseResolution(fields[1]),
+		InputColorSpace:  fields[2],
+		InputColorDepth:  fields[3],
+		OutputPort:       fields[4],
+		OutputResolution: ParseResolution(fields[5]),
+		OutputColorSpace: fields[6],
+		OutputColorDepth: fields[7],
+		Raw:              value,
+	}
+
+	if len(fields) > 8 {
+		info.PictureMode = fields[8]
+	}
+
+	if len(fields) > 9 {
+		info.InputDynamicRange = fields[9]
+		info.Extra = fields[10:]
+	}
+
+	return info, nil
+}
+
+// splitInfo splits a comma-separated information string, which usually has a trailing comma.
+func splitInfo(value string) []string {
+	fields := strings.Split(strings.TrimSuffix(value, `,`), `,`)
+
+	for i, field := range fields {
+		fields[i] = strings.TrimSpace(field)
+	}
+
+	return fields
+}
+
+func parseSampleRate(value string) int {
+	if match := sampleRatePattern.FindStringSubmatch(strings.TrimSpace(value)); match != nil {
+		if khz, err := strconv.ParseFloat(match[1], 64); err == nil {
+			return int(khz * 1000)
+		}
+	}
+
+	return 0
+}
+
+func formatSampleRate(rate int) string {
+	if rate <= 0 {
+		return ``
+	}
+
+	return strconv.FormatFloat(float64(rate)/1000, 'f', -1, 64) + ` kHz`
+}
+
+// joinFields joins the non-empty fields with the given separator.
+func joinFields(separator string, fields ...string) string {
+	nonEmpty := make([]string, 0, len(fields))
+
+	for _, field := range fields {
+		if field != `` {
+			nonEmpty = append(nonEmpty, field)
+		}
+	}
+
+	return strings.Join(nonEmpty, separator)
+}
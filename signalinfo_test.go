@@ -0,0 +1,319 @@
+package onkyo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseChannelLayout(t *testing.T) {
+	tests := []struct {
+		value  string
+		layout ChannelLayout
+		total  int
+		err    bool
+	}{
+		{`2.0 ch`, ChannelLayout{Main: 2}, 2, false},
+		{`5.1ch`, ChannelLayout{Main: 5, LFE: 1}, 6, false},
+		{`7.1.4 ch`, ChannelLayout{Main: 7, LFE: 1, Height: 4}, 12, false},
+		{` 5.1.2 ch `, ChannelLayout{Main: 5, LFE: 1, Height: 2}, 8, false},
+		{``, ChannelLayout{}, 0, true},
+		{`Stereo`, ChannelLayout{}, 0, true},
+	}
+
+	for _, test := range tests {
+		layout, err := ParseChannelLayout(test.value)
+
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error state: %v", test.value, err)
+		} else if layout != test.layout {
+			t.Errorf("%q: expected %+v, got %+v", test.value, test.layout, layout)
+		} else if layout.Total() != test.total {
+			t.Errorf("%q: expected %d channels, got %d", test.value, test.total, layout.Total())
+		}
+	}
+}
+
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		value      string
+		resolution Resolution
+		str        string
+	}{
+		{
+			`1920 x 1080p  60 Hz`,
+			Resolution{Width: 1920, Height: 1080, RefreshRate: 60, Raw: `1920 x 1080p  60 Hz`},
+			`1920x1080p60`,
+		}, {
+			`3840 x 2160p  23.98 Hz`,
+			Resolution{Width: 3840, Height: 2160, RefreshRate: 23.98, Raw: `3840 x 2160p  23.98 Hz`},
+			`3840x2160p23.98`,
+		}, {
+			`1920 x 1080i  50 Hz`,
+			Resolution{Width: 1920, Height: 1080, Interlaced: true, RefreshRate: 50, Raw: `1920 x 1080i  50 Hz`},
+			`1920x1080i50`,
+		}, {
+			`720 x 480p`,
+			Resolution{Width: 720, Height: 480, Raw: `720 x 480p`},
+			`720x480p`,
+		}, {
+			`Unknown Resolution`,
+			Resolution{Raw: `Unknown Resolution`},
+			`Unknown Resolution`,
+		}, {
+			`Unknown`,
+			Resolution{Raw: `Unknown`},
+			`Unknown`,
+		}, {
+			`---`,
+			Resolution{Raw: `---`},
+			`---`,
+		}, {
+			``,
+			Resolution{},
+			``,
+		},
+	}
+
+	for _, test := range tests {
+		resolution := ParseResolution(test.value)
+
+		if resolution != test.resolution {
+			t.Errorf("%q: expected %+v, got %+v", test.value, test.resolution, resolution)
+		} else if resolution.String() != test.str {
+			t.Errorf("%q: expected %q, got %q", test.value, test.str, resolution.String())
+		}
+	}
+}
+
+func TestParseAudioInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		info   *AudioInfo
+		pcm    bool
+		object bool
+	}{
+		{
+			name:  `TX-NR509 PCM stereo`,
+			value: `HDMI 1,PCM,44.1 kHz,2.0 ch,Stereo,2.1 ch,`,
+			info: &AudioInfo{
+				InputPort:      `HDMI 1`,
+				InputFormat:    `PCM`,
+				SampleRate:     44100,
+				InputChannels:  `2.0 ch`,
+				ListeningMode:  `Stereo`,
+				OutputChannels: `2.1 ch`,
+			},
+			pcm: true,
+		}, {
+			name:  `TX-NR626 multichannel PCM`,
+			value: `HDMI 5,PCM,48 kHz,2.0 ch,All Ch Stereo,5.1 ch,`,
+			info: &AudioInfo{
+				InputPort:      `HDMI 5`,
+				InputFormat:    `PCM`,
+				SampleRate:     48000,
+				InputChannels:  `2.0 ch`,
+				ListeningMode:  `All Ch Stereo`,
+				OutputChannels: `5.1 ch`,
+			},
+			pcm: true,
+		}, {
+			name:  `TX-RZ810 Atmos with output frequency and extra fields`,
+			value: `HDMI 2,Dolby Atmos,48 kHz,7.1.4 ch,Dolby Atmos,5.1.2 ch,48 kHz,,0 ms,Normal,`,
+			info: &AudioInfo{
+				InputPort:        `HDMI 2`,
+				InputFormat:      `Dolby Atmos`,
+				SampleRate:       48000,
+				InputChannels:    `7.1.4 ch`,
+				ListeningMode:    `Dolby Atmos`,
+				OutputChannels:   `5.1.2 ch`,
+				OutputSampleRate: 48000,
+				Extra:            []string{``, `0 ms`, `Normal`},
+			},
+			object: true,
+		}, {
+			name:  `TX-NR686 analog input with empty signal fields`,
+			value: `TV/CD,,,,Stereo,2.1 ch,48 kHz,,0 ms,Normal,`,
+			info: &AudioInfo{
+				InputPort:        `TV/CD`,
+				ListeningMode:    `Stereo`,
+				OutputChannels:   `2.1 ch`,
+				OutputSampleRate: 48000,
+				Extra:            []string{``, `0 ms`, `Normal`},
+			},
+		}, {
+			name:  `TX-NR555 DTS:X upmix without a trailing comma`,
+			value: `HDMI 3,DTS-HD MSTR,96 kHz,5.1 ch,DTS:X,7.1 ch`,
+			info: &AudioInfo{
+				InputPort:      `HDMI 3`,
+				InputFormat:    `DTS-HD MSTR`,
+				SampleRate:     96000,
+				InputChannels:  `5.1 ch`,
+				ListeningMode:  `DTS:X`,
+				OutputChannels: `7.1 ch`,
+			},
+			object: true,
+		}, {
+			name:  `NET source with no signal`,
+			value: `NET,,,,Stereo,2.0 ch,`,
+			info: &AudioInfo{
+				InputPort:      `NET`,
+				ListeningMode:  `Stereo`,
+				OutputChannels: `2.0 ch`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		info, err := ParseAudioInfo(test.value)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		test.info.Raw = test.value
+
+		if !reflect.DeepEqual(info, test.info) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.info, info)
+		}
+
+		if info.IsPCM() != test.pcm {
+			t.Errorf("%s: expected IsPCM() to be %v", test.name, test.pcm)
+		}
+
+		if info.IsObjectBased() != test.object {
+			t.Errorf("%s: expected IsObjectBased() to be %v", test.name, test.object)
+		}
+	}
+
+	for _, value := range []string{``, `HDMI 1,PCM,48 kHz,`, `HDMI 1,PCM,48 kHz,2.0 ch,Stereo`} {
+		if _, err := ParseAudioInfo(value); err == nil {
+			t.Errorf("%q: expected an error for missing fields", value)
+		}
+	}
+}
+
+func TestParseVideoInfo(t *testing.T) {
+	hd := ParseResolution(`1920 x 1080p  60 Hz`)
+	uhd := ParseResolution(`3840 x 2160p  23.98 Hz`)
+
+	tests := []struct {
+		name  string
+		value string
+		info  *VideoInfo
+	}{
+		{
+			name:  `TX-NR626 without picture mode`,
+			value: `HDMI 2,1920 x 1080p  60 Hz,RGB,24bit,HDMI,1920 x 1080p  60 Hz,RGB,24bit,`,
+			info: &VideoInfo{
+				InputPort:        `HDMI 2`,
+				InputResolution:  hd,
+				InputColorSpace:  `RGB`,
+				InputColorDepth:  `24bit`,
+				OutputPort:       `HDMI`,
+				OutputResolution: hd,
+				OutputColorSpace: `RGB`,
+				OutputColorDepth: `24bit`,
+			},
+		}, {
+			name:  `TX-NR676 with picture mode`,
+			value: `HDMI 4,1920 x 1080p  60 Hz,RGB,24bit,HDMI Main,1920 x 1080p  60 Hz,RGB,24bit,Through,`,
+			info: &VideoInfo{
+				InputPort:        `HDMI 4`,
+				InputResolution:  hd,
+				InputColorSpace:  `RGB`,
+				InputColorDepth:  `24bit`,
+				OutputPort:       `HDMI Main`,
+				OutputResolution: hd,
+				OutputColorSpace: `RGB`,
+				OutputColorDepth: `24bit`,
+				PictureMode:      `Through`,
+			},
+		}, {
+			name:  `TX-RZ830 4K HDR with extra fields`,
+			value: `HDMI 1,3840 x 2160p  23.98 Hz,YCbCr 4:2:0,10bit,HDMI Main,3840 x 2160p  23.98 Hz,YCbCr 4:2:0,10bit,Through,HDR10,Direct,`,
+			info: &VideoInfo{
+				InputPort:         `HDMI 1`,
+				InputResolution:   uhd,
+				InputColorSpace:   `YCbCr 4:2:0`,
+				InputColorDepth:   `10bit`,
+				OutputPort:        `HDMI Main`,
+				OutputResolution:  uhd,
+				OutputColorSpace:  `YCbCr 4:2:0`,
+				OutputColorDepth:  `10bit`,
+				PictureMode:       `Through`,
+				InputDynamicRange: `HDR10`,
+				Extra:             []string{`Direct`},
+			},
+		}, {
+			name:  `TX-NR686 unknown resolution with empty fields`,
+			value: `HDMI 3,Unknown Resolution,,,HDMI Main,Unknown Resolution,,,Through,,`,
+			info: &VideoInfo{
+				InputPort:        `HDMI 3`,
+				InputResolution:  Resolution{Raw: `Unknown Resolution`},
+				OutputPort:       `HDMI Main`,
+				OutputResolution: Resolution{Raw: `Unknown Resolution`},
+				PictureMode:      `Through`,
+				Extra:            []string{},
+			},
+		}, {
+			name:  `no signal`,
+			value: `---,---,,,---,---,,,`,
+			info: &VideoInfo{
+				InputPort:        `---`,
+				InputResolution:  Resolution{Raw: `---`},
+				OutputPort:       `---`,
+				OutputResolution: Resolution{Raw: `---`},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		info, err := ParseVideoInfo(test.value)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		test.info.Raw = test.value
+
+		if !reflect.DeepEqual(info, test.info) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.info, info)
+		}
+	}
+
+	for _, value := range []string{``, `HDMI 1,1920 x 1080p  60 Hz,RGB,24bit,HDMI,`} {
+		if _, err := ParseVideoInfo(value); err == nil {
+			t.Errorf("%q: expected an error for missing fields", value)
+		}
+	}
+}
+
+func TestSignalInfoString(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{`HDMI 1,PCM,44.1 kHz,2.0 ch,Stereo,2.1 ch,`, `HDMI 1 PCM 44.1 kHz 2.0 ch -> Stereo 2.1 ch`},
+		{`TV/CD,,,,Stereo,2.1 ch,48 kHz,,0 ms,Normal,`, `TV/CD -> Stereo 2.1 ch`},
+	}
+
+	for _, test := range tests {
+		if info, err := ParseAudioInfo(test.value); err != nil {
+			t.Errorf("%q: %v", test.value, err)
+		} else if info.String() != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.value, test.expected, info.String())
+		}
+	}
+
+	video := `HDMI 1,3840 x 2160p  23.98 Hz,YCbCr 4:2:0,10bit,HDMI Main,3840 x 2160p  23.98 Hz,YCbCr 4:2:0,10bit,Through,HDR10,`
+	expected := `HDMI 1 3840x2160p23.98 YCbCr 4:2:0 10bit HDR10 -> HDMI Main 3840x2160p23.98 YCbCr 4:2:0 10bit`
+
+	if info, err := ParseVideoInfo(video); err != nil {
+		t.Error(err)
+	} else if info.String() != expected {
+		t.Errorf("expected %q, got %q", expected, info.String())
+	}
+}
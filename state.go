@@ -0,0 +1,64 @@
+package onkyo
+
+import (
+	"sync"
+	"time"
+)
+
+// State holds the most recent message received for each command code.
+type State struct {
+	lock    sync.RWMutex
+	values  map[string]Message
+	updated map[string]time.Time
+}
+
+func NewState() *State {
+	return &State{
+		values:  make(map[string]Message),
+		updated: make(map[string]time.Time),
+	}
+}
+
+// Set records the given message, returning whether it differs from the previously-stored
+// value for the same code.
+func (self *State) Set(message Message) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	code := message.Code()
+	last, ok := self.values[code]
+
+	self.values[code] = message
+	self.updated[code] = time.Now()
+
+	return !ok || last != message
+}
+
+func (self *State) Get(code string) (Message, bool) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	message, ok := self.values[code]
+	return message, ok
+}
+
+func (self *State) Updated(code string) time.Time {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.updated[code]
+}
+
+// Messages returns a copy of all stored messages, keyed on command code.
+func (self *State) Messages() map[string]Message {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	messages := make(map[string]Message)
+
+	for code, message := range self.values {
+		messages[code] = message
+	}
+
+	return messages
+}
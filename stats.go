@@ -0,0 +1,62 @@
+package onkyo
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var discoveryResponders uint64
+
+// DiscoveryResponders returns the total number of devices that have responded to discovery
+// requests made by this process.
+func DiscoveryResponders() uint64 {
+	return atomic.LoadUint64(&discoveryResponders)
+}
+
+// Stats tracks counters describing the health of the connection to a device.
+type Stats struct {
+	lock           sync.Mutex
+	received       map[string]uint64
+	sendErrors     uint64
+	decodeFailures uint64
+	reconnects     uint64
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		received: make(map[string]uint64),
+	}
+}
+
+// Received returns the number of messages received from the device, keyed on command code.
+func (self *Stats) Received() map[string]uint64 {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	received := make(map[string]uint64)
+
+	for code, count := range self.received {
+		received[code] = count
+	}
+
+	return received
+}
+
+func (self *Stats) SendErrors() uint64 {
+	return atomic.LoadUint64(&self.sendErrors)
+}
+
+func (self *Stats) DecodeFailures() uint64 {
+	return atomic.LoadUint64(&self.decodeFailures)
+}
+
+func (self *Stats) Reconnects() uint64 {
+	return atomic.LoadUint64(&self.reconnects)
+}
+
+func (self *Stats) messageReceived(code string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.received[code] += 1
+}
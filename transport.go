@@ -0,0 +1,234 @@
+package onkyo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const DEFAULT_DIAL_TIMEOUT = time.Duration(5) * time.Second
+
+var ErrCannotRedial = errors.New(`Transport cannot be re-established`)
+
+// Transport carries ISCP messages (e.g.: "!1PWR01") to and from a device.
+type Transport interface {
+	// Dial establishes the connection, replacing the existing one if already connected.
+	Dial() error
+
+	// ReadFrame blocks until a message has been received.  A *DecodeError is returned for
+	// malformed data; the transport remains usable afterwards.
+	ReadFrame() (Message, error)
+
+	WriteFrame(message Message) error
+	Close() error
+}
+
+// DecodeError describes data received from a device that could not be decoded.
+type DecodeError struct {
+	Err error
+}
+
+func (self *DecodeError) Error() string {
+	return self.Err.Error()
+}
+
+// Framing describes how messages are delimited on the wire.
+type Framing int
+
+const (
+	// FramingEISCP wraps each message in an eISCP packet, as used over the network.
+	FramingEISCP Framing = iota
+
+	// FramingISCP sends bare messages terminated by CR (or EOF from the device), as used
+	// over RS-232.
+	FramingISCP
+)
+
+// StreamTransport frames messages over any byte stream, which is obtained (and re-obtained
+// when reconnecting) by calling a dialer function.
+type StreamTransport struct {
+	framing Framing
+	dialer  func() (io.ReadWriteCloser, error)
+	lock    sync.RWMutex
+	rw      io.ReadWriteCloser
+	reader  *bufio.Reader
+}
+
+func NewStreamTransport(framing Framing, dialer func() (io.ReadWriteCloser, error)) *StreamTransport {
+	return &StreamTransport{
+		framing: framing,
+		dialer:  dialer,
+	}
+}
+
+// NewTCPTransport returns a transport that connects to an eISCP device at the given address.
+func NewTCPTransport(address string) *StreamTransport {
+	return NewStreamTransport(FramingEISCP, func() (io.ReadWriteCloser, error) {
+		return net.DialTimeout(`tcp`, address, DEFAULT_DIAL_TIMEOUT)
+	})
+}
+
+// NewConnTransport returns a transport for an existing connection.  Since the connection
+// was established elsewhere, it can't be re-established once lost.
+func NewConnTransport(rw io.ReadWriteCloser, framing Framing) *StreamTransport {
+	used := false
+
+	return NewStreamTransport(framing, func() (io.ReadWriteCloser, error) {
+		if used {
+			return nil, ErrCannotRedial
+		}
+
+		used = true
+		return rw, nil
+	})
+}
+
+func (self *StreamTransport) Dial() error {
+	if rw, err := self.dialer(); err == nil {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+
+		if self.rw != nil {
+			self.rw.Close()
+		}
+
+		self.rw = rw
+		self.reader = bufio.NewReaderSize(rw, maxPacketSize)
+		return nil
+	} else {
+		return err
+	}
+}
+
+func (self *StreamTransport) ReadFrame() (Message, error) {
+	self.lock.RLock()
+	reader := self.reader
+	self.lock.RUnlock()
+
+	if reader == nil {
+		return ``, fmt.Errorf("Transport is not connected")
+	}
+
+	switch self.framing {
+	case FramingISCP:
+		return readISCPFrame(reader)
+	default:
+		return readEISCPFrame(reader)
+	}
+}
+
+func (self *StreamTransport) WriteFrame(message Message) error {
+	self.lock.RLock()
+	rw := self.rw
+	self.lock.RUnlock()
+
+	if rw == nil {
+		return fmt.Errorf("Transport is not connected")
+	}
+
+	var data []byte
+
+	switch self.framing {
+	case FramingISCP:
+		data = []byte(string(message) + iscpTerminator)
+	default:
+		if len(message) < 2 || message[0] != '!' {
+			return fmt.Errorf("Malformed message %q", message)
+		}
+
+		data = encodePacket(string(message[2:]), DeviceCategory(message[1])).bytes()
+	}
+
+	_, err := rw.Write(data)
+	return err
+}
+
+func (self *StreamTransport) Close() error {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if self.rw != nil {
+		return self.rw.Close()
+	}
+
+	return nil
+}
+
+// readEISCPFrame reads the next eISCP packet, skipping over any garbage preceding it.
+func readEISCPFrame(reader *bufio.Reader) (Message, error) {
+	skipped := 0
+
+	for {
+		if peek, err := reader.Peek(len(magic)); err == nil {
+			if string(peek) == magic {
+				break
+			}
+
+			reader.Discard(1)
+			skipped += 1
+		} else {
+			return ``, err
+		}
+	}
+
+	if skipped > 0 {
+		return ``, &DecodeError{fmt.Errorf("Skipped %d bytes preceding packet", skipped)}
+	}
+
+	header := make([]byte, headerSize)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return ``, err
+	}
+
+	if err := packet(header).validateHeader(); err != nil {
+		return ``, &DecodeError{err}
+	} else if packet(header).messageLen() > maxPacketSize {
+		return ``, &DecodeError{fmt.Errorf("Message too long (%d bytes)", packet(header).messageLen())}
+	}
+
+	pkt := make([]byte, int(packet(header).headerSize())+packet(header).messageLen())
+	copy(pkt, header)
+
+	if _, err := io.ReadFull(reader, pkt[headerSize:]); err != nil {
+		return ``, err
+	}
+
+	return packet(pkt).Message(), nil
+}
+
+// readISCPFrame reads up to the next EOF, CR or LF character, skipping empty frames.
+func readISCPFrame(reader *bufio.Reader) (Message, error) {
+	frame := make([]byte, 0)
+
+	for {
+		if b, err := reader.ReadByte(); err == nil {
+			switch b {
+			case iscpEOF, '\r', '\n':
+				if len(frame) == 0 {
+					continue
+				}
+
+				// discard line noise preceding the start character
+				if i := bytes.IndexByte(frame, '!'); i > 0 {
+					frame = frame[i:]
+				}
+
+				if frame[0] != '!' || len(frame) < 5 {
+					return ``, &DecodeError{fmt.Errorf("Malformed message %q", frame)}
+				}
+
+				return Message(frame), nil
+			default:
+				frame = append(frame, b)
+			}
+		} else {
+			return ``, err
+		}
+	}
+}
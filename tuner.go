@@ -0,0 +1,309 @@
+package onkyo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MaxPreset = 40
+
+type Band string
+
+const (
+	BandFM Band = `FM`
+	BandAM Band = `AM`
+)
+
+// the input selector values for each band
+var bandInputs = map[Band]string{
+	BandFM: `24`,
+	BandAM: `25`,
+}
+
+// PTYNames are the RDS programme types, indexed by PTY number.
+var PTYNames = []string{
+	`None`, `News`, `Current Affairs`, `Information`, `Sport`, `Education`, `Drama`, `Culture`,
+	`Science`, `Varied`, `Pop Music`, `Rock Music`, `Easy Listening`, `Light Classical`,
+	`Serious Classical`, `Other Music`, `Weather`, `Finance`, `Children's Programmes`,
+	`Social Affairs`, `Religion`, `Phone-In`, `Travel`, `Leisure`, `Jazz Music`, `Country Music`,
+	`National Music`, `Oldies Music`, `Folk Music`, `Documentary`, `Alarm Test`,
+}
+
+// Frequency is a tuner frequency; in MHz for FM and kHz for AM.
+type Frequency struct {
+	Band  Band
+	Value float64
+}
+
+func (self Frequency) String() string {
+	switch self.Band {
+	case BandFM:
+		return fmt.Sprintf("%.2f MHz", self.Value)
+	default:
+		return fmt.Sprintf("%.0f kHz", self.Value)
+	}
+}
+
+// Validate returns an error if the frequency is outside of the band or not on a valid step.
+func (self Frequency) Validate() error {
+	switch self.Band {
+	case BandFM:
+		if self.Value < 76 || self.Value > 108 {
+			return fmt.Errorf("FM frequency must be between 76.00 and 108.00 MHz")
+		} else if steps := self.Value / 0.05; math.Abs(steps-math.Round(steps)) > 0.001 {
+			return fmt.Errorf("FM frequency must be a multiple of 0.05 MHz")
+		}
+	case BandAM:
+		if self.Value < 522 || self.Value > 1710 {
+			return fmt.Errorf("AM frequency must be between 522 and 1710 kHz")
+		} else if self.Value != math.Trunc(self.Value) {
+			return fmt.Errorf("AM frequency must be a whole number of kHz")
+		}
+	default:
+		return fmt.Errorf("Unknown band %q", self.Band)
+	}
+
+	return nil
+}
+
+// Encode returns the frequency as a TUN value ("nnnnn").
+func (self Frequency) Encode() (string, error) {
+	if err := self.Validate(); err != nil {
+		return ``, err
+	}
+
+	switch self.Band {
+	case BandFM:
+		return fmt.Sprintf("%05d", int(math.Round(self.Value*100))), nil
+	default:
+		return fmt.Sprintf("%05d", int(self.Value)), nil
+	}
+}
+
+// ParseBand converts a band name into a Band.
+func ParseBand(name string) (Band, error) {
+	switch band := Band(strings.ToUpper(name)); band {
+	case BandFM, BandAM:
+		return band, nil
+	default:
+		return ``, fmt.Errorf("Unknown band %q", name)
+	}
+}
+
+// ParseFrequency decodes a TUN value.  FM frequencies are sent in hundredths of a MHz and AM
+// frequencies in kHz; since the valid ranges don't overlap, the band is inferred from the value.
+func ParseFrequency(value string) (Frequency, error) {
+	if v, err := strconv.Atoi(value); err == nil {
+		if v >= 7600 {
+			return Frequency{
+				Band:  BandFM,
+				Value: float64(v) / 100,
+			}, nil
+		} else {
+			return Frequency{
+				Band:  BandAM,
+				Value: float64(v),
+			}, nil
+		}
+	} else {
+		return Frequency{}, fmt.Errorf("Malformed frequency %q", value)
+	}
+}
+
+// DecodeDisplayText decodes the hex-encoded text shown on the front panel display (FLD).
+func DecodeDisplayText(value string) (string, error) {
+	if data, err := hex.DecodeString(value); err == nil {
+		return strings.TrimSpace(string(data)), nil
+	} else {
+		return ``, err
+	}
+}
+
+// PTYNumber returns the number of the named RDS programme type.
+func PTYNumber(name string) (int, error) {
+	for i, pty := range PTYNames {
+		if strings.EqualFold(pty, strings.TrimSpace(name)) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown programme type %q", name)
+}
+
+type RDSInfo struct {
+	Text    string
+	PTY     int
+	PTYName string
+}
+
+// Tuner provides typed access to the AM/FM tuner of a device.
+type Tuner struct {
+	Timeout time.Duration
+	device  *Device
+}
+
+func NewTuner(device *Device, timeout time.Duration) *Tuner {
+	return &Tuner{
+		Timeout: timeout,
+		device:  device,
+	}
+}
+
+// Tune switches to the given band and tunes to the given frequency.
+func (self *Tuner) Tune(band Band, value float64) (Frequency, error) {
+	freq := Frequency{
+		Band:  band,
+		Value: value,
+	}
+
+	if encoded, err := freq.Encode(); err == nil {
+		if _, err := self.device.Request(self.Timeout, `SLI`, bandInputs[band]); err != nil {
+			return Frequency{}, err
+		}
+
+		if message, err := self.device.Request(self.Timeout, `TUN`, encoded); err == nil {
+			return ParseFrequency(message.Value())
+		} else {
+			return Frequency{}, err
+		}
+	} else {
+		return Frequency{}, err
+	}
+}
+
+// Frequency returns the frequency the tuner is currently set to.
+func (self *Tuner) Frequency() (Frequency, error) {
+	if message, err := self.device.Query(self.Timeout, `TUN`); err == nil {
+		return ParseFrequency(message.Value())
+	} else {
+		return Frequency{}, err
+	}
+}
+
+// Preset recalls the given preset, returning the frequency it is tuned to.
+func (self *Tuner) Preset(n int) (Frequency, error) {
+	if err := validatePreset(n); err != nil {
+		return Frequency{}, err
+	}
+
+	sub := self.device.Subscribe()
+	defer self.device.Unsubscribe(sub)
+
+	if err := self.device.Send(`PRS`, fmt.Sprintf("%02X", n)); err != nil {
+		return Frequency{}, err
+	}
+
+	if message, err := self.waitFor(sub, `TUN`); err == nil {
+		return ParseFrequency(message.Value())
+	} else {
+		return Frequency{}, err
+	}
+}
+
+// CurrentPreset returns the number of the selected preset, or 0 if the tuner isn't on one.
+func (self *Tuner) CurrentPreset() (int, error) {
+	if message, err := self.device.Query(self.Timeout, `PRS`); err == nil {
+		if message.Value() == `` {
+			return 0, nil
+		}
+
+		if v, err := strconv.ParseInt(message.Value(), 16, 32); err == nil {
+			return int(v), nil
+		} else {
+			return 0, fmt.Errorf("Malformed preset %q", message.Value())
+		}
+	} else {
+		return 0, err
+	}
+}
+
+// StorePreset saves the current frequency to the given preset.
+func (self *Tuner) StorePreset(n int) error {
+	if err := validatePreset(n); err != nil {
+		return err
+	}
+
+	return self.device.Send(`PRM`, fmt.Sprintf("%02X", n))
+}
+
+// ScanPTY starts scanning for a station broadcasting the given RDS programme type.
+func (self *Tuner) ScanPTY(pty int) error {
+	if pty < 0 || pty >= len(PTYNames) {
+		return fmt.Errorf("Programme type must be between 0 and %d", len(PTYNames)-1)
+	}
+
+	return self.device.Send(`PTS`, fmt.Sprintf("%02X", pty))
+}
+
+// ScanTP starts scanning for a station broadcasting traffic programmes.
+func (self *Tuner) ScanTP() error {
+	return self.device.Send(`TPS`)
+}
+
+// RDS switches the front panel display to each kind of RDS information in turn and decodes
+// what the device shows.
+func (self *Tuner) RDS() (*RDSInfo, error) {
+	info := &RDSInfo{}
+
+	if text, err := self.display(`00`); err == nil {
+		info.Text = text
+	} else {
+		return nil, err
+	}
+
+	if text, err := self.display(`01`); err == nil {
+		info.PTYName = text
+
+		if pty, err := PTYNumber(text); err == nil {
+			info.PTY = pty
+		}
+	} else {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (self *Tuner) display(mode string) (string, error) {
+	sub := self.device.Subscribe()
+	defer self.device.Unsubscribe(sub)
+
+	if err := self.device.Send(`RDS`, mode); err != nil {
+		return ``, err
+	}
+
+	if message, err := self.waitFor(sub, `FLD`); err == nil {
+		return DecodeDisplayText(message.Value())
+	} else {
+		return ``, err
+	}
+}
+
+func (self *Tuner) waitFor(sub chan Message, code string) (Message, error) {
+	deadline := time.After(self.Timeout)
+
+	for {
+		select {
+		case message, ok := <-sub:
+			if !ok {
+				return ``, fmt.Errorf("Connection closed")
+			} else if message.Code() == code {
+				return message, nil
+			}
+		case <-deadline:
+			return ``, ErrResponseTimeout
+		}
+	}
+}
+
+func validatePreset(n int) error {
+	if n < 1 || n > MaxPreset {
+		return fmt.Errorf("Preset must be between 1 and %d", MaxPreset)
+	}
+
+	return nil
+}
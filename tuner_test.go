@@ -0,0 +1,85 @@
+package onkyo
+
+import (
+	"testing"
+)
+
+func TestFrequencyRoundTrip(t *testing.T) {
+	tests := []struct {
+		frequency Frequency
+		encoded   string
+		str       string
+	}{
+		{Frequency{BandFM, 76}, `07600`, `76.00 MHz`},
+		{Frequency{BandFM, 87.5}, `08750`, `87.50 MHz`},
+		{Frequency{BandFM, 98.1}, `09810`, `98.10 MHz`},
+		{Frequency{BandFM, 101.05}, `10105`, `101.05 MHz`},
+		{Frequency{BandFM, 107.95}, `10795`, `107.95 MHz`},
+		{Frequency{BandFM, 108}, `10800`, `108.00 MHz`},
+		{Frequency{BandAM, 522}, `00522`, `522 kHz`},
+		{Frequency{BandAM, 1000}, `01000`, `1000 kHz`},
+		{Frequency{BandAM, 1710}, `01710`, `1710 kHz`},
+	}
+
+	for _, test := range tests {
+		encoded, err := test.frequency.Encode()
+
+		if err != nil {
+			t.Errorf("%v: %v", test.frequency, err)
+			continue
+		} else if encoded != test.encoded {
+			t.Errorf("%v: expected %s, got %s", test.frequency, test.encoded, encoded)
+		}
+
+		if decoded, err := ParseFrequency(encoded); err != nil {
+			t.Errorf("%s: %v", encoded, err)
+		} else if decoded != test.frequency {
+			t.Errorf("%s: expected %+v, got %+v", encoded, test.frequency, decoded)
+		} else if decoded.String() != test.str {
+			t.Errorf("%s: expected %q, got %q", encoded, test.str, decoded.String())
+		}
+	}
+}
+
+func TestFrequencyInvalid(t *testing.T) {
+	for _, frequency := range []Frequency{
+		{BandFM, 75.95},
+		{BandFM, 108.05},
+		{BandFM, 98.13},
+		{BandAM, 521},
+		{BandAM, 1711},
+		{BandAM, 999.5},
+		{`SW`, 9500},
+	} {
+		if encoded, err := frequency.Encode(); err == nil {
+			t.Errorf("%+v: expected an error, got %s", frequency, encoded)
+		}
+	}
+
+	for _, value := range []string{``, `FM`, `98.1`} {
+		if frequency, err := ParseFrequency(value); err == nil {
+			t.Errorf("%q: expected an error, got %+v", value, frequency)
+		}
+	}
+}
+
+func TestParseBand(t *testing.T) {
+	tests := []struct {
+		name string
+		band Band
+		err  bool
+	}{
+		{`fm`, BandFM, false},
+		{`AM`, BandAM, false},
+		{`sw`, ``, true},
+		{``, ``, true},
+	}
+
+	for _, test := range tests {
+		if band, err := ParseBand(test.name); (err != nil) != test.err {
+			t.Errorf("%q: unexpected error state: %v", test.name, err)
+		} else if band != test.band {
+			t.Errorf("%q: expected %q, got %q", test.name, test.band, band)
+		}
+	}
+}